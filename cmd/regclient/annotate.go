@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+var annotateCommand = cli.Command{
+	Name:      "annotate",
+	Usage:     "Adds, updates or removes OCI annotations of an image and pushes it under the same tag",
+	ArgsUsage: "repository:tag key=value...",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "remove",
+			Usage: "Remove the annotation with this key",
+		},
+		cli.BoolFlag{
+			Name:  "delete-old",
+			Usage: "Delete the previous manifest after retagging, unless other tags still point at it",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Only show the digest the annotated manifest would have, and the deletion plan of --delete-old",
+		},
+	},
+	Action: instrumented("annotate", func(c *cli.Context) error {
+		if c.NArg() < 1 || (c.NArg() == 1 && len(c.StringSlice("remove")) == 0) {
+			return cli.NewExitError("You must specify an image and at least one annotation", 1)
+		}
+		repo, tag, err := registry.ParseReference(c.Args().First())
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		set := make(map[string]string)
+		for _, arg := range c.Args().Tail() {
+			kv := strings.SplitN(arg, "=", 2)
+			if len(kv) != 2 || kv[0] == "" {
+				return cli.NewExitError(fmt.Sprintf("Annotation '%s' must be in the form key=value", arg), 1)
+			}
+			set[kv[0]] = kv[1]
+		}
+
+		r := init_registry(c)
+		old, err := r.GetManifest(cmdctx, repo, tag)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		updated, err := old.WithAnnotations(set, c.StringSlice("remove"))
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		dryrun := c.Bool("dry-run")
+		if !dryrun {
+			if _, err := r.PutManifest(cmdctx, repo, tag, updated); err != nil {
+				return cli.NewExitError(fmt.Sprintf("Unable to push annotated manifest: %v", err), 1)
+			}
+		}
+		fmt.Fprintf(stdout, "%s:%s %s -> %s\n", repo, tag, old.Digest, updated.Digest)
+
+		if c.Bool("delete-old") && old.Digest != updated.Digest {
+			//Once pushed, the tag points at the annotated manifest
+			previous := &registry.DockerImage{Name: repo, ContentDigest: old.Digest}
+			if dryrun {
+				previous.Tag = tag
+			}
+			if failed, err := delete_replaced(c, r, []*registry.DockerImage{previous}, dryrun); err != nil {
+				return err
+			} else if failed > 0 {
+				return cli.NewExitError(fmt.Sprintf("Unable to delete previous manifest %s", old.Digest), 1)
+			}
+		}
+		return nil
+	}),
+}
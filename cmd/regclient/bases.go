@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+const (
+	annotationBaseName   = "org.opencontainers.image.base.name"
+	annotationBaseDigest = "org.opencontainers.image.base.digest"
+)
+
+// layeredImage is an image reference together with its layers, base first
+type layeredImage struct {
+	Repo        string
+	Ref         string
+	Layers      []registry.Descriptor
+	Annotations map[string]string
+}
+
+// fetch_layered resolves the layers of every tag in repos, indexes are skipped
+func fetch_layered(r *registry.DockerRegistry, repos []string) []*layeredImage {
+	var imgs []*layeredImage
+	for _, repo := range repos {
+		tags, err := r.Tags(cmdctx, repo)
+		if err != nil {
+			record_error()
+			log.Printf("Unable to get tags of %s: %v", repo, err)
+			continue
+		}
+		for _, tag := range tags {
+			m, err := r.GetManifest(cmdctx, repo, tag)
+			if err != nil {
+				record_error()
+				log.Printf("Unable to get manifest of %s:%s: %v", repo, tag, err)
+				continue
+			}
+			layers, _ := m.Layers()
+			if len(layers) == 0 {
+				continue
+			}
+			annotations, _ := m.Annotations()
+			imgs = append(imgs, &layeredImage{repo, repo + ":" + tag, layers, annotations})
+		}
+	}
+	return imgs
+}
+
+func has_layer_prefix(layers, prefix []registry.Descriptor) bool {
+	if len(prefix) > len(layers) {
+		return false
+	}
+	for i := range prefix {
+		if layers[i].Digest != prefix[i].Digest {
+			return false
+		}
+	}
+	return true
+}
+
+// closest_base returns the known base sharing the longest layer prefix with
+// img, or nil
+func closest_base(img *layeredImage, bases []*layeredImage) *layeredImage {
+	var best *layeredImage
+	for _, base := range bases {
+		if base.Ref == img.Ref || !has_layer_prefix(img.Layers, base.Layers) {
+			continue
+		}
+		if best == nil || len(base.Layers) > len(best.Layers) {
+			best = base
+		}
+	}
+	return best
+}
+
+// base_of names the base image of img: the known base sharing the longest
+// layer prefix, else the base image annotation, else the base layer digest
+func base_of(img *layeredImage, bases []*layeredImage) string {
+	if best := closest_base(img, bases); best != nil {
+		return best.Ref
+	}
+	if name := img.Annotations[annotationBaseName]; name != "" {
+		if digest := img.Annotations[annotationBaseDigest]; digest != "" {
+			return name + "@" + digest
+		}
+		return name
+	}
+	return "layer " + img.Layers[0].Digest
+}
+
+var baseImagesCommand = cli.Command{
+	Name:  "base-images",
+	Usage: "Reports which base images the images in the registry are built on",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "repo, r",
+			Usage: "Report on this repository (default: the whole catalog)",
+		},
+		cli.StringSliceFlag{
+			Name:  "base-repo",
+			Usage: "Repository containing base images, used to name bases by matching layers",
+		},
+		cli.StringSliceFlag{
+			Name:  "deprecated",
+			Usage: "Flag bases whose name contains this string as deprecated (eg alpine:3.12)",
+		},
+	},
+	Action: instrumented("base-images", func(c *cli.Context) error {
+		r := init_registry(c)
+		repos := c.StringSlice("repo")
+		if len(repos) == 0 {
+			var err error
+			if repos, err = r.Repos(cmdctx); err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+		}
+		bases := fetch_layered(r, c.StringSlice("base-repo"))
+
+		users := make(map[string][]string)
+		for _, img := range fetch_layered(r, repos) {
+			base := base_of(img, bases)
+			users[base] = append(users[base], img.Ref)
+		}
+
+		var names []string
+		for name := range users {
+			names = append(names, name)
+		}
+		//Most used bases first
+		sort.Slice(names, func(i, j int) bool {
+			if len(users[names[i]]) != len(users[names[j]]) {
+				return len(users[names[i]]) > len(users[names[j]])
+			}
+			return names[i] < names[j]
+		})
+		for _, name := range names {
+			flag := ""
+			for _, deprecated := range c.StringSlice("deprecated") {
+				if strings.Contains(name, deprecated) {
+					flag = " DEPRECATED"
+				}
+			}
+			fmt.Fprintf(stdout, "%s (%d images)%s\n", name, len(users[name]), flag)
+			sort.Strings(users[name])
+			for _, ref := range users[name] {
+				fmt.Fprintf(stdout, "  %s\n", ref)
+			}
+		}
+		return nil
+	}),
+}
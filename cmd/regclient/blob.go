@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+// download_blob writes the blob digest of repo to out, verifying its
+// content against the digest. It returns the number of bytes written.
+func download_blob(r *registry.DockerRegistry, repo, digest string, out io.Writer) (int64, error) {
+	var written int64
+	err := r.FetchBlob(cmdctx, repo, digest, func(content io.Reader, _ int64) error {
+		h := sha256.New()
+		var err error
+		written, err = io.Copy(io.MultiWriter(out, h), content)
+		if err != nil {
+			return err
+		}
+		if got := fmt.Sprintf("sha256:%x", h.Sum(nil)); got != digest {
+			return fmt.Errorf("Content of blob %s has the digest %s", digest, got)
+		}
+		return nil
+	})
+	return written, err
+}
+
+var blobCommand = cli.Command{
+	Name:  "blob",
+	Usage: "Works with individual blobs (layers and configs)",
+	Subcommands: []cli.Command{
+		{
+			Name:      "get",
+			Usage:     "Downloads a single layer or config blob, verifying its digest",
+			ArgsUsage: "repository sha256:...",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "output, o",
+					Usage: "Write the blob to this file, - for standard output",
+				},
+			},
+			Action: instrumented("blob get", func(c *cli.Context) error {
+				if c.NArg() != 2 {
+					return cli.NewExitError("You must specify the repository and the digest of the blob", 1)
+				}
+				repo, digest := c.Args().Get(0), c.Args().Get(1)
+				if !strings.HasPrefix(digest, "sha256:") {
+					return cli.NewExitError("The digest must be a sha256 digest (sha256:...)", 1)
+				}
+				path := c.String("output")
+				if path == "" {
+					return cli.NewExitError("You must specify the output file with -o, or - for standard output", 1)
+				}
+				r := init_registry(c)
+
+				if path == "-" {
+					if _, err := download_blob(r, repo, digest, os.Stdout); err != nil {
+						return cli.NewExitError(fmt.Sprintf("Unable to download %s: %v", digest, err), 1)
+					}
+					return nil
+				}
+				//The blob is only moved to path once its digest is verified,
+				//so an interrupted or corrupted download leaves nothing behind
+				tmp, err := os.CreateTemp(filepath.Dir(path), ".blob-")
+				if err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				defer os.Remove(tmp.Name())
+				size, err := download_blob(r, repo, digest, tmp)
+				if cerr := tmp.Close(); err == nil {
+					err = cerr
+				}
+				if err != nil {
+					return cli.NewExitError(fmt.Sprintf("Unable to download %s: %v", digest, err), 1)
+				}
+				if err := os.Rename(tmp.Name(), path); err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				fmt.Fprintf(stdout, "%s %s (%s)\n", digest, path, human_size(size))
+				return nil
+			}),
+		},
+	},
+}
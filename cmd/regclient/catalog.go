@@ -0,0 +1,131 @@
+package main
+
+import (
+	"hash/fnv"
+	"strings"
+	"sync"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+// namespace_of returns the first path component of repo, repositories at the
+// top level of the registry share the "" namespace
+func namespace_of(repo string) string {
+	if i := strings.Index(repo, "/"); i >= 0 {
+		return repo[:i]
+	}
+	return ""
+}
+
+// catalogWindow is how many repositories per worker walk_catalog processes
+// ahead of the oldest one whose results are still pending
+const catalogWindow = 8
+
+// walk_catalog calls fn for every repository in repos, or in the whole
+// catalog if repos is empty. The catalog is streamed page by page and the
+// repositories are sharded by namespace over --catalog-workers goroutines,
+// every namespace going to a single one, so fn is called concurrently for
+// repositories of different namespaces only. The function fn returns
+// handles the results of the repository, it is called from the calling
+// goroutine in catalog order and may be nil. Results of repositories done
+// early wait for the earlier ones, a few per worker at most, which keeps
+// output deterministic and memory bounded on registries with tens of
+// thousands of repositories.
+// It returns true if the whole catalog was walked.
+func walk_catalog(c *cli.Context, r *registry.DockerRegistry, repos []string, fn func(repo string) func()) (bool, error) {
+	complete := len(repos) == 0
+	workers := c.GlobalInt("catalog-workers")
+	if workers < 1 {
+		workers = 1
+	}
+	type job struct {
+		i    int
+		repo string
+	}
+	type result struct {
+		i    int
+		emit func()
+	}
+	//Every repository takes a slot of the window until its results are
+	//handled
+	window := make(chan struct{}, catalogWindow*workers)
+	shards := make([]chan job, workers)
+	for w := range shards {
+		shards[w] = make(chan job, catalogWindow)
+	}
+	var sent int
+	var catalogErr error
+	go func() {
+		defer func() {
+			for _, shard := range shards {
+				close(shard)
+			}
+		}()
+		send := func(repo string) error {
+			window <- struct{}{}
+			if err := cmdctx.Err(); err != nil {
+				return err
+			}
+			shards[shard_of(repo, workers)] <- job{sent, repo}
+			sent++
+			return nil
+		}
+		if !complete {
+			for _, repo := range repos {
+				if send(repo) != nil {
+					return
+				}
+			}
+			return
+		}
+		if err := r.EachRepo(cmdctx, send); err != nil && cmdctx.Err() == nil {
+			catalogErr = err
+		}
+	}()
+
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for _, shard := range shards {
+		wg.Add(1)
+		go func(shard chan job) {
+			defer wg.Done()
+			for j := range shard {
+				results <- result{j.i, fn(j.repo)}
+			}
+		}(shard)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	pending := make(map[int]func())
+	next := 0
+	for res := range results {
+		pending[res.i] = res.emit
+		for {
+			emit, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			if emit != nil {
+				emit()
+			}
+			next++
+			<-window
+		}
+	}
+	if catalogErr != nil {
+		return false, catalogErr
+	}
+	return complete && next == sent && cmdctx.Err() == nil, nil
+}
+
+// shard_of returns the worker of the namespace of repo
+func shard_of(repo string, workers int) int {
+	h := fnv.New32a()
+	h.Write([]byte(namespace_of(repo)))
+	return int(h.Sum32() % uint32(workers))
+}
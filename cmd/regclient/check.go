@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+// expectedRef is a reference to check, with the digest it should resolve
+// to if one was given
+type expectedRef struct {
+	Repo   string
+	Ref    string
+	Digest string
+}
+
+// parse_expected parses repository:tag, repository:tag@digest (as pinned by
+// deployment manifests) or repository@digest
+func parse_expected(s string) (expectedRef, error) {
+	var digest string
+	if i := strings.Index(s, "@"); i >= 0 {
+		s, digest = s[:i], s[i+1:]
+	}
+	if !strings.Contains(s, ":") && digest != "" {
+		return expectedRef{s, digest, digest}, nil
+	}
+	repo, tag, err := registry.ParseReference(s)
+	if err != nil {
+		return expectedRef{}, err
+	}
+	return expectedRef{repo, tag, digest}, nil
+}
+
+// read_expected reads one reference per line, empty lines and lines starting
+// with # are skipped
+func read_expected(r io.Reader) ([]expectedRef, error) {
+	var refs []expectedRef
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		ref, err := parse_expected(line)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid reference '%s': %v", line, err)
+		}
+		refs = append(refs, ref)
+	}
+	return refs, scanner.Err()
+}
+
+var checkCommand = cli.Command{
+	Name:      "check",
+	Usage:     "Verifies that references exist, with the expected digest where one is given",
+	ArgsUsage: "[repository:tag[@digest]...]",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "file, f",
+			Usage: "Read the references from this file, one per line (- for STDIN)",
+		},
+	},
+	Action: instrumented("check", func(c *cli.Context) error {
+		var refs []expectedRef
+		if path := c.String("file"); path != "" {
+			var in io.Reader = os.Stdin
+			if path != "-" {
+				f, err := os.Open(path)
+				if err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				defer f.Close()
+				in = f
+			}
+			var err error
+			if refs, err = read_expected(in); err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+		}
+		for _, arg := range c.Args() {
+			ref, err := parse_expected(arg)
+			if err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+			refs = append(refs, ref)
+		}
+		if len(refs) == 0 {
+			return cli.NewExitError("You must specify references or a --file", 1)
+		}
+
+		r := init_registry(c)
+		var missing, drifted, failed int
+		for _, ref := range refs {
+			name := ref.Repo + ":" + ref.Ref
+			if ref.Ref == ref.Digest {
+				name = ref.Repo + "@" + ref.Digest
+			}
+			digest, err := r.ManifestDigest(cmdctx, ref.Repo, ref.Ref)
+			switch {
+			case registry.IsNotFound(err):
+				missing++
+				fmt.Fprintf(stdout, "MISSING %s\n", name)
+			case err != nil:
+				failed++
+				record_error()
+				fmt.Fprintf(stdout, "ERROR   %s: %v\n", name, err)
+			case ref.Digest != "" && digest != ref.Digest:
+				drifted++
+				fmt.Fprintf(stdout, "DRIFT   %s is %s, expected %s\n", name, digest, ref.Digest)
+			default:
+				fmt.Fprintf(stdout, "OK      %s %s\n", name, digest)
+			}
+		}
+		fmt.Fprintf(stdout, "%d references checked, %d missing, %d drifted, %d failed\n", len(refs), missing, drifted, failed)
+		if missing+drifted+failed > 0 {
+			return cli.NewExitError("", 1)
+		}
+		return nil
+	}),
+}
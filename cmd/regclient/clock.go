@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// Clock tells the time to the age based filters, the maintenance windows
+// and everything else that records or compares against the current time
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time        { return time.Now() }
+func (systemClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// fixedClock stands still at the time given with --now, sleeping only
+// advances it, so runs are reproducible
+type fixedClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (f *fixedClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fixedClock) Sleep(d time.Duration) {
+	f.mu.Lock()
+	f.now = f.now.Add(d)
+	f.mu.Unlock()
+}
+
+var clock Clock = systemClock{}
+
+// fixed_clock reports whether the clock was fixed with --now. Such runs only
+// show what would happen at that time and never modify the registry.
+func fixed_clock() bool {
+	_, ok := clock.(*fixedClock)
+	return ok
+}
+
+// refuse_fixed_clock fails runs that would delete with --now, so they stop
+// before anything is planned or confirmed
+func refuse_fixed_clock(dryrun bool) error {
+	if fixed_clock() && !dryrun {
+		return cli.NewExitError("Refusing to delete with --now, use it with --dry-run or read-only commands", 1)
+	}
+	return nil
+}
+
+// init_clock fixes the clock at the time given with --now
+func init_clock(c *cli.Context) error {
+	now := c.GlobalString("now")
+	if now == "" {
+		return nil
+	}
+	t, err := parse_date(now)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Invalid --now: %v", err), 1)
+	}
+	clock = &fixedClock{now: t}
+	return nil
+}
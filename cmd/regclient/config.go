@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/urfave/cli"
+	"gopkg.in/yaml.v3"
+)
+
+// The config file maps command names to default flag values, flags given on
+// the command line or through the environment always take precedence:
+//
+//	global:
+//	  url: https://my.registry.com:5000
+//	images:
+//	  older-than: 90d
+//	  tag-exclude: release
+//	  repo: [webserver, backend-server]
+var config map[string]map[string]interface{}
+
+func default_config_path() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "docker-regclient", "config.yaml")
+}
+
+// load_config reads the file given with --config, or the default config
+// file if it exists
+func load_config(c *cli.Context) error {
+	path := c.GlobalString("config")
+	if path == "" {
+		path = default_config_path()
+		if _, err := os.Stat(path); err != nil {
+			return nil
+		}
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Unable to read config file: %v", err), 1)
+	}
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return cli.NewExitError(fmt.Sprintf("Unable to parse config file %s: %v", path, err), 1)
+	}
+	return nil
+}
+
+// apply_config_defaults sets every flag of section that was not given on the
+// command line. Lists are applied element by element, so they work for
+// repeatable flags such as --repo.
+func apply_config_defaults(c *cli.Context, section string) error {
+	for name, value := range config[section] {
+		if c.IsSet(name) {
+			continue
+		}
+		values, ok := value.([]interface{})
+		if !ok {
+			values = []interface{}{value}
+		}
+		for _, v := range values {
+			if err := c.Set(name, fmt.Sprint(v)); err != nil {
+				return cli.NewExitError(fmt.Sprintf("Invalid config value %s.%s: %v", section, name, err), 1)
+			}
+		}
+	}
+	return nil
+}
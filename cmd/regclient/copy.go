@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+var copyCommand = cli.Command{
+	Name:      "copy",
+	Usage:     "Copies an image, verifying its digest before and after the transfer",
+	ArgsUsage: "source destination",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "dest-url",
+			Usage: "Copy to this registry instead of the one given with --url",
+		},
+		cli.StringFlag{
+			Name:   "dest-username",
+			Usage:  "Authenticate to --dest-url as this user",
+			EnvVar: "REGCLIENT_DEST_USERNAME",
+		},
+		cli.StringFlag{
+			Name:   "dest-password",
+			Usage:  "Password of --dest-username",
+			EnvVar: "REGCLIENT_DEST_PASSWORD",
+		},
+		cli.StringFlag{
+			Name:  "require-digest",
+			Usage: "Only copy if the source resolves to exactly this digest (eg sha256:...)",
+		},
+		cli.IntFlag{
+			Name:  "workers",
+			Usage: "Number of blobs transferred concurrently",
+			Value: 4,
+		},
+	},
+	Action: instrumented("copy", func(c *cli.Context) error {
+		if c.NArg() != 2 {
+			return cli.NewExitError("You must specify the source and destination images", 1)
+		}
+		required := c.String("require-digest")
+		if required != "" && !strings.HasPrefix(required, "sha256:") {
+			return cli.NewExitError("--require-digest must be a sha256 digest", 1)
+		}
+		srcrepo, srcref, err := registry.ParseReference(c.Args().Get(0))
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		dstrepo, dstref, err := registry.ParseReference(c.Args().Get(1))
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		src := init_registry(c)
+		dst := src
+		if url := c.String("dest-url"); url != "" {
+			dst = init_registry_at(c, url, c.String("dest-username"), c.String("dest-password"))
+		}
+		var copied, skipped int64
+		opts := registry.CopyOptions{
+			RequireDigest: required,
+			Workers:       c.Int("workers"),
+			OnBlob: func(blob registry.Descriptor, transferred bool) {
+				if transferred {
+					atomic.AddInt64(&copied, 1)
+				} else {
+					atomic.AddInt64(&skipped, 1)
+				}
+			},
+		}
+		digest, err := registry.CopyImage(cmdctx, src, srcrepo, srcref, dst, dstrepo, dstref, opts)
+		if err != nil {
+			record_error()
+			return cli.NewExitError(fmt.Sprintf("Copy failed: %v", err), 1)
+		}
+		fmt.Fprintf(stdout, "%s -> %s %s (%d blobs copied, %d already present)\n", c.Args().Get(0), c.Args().Get(1), digest, copied, skipped)
+		return nil
+	}),
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+// deletePlan orders the deletion of the selected images. Deleting a manifest
+// removes every tag of the repository pointing at it, so images sharing their
+// digest with a tag that was not selected are skipped, and images sharing a
+// digest with each other are deleted in a single step.
+type deletePlan struct {
+	//Steps delete one manifest each, together with all images of the step
+	Steps [][]*registry.DockerImage
+	//Skipped maps the images left alone to the unselected tags sharing
+	//their digest
+	Skipped map[*registry.DockerImage][]string
+	//Deleted and Failed hold the images deleted by run_delete_plan and
+	//those it failed to delete, nil until it ran. Images in neither were
+	//skipped as immutable, or left when the maintenance window closed or
+	//the run was interrupted.
+	Deleted map[*registry.DockerImage]bool
+	Failed  map[*registry.DockerImage]bool
+	//Forced maps the manifests (name@digest) of the steps added by
+	//force_shared to the unselected tags removed along with them
+	Forced map[string][]string
+}
+
+func (p *deletePlan) images() int {
+	var n int
+	for _, step := range p.Steps {
+		n += len(step)
+	}
+	return n
+}
+
+// plan_deletes resolves every tag of the repositories imgs are in and groups
+// imgs by digest. All shared digests are detected before anything is deleted,
+// and the steps deleting several tags at once are ordered last.
+func plan_deletes(r *registry.DockerRegistry, imgs []*registry.DockerImage) *deletePlan {
+	selected := make(map[string]bool)
+	for _, img := range imgs {
+		selected[img.Name+":"+img.Tag] = true
+	}
+
+	//Tags of every repository by digest, nil for repositories that could
+	//not be resolved completely
+	tagsbydigest := make(map[string]map[string][]string)
+	for _, img := range imgs {
+		if _, ok := tagsbydigest[img.Name]; ok {
+			continue
+		}
+		tags, err := r.Tags(cmdctx, img.Name)
+		if err != nil {
+			record_error()
+			log.Printf("Unable to get tags of %s, none of its images are deleted: %v", img.Name, err)
+			tagsbydigest[img.Name] = nil
+			continue
+		}
+		bydigest := make(map[string][]string)
+		for _, tag := range tags {
+			digest, err := r.ManifestDigest(cmdctx, img.Name, tag)
+			if err != nil {
+				record_error()
+				log.Printf("Unable to resolve %s:%s, none of the images of %s are deleted: %v", img.Name, tag, img.Name, err)
+				bydigest = nil
+				break
+			}
+			bydigest[digest] = append(bydigest[digest], tag)
+		}
+		tagsbydigest[img.Name] = bydigest
+	}
+
+	var order []string
+	groups := make(map[string][]*registry.DockerImage)
+	for _, img := range imgs {
+		if tagsbydigest[img.Name] == nil {
+			continue
+		}
+		key := img.Name + "@" + img.ContentDigest
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], img)
+	}
+
+	plan := &deletePlan{Skipped: make(map[*registry.DockerImage][]string)}
+	var shared [][]*registry.DockerImage
+	for _, key := range order {
+		group := groups[key]
+		var protected []string
+		for _, tag := range tagsbydigest[group[0].Name][group[0].ContentDigest] {
+			if !selected[group[0].Name+":"+tag] {
+				protected = append(protected, group[0].Name+":"+tag)
+			}
+		}
+		if len(protected) > 0 {
+			for _, img := range group {
+				plan.Skipped[img] = protected
+			}
+		} else if len(group) > 1 {
+			shared = append(shared, group)
+		} else {
+			plan.Steps = append(plan.Steps, group)
+		}
+	}
+	plan.Steps = append(plan.Steps, shared...)
+	return plan
+}
+
+func refs(imgs []*registry.DockerImage) string {
+	var names []string
+	for _, img := range imgs {
+		if img.Tag == "" {
+			names = append(names, img.Name+"@"+short_digest(img.ContentDigest))
+			continue
+		}
+		names = append(names, img.Name+":"+img.Tag)
+	}
+	return strings.Join(names, ", ")
+}
+
+// report_skipped explains every image the plan leaves alone, pointing to
+// --force-shared if the command has it
+func report_skipped(plan *deletePlan, forceable bool) {
+	var lines []string
+	for img, protected := range plan.Skipped {
+		lines = append(lines, fmt.Sprintf("Keeping %s:%s, its digest %s is also tagged %s", img.Name, img.Tag, img.ContentDigest, strings.Join(protected, ", ")))
+	}
+	sort.Strings(lines)
+	for _, line := range lines {
+		log.Print(line)
+	}
+	if len(lines) > 0 && forceable {
+		log.Printf("Use --force-shared to delete these %d images together with the tags sharing their digest", len(lines))
+	}
+}
+
+// force_shared adds the images plan skipped to its steps, deleting them
+// with the unselected tags sharing their digest, which are logged
+func force_shared(plan *deletePlan) {
+	var order []string
+	groups := make(map[string][]*registry.DockerImage)
+	others := make(map[string][]string)
+	for img, tags := range plan.Skipped {
+		key := img.Name + "@" + img.ContentDigest
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+			others[key] = tags
+		}
+		groups[key] = append(groups[key], img)
+	}
+	sort.Strings(order)
+	for _, key := range order {
+		group := groups[key]
+		sort.Slice(group, func(i, j int) bool { return group[i].Tag < group[j].Tag })
+		log.Printf("WARNING: deleting %s also removes %s, they share the digest %s", refs(group), strings.Join(others[key], ", "), group[0].ContentDigest)
+		plan.Steps = append(plan.Steps, group)
+		if plan.Forced == nil {
+			plan.Forced = make(map[string][]string)
+		}
+		plan.Forced[key] = others[key]
+	}
+	plan.Skipped = make(map[*registry.DockerImage][]string)
+}
+
+// print_delete_plan lists the steps of plan in the order they are run
+func print_delete_plan(plan *deletePlan) {
+	fmt.Fprintf(stdout, "Deletion plan, %d manifests removing %d tags:\n", len(plan.Steps), plan.images())
+	for i, step := range plan.Steps {
+		fmt.Fprintf(stdout, "%4d. %s@%s removes %s\n", i+1, step[0].Name, step[0].ContentDigest, refs(step))
+	}
+}
+
+// run_delete_plan deletes the manifest of every step and reports the result.
+// Registries that refuse to delete tagged manifests get all tags of a step
+// deleted first. It returns the number of images that were not deleted.
+func run_delete_plan(r *registry.DockerRegistry, plan *deletePlan) int {
+	failed := 0
+	plan.Deleted = make(map[*registry.DockerImage]bool)
+	plan.Failed = make(map[*registry.DockerImage]bool)
+	for i, step := range plan.Steps {
+		closed := maintenance != nil && !maintenance.open(clock.Now())
+		if closed || cmdctx.Err() != nil {
+			var left int
+			for _, step := range plan.Steps[i:] {
+				left += len(step)
+			}
+			if closed {
+				fmt.Fprintf(stdout, "The maintenance window closed, %d images were not deleted\n", left)
+			} else {
+				fmt.Fprintf(stdout, "Interrupted, %d images were not deleted\n", left)
+			}
+			return failed + left
+		}
+		fmt.Fprintf(stdout, "Deleting (%s): ", refs(step))
+		last := step[len(step)-1]
+		var err error
+		if r.Flavor() == registry.FlavorGCR || r.Flavor() == registry.FlavorQuay {
+			//The manifest can only be deleted once no tag points at it,
+			//including the unselected ones of a forced step
+			var tags []string
+			for _, ref := range plan.Forced[last.Name+"@"+last.ContentDigest] {
+				tags = append(tags, strings.TrimPrefix(ref, last.Name+":"))
+			}
+			for _, img := range step[:len(step)-1] {
+				tags = append(tags, img.Tag)
+			}
+			for _, tag := range tags {
+				if err = r.DeleteTag(cmdctx, last.Name, tag); err != nil && !registry.IsNotFound(err) {
+					break
+				}
+				err = nil
+			}
+		}
+		if err == nil {
+			err = r.DeleteImage(cmdctx, last)
+		}
+		if err == nil {
+			for _, img := range step {
+				record_deleted(img)
+				plan.Deleted[img] = true
+			}
+			fmt.Fprintf(stdout, "SUCCESS\n")
+		} else if registry.IsImmutable(err) {
+			record_immutable(err)
+			fmt.Fprintf(stdout, "SKIPPED, %v\n", err)
+		} else {
+			failed += len(step)
+			for _, img := range step {
+				record_delete_error(img)
+				plan.Failed[img] = true
+			}
+			fmt.Fprintln(stdout, err)
+		}
+	}
+	return failed
+}
+
+// delete_replaced deletes the previous manifests of images a command pushed
+// again under the same tag (annotate, migrate-schema1), going through the
+// same checks and plan as images --delete. Manifests other tags still point
+// at are kept. With dryrun the plan is only printed. It returns the number of
+// manifests that were not deleted.
+func delete_replaced(c *cli.Context, r *registry.DockerRegistry, imgs []*registry.DockerImage, dryrun bool) (int, error) {
+	if err := refuse_fixed_clock(dryrun); err != nil {
+		return 0, err
+	}
+	imgs = drop_pinned(r, imgs)
+	imgs = require_archived(c, imgs)
+	if err := refuse_mirrors(c, r, imgs); err != nil {
+		return 0, err
+	}
+	imgs = preflight_deletes(r, imgs, dryrun)
+	plan := plan_deletes(r, imgs)
+	report_skipped(plan, false)
+	if dryrun {
+		print_delete_plan(plan)
+		return 0, nil
+	}
+	if len(plan.Steps) == 0 {
+		return 0, nil
+	}
+	if err := wait_for_window(c); err != nil {
+		return 0, err
+	}
+	return run_delete_plan(r, plan), nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+)
+
+// fake_registry serves the tags of repos, which map every tag to the digest
+// of its manifest
+func fake_registry(t *testing.T, repos map[string]map[string]string) *registry.DockerRegistry {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+		path := strings.TrimPrefix(req.URL.Path, "/v2/")
+		switch {
+		case path == "":
+		case strings.HasSuffix(path, "/tags/list"):
+			var tags []string
+			for tag := range repos[strings.TrimSuffix(path, "/tags/list")] {
+				tags = append(tags, tag)
+			}
+			json.NewEncoder(w).Encode(map[string][]string{"tags": tags})
+		case strings.Contains(path, "/manifests/"):
+			i := strings.LastIndex(path, "/manifests/")
+			digest, ok := repos[path[:i]][path[i+len("/manifests/"):]]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Header().Set("Docker-Content-Digest", digest)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	r, err := registry.NewDockerRegistry(server.URL)
+	if err != nil {
+		t.Fatalf("Unable to connect to the fake registry: %v", err)
+	}
+	return r
+}
+
+// image_refs returns name:tag for every image
+func image_refs(imgs []*registry.DockerImage) []string {
+	var names []string
+	for _, img := range imgs {
+		names = append(names, img.Name+":"+img.Tag)
+	}
+	return names
+}
+
+func TestPlanDeletes(t *testing.T) {
+	r := fake_registry(t, map[string]map[string]string{
+		"app": {"1.0": "sha256:a", "1.1": "sha256:b", "1.1-old": "sha256:b", "2.0": "sha256:c", "latest": "sha256:c"},
+	})
+	tests := []struct {
+		selected []string
+		steps    [][]string
+		skipped  []string
+	}{
+		{[]string{"1.0"}, [][]string{{"app:1.0"}}, nil},
+		//Tags sharing the digest with an unselected tag are kept
+		{[]string{"1.0", "2.0"}, [][]string{{"app:1.0"}}, []string{"app:2.0"}},
+		//Tags sharing their digest go in a single step, ordered last
+		{[]string{"1.1", "1.0", "1.1-old"}, [][]string{{"app:1.0"}, {"app:1.1", "app:1.1-old"}}, nil},
+	}
+	for _, test := range tests {
+		var imgs []*registry.DockerImage
+		for _, tag := range test.selected {
+			digest, _ := r.ManifestDigest(cmdctx, "app", tag)
+			imgs = append(imgs, &registry.DockerImage{Name: "app", Tag: tag, ContentDigest: digest})
+		}
+		plan := plan_deletes(r, imgs)
+		var steps [][]string
+		for _, step := range plan.Steps {
+			steps = append(steps, image_refs(step))
+		}
+		if !reflect.DeepEqual(steps, test.steps) {
+			t.Errorf("plan_deletes(%v) steps = %v, want %v", test.selected, steps, test.steps)
+		}
+		var skipped []*registry.DockerImage
+		for img := range plan.Skipped {
+			skipped = append(skipped, img)
+		}
+		if got := image_refs(skipped); !reflect.DeepEqual(got, test.skipped) {
+			t.Errorf("plan_deletes(%v) skipped = %v, want %v", test.selected, got, test.skipped)
+		}
+	}
+}
+
+func TestForceShared(t *testing.T) {
+	shared := &registry.DockerImage{Name: "app", Tag: "2.0", ContentDigest: "sha256:c"}
+	alone := &registry.DockerImage{Name: "app", Tag: "1.0", ContentDigest: "sha256:a"}
+	plan := &deletePlan{
+		Steps:   [][]*registry.DockerImage{{alone}},
+		Skipped: map[*registry.DockerImage][]string{shared: {"app:latest"}},
+	}
+	force_shared(plan)
+	if len(plan.Skipped) != 0 {
+		t.Errorf("Images still skipped after force_shared: %v", plan.Skipped)
+	}
+	if len(plan.Steps) != 2 || plan.Steps[1][0] != shared {
+		t.Errorf("The skipped image isn't deleted last, steps are %v", plan.Steps)
+	}
+	if got := plan.Forced["app@sha256:c"]; !reflect.DeepEqual(got, []string{"app:latest"}) {
+		t.Errorf("Forced = %v, want the tags removed along with app:2.0", plan.Forced)
+	}
+	if plan.images() != 2 {
+		t.Errorf("images() = %d, want 2", plan.images())
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+// repo_manifests returns the images of repo, one per tag and one without a
+// tag for every untagged manifest. Registries able to list manifests are
+// asked directly, which includes untagged manifests, otherwise every tag is
+// resolved.
+func repo_manifests(r *registry.DockerRegistry, repo string) ([]*registry.DockerImage, error) {
+	if manifests, err := r.ListManifests(cmdctx, repo); err == nil {
+		var imgs []*registry.DockerImage
+		for _, m := range manifests {
+			tags := m.Tags
+			if len(tags) == 0 {
+				tags = []string{""}
+			}
+			for _, tag := range tags {
+				imgs = append(imgs, &registry.DockerImage{Name: repo, Tag: tag, ContentDigest: m.Digest, MediaType: m.MediaType, Size: m.Size, Created: m.Pushed})
+			}
+		}
+		return imgs, nil
+	} else if err != registry.ErrListingUnsupported {
+		return nil, err
+	}
+
+	tags, err := r.Tags(cmdctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	var imgs []*registry.DockerImage
+	for _, tag := range tags {
+		digest, err := r.ManifestDigest(cmdctx, repo, tag)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to resolve %s:%s: %v", repo, tag, err)
+		}
+		imgs = append(imgs, &registry.DockerImage{Name: repo, Tag: tag, ContentDigest: digest})
+	}
+	return imgs, nil
+}
+
+var deleteRepoCommand = cli.Command{
+	Name:      "delete-repo",
+	Usage:     "Deletes every manifest in a repository",
+	ArgsUsage: "repository...",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Only show which manifests would be deleted, exit with 2 if there are any",
+		},
+		cli.BoolFlag{
+			Name:  "yes",
+			Usage: "Do not prompt, when deleting images",
+		},
+		cli.BoolFlag{
+			Name:  "delete-empty-repos",
+			Usage: "Delete the repository itself afterwards (Harbor and GitLab only)",
+		},
+	},
+	Action: instrumented("delete-repo", func(c *cli.Context) error {
+		if c.NArg() == 0 {
+			return cli.NewExitError("You must specify at least one repository", 1)
+		}
+		if err := refuse_fixed_clock(c.Bool("dry-run")); err != nil {
+			return err
+		}
+		r := init_registry(c)
+
+		var imgs []*registry.DockerImage
+		for _, repo := range c.Args() {
+			repoimgs, err := repo_manifests(r, repo)
+			if err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+			imgs = append(imgs, repoimgs...)
+		}
+		imgs = drop_pinned(r, imgs)
+		imgs = require_archived(c, imgs)
+		if err := refuse_mirrors(c, r, imgs); err != nil {
+			return err
+		}
+		imgs = preflight_deletes(r, imgs, c.Bool("dry-run"))
+		//Every tag is deleted on its own where the registry needs it, the
+		//tags of a manifest are only kept together by the plan
+		plan := plan_deletes(r, imgs)
+		report_skipped(plan, false)
+		print_delete_plan(plan)
+		if c.Bool("dry-run") {
+			return dry_run_result(plan.images())
+		}
+		if len(plan.Steps) == 0 {
+			return nil
+		}
+		if !c.Bool("yes") {
+			prompt := fmt.Sprintf("Do you really want to delete all %d manifests of %s? (y/n): ", len(plan.Steps), strings.Join(c.Args(), ", "))
+			if !Confirm(prompt) {
+				return nil
+			}
+		}
+		if err := wait_for_window(c); err != nil {
+			return err
+		}
+		failed := run_delete_plan(r, plan)
+		report_empty_repos(r, c.Args(), c.Bool("delete-empty-repos"))
+		if failed > 0 {
+			return cli.NewExitError(fmt.Sprintf("%d images were not deleted", failed), 1)
+		}
+		return nil
+	}),
+}
+
+// report_empty_repos lists the repositories without any tags left and, if
+// requested, deletes them through the vendor API of the registry. The pins
+// artifact isn't listed as a tag, a repository with only pins left is empty.
+func report_empty_repos(r *registry.DockerRegistry, repos []string, deleteempty bool) {
+	for _, repo := range repos {
+		tags, err := r.Tags(cmdctx, repo)
+		if err != nil && !registry.IsNotFound(err) {
+			log.Printf("Unable to check whether %s is empty: %v", repo, err)
+			continue
+		}
+		if len(tags) > 0 {
+			continue
+		}
+		fmt.Fprintf(stdout, "Repository %s has no tags left\n", repo)
+		if !deleteempty {
+			continue
+		}
+		if err := r.DeleteRepository(cmdctx, repo); err != nil {
+			record_error()
+			fmt.Fprintf(stdout, "Unable to delete repository %s: %v\n", repo, err)
+		} else {
+			fmt.Fprintf(stdout, "Deleted repository %s\n", repo)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+// reason describes, for one candidate image, a filter it matched or a
+// protection that didn't keep it
+type reason func(img *registry.DockerImage) string
+
+// print_explanations lists every candidate with the reasons it was selected
+func print_explanations(imgs []*registry.DockerImage, reasons []reason) {
+	fmt.Fprintf(stdout, "Why these images were selected:\n")
+	for _, img := range imgs {
+		fmt.Fprintf(stdout, "%s:%s\n", img.Name, img.Tag)
+		if len(reasons) == 0 {
+			fmt.Fprintf(stdout, "  no filters given, every image matches\n")
+		}
+		for _, why := range reasons {
+			fmt.Fprintf(stdout, "  %s\n", why(img))
+		}
+	}
+}
+
+// protection_reasons explains why the checks before deleting let every image
+// of plan through: its pins, the archive registry, the pull-through cache
+// probe, the delete permission and the tags sharing its digest
+func protection_reasons(c *cli.Context, r *registry.DockerRegistry, plan *deletePlan) []reason {
+	pinned := make(map[string]int)
+	reasons := []reason{func(img *registry.DockerImage) string {
+		n, ok := pinned[img.Name]
+		if !ok {
+			pins, _, err := r.Pins(cmdctx, img.Name)
+			if err != nil {
+				return fmt.Sprintf("not pinned, the pins of %s are unreadable now: %v", img.Name, err)
+			}
+			n = len(pins)
+			pinned[img.Name] = n
+		}
+		return fmt.Sprintf("not pinned, %s is not among the %d pinned digests of %s", short_digest(img.ContentDigest), n, img.Name)
+	}}
+	if url := c.GlobalString("archive-url"); url != "" {
+		reasons = append(reasons, func(img *registry.DockerImage) string {
+			return fmt.Sprintf("--archive-url: %s exists in %s", short_digest(img.ContentDigest), url)
+		})
+	}
+	if !c.GlobalBool("allow-mirror") {
+		reasons = append(reasons, func(img *registry.DockerImage) string {
+			return fmt.Sprintf("%s is not a pull-through cache", img.Name)
+		})
+	}
+	steps := make(map[*registry.DockerImage][]*registry.DockerImage)
+	for _, step := range plan.Steps {
+		for _, img := range step {
+			steps[img] = step
+		}
+	}
+	return append(reasons,
+		func(img *registry.DockerImage) string {
+			return fmt.Sprintf("the registry allows deleting in %s", img.Name)
+		},
+		func(img *registry.DockerImage) string {
+			if forced := plan.Forced[img.Name+"@"+img.ContentDigest]; len(forced) > 0 {
+				return fmt.Sprintf("--force-shared: deleting %s also removes %s", short_digest(img.ContentDigest), strings.Join(forced, ", "))
+			}
+			var others []*registry.DockerImage
+			for _, other := range steps[img] {
+				if other != img {
+					others = append(others, other)
+				}
+			}
+			if len(others) == 0 {
+				return fmt.Sprintf("no other tag points at %s", short_digest(img.ContentDigest))
+			}
+			return fmt.Sprintf("the other tags of %s are selected as well: %s", short_digest(img.ContentDigest), refs(others))
+		})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+// pluginPrefix is the prefix of executables in PATH run as commands, so
+// regclient-foo is available as the foo command
+const pluginPrefix = "regclient-"
+
+// alias_args returns the arguments an alias from the "aliases" section of
+// the config file expands to. Aliases are given as a string split at spaces,
+// or as a list for arguments containing spaces:
+//
+//	aliases:
+//	  prune: images --older-than 90d --delete
+//	  stale: [images, --tag-contains, "feature "]
+func alias_args(name string) ([]string, bool) {
+	value, ok := config["aliases"][name]
+	if !ok {
+		return nil, false
+	}
+	if list, ok := value.([]interface{}); ok {
+		var args []string
+		for _, v := range list {
+			args = append(args, fmt.Sprint(v))
+		}
+		return args, true
+	}
+	return strings.Fields(fmt.Sprint(value)), true
+}
+
+// run_extension runs the command c names if it isn't built in: an alias
+// is expanded and the resulting command run with the same global flags,
+// otherwise a plugin executable is looked up in PATH
+func run_extension(c *cli.Context) error {
+	name := c.Args().First()
+	if args, ok := alias_args(name); ok && len(args) > 0 {
+		cmd := c.App.Command(args[0])
+		if cmd == nil {
+			return cli.NewExitError(fmt.Sprintf("Alias %s refers to the unknown command %s", name, args[0]), 1)
+		}
+		//The global flags are looked up in c, the new context only
+		//carries the expanded arguments
+		set := flag.NewFlagSet(name, flag.ContinueOnError)
+		if err := set.Parse(append(args, c.Args().Tail()...)); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return cmd.Run(cli.NewContext(c.App, set, c))
+	}
+
+	path, err := exec.LookPath(pluginPrefix + name)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Unknown command %s, see --help", name), 1)
+	}
+	plugin := exec.Command(path, c.Args().Tail()...)
+	plugin.Stdin, plugin.Stdout, plugin.Stderr = os.Stdin, os.Stdout, os.Stderr
+	//Plugins get the registry settings through the environment
+	plugin.Env = append(os.Environ(),
+		"REGCLIENT_URL="+c.GlobalString("url"),
+		fmt.Sprintf("REGCLIENT_VERIFY_TLS=%t", c.GlobalBool("verify-tls")),
+		"REGCLIENT_FLAVOR="+c.GlobalString("flavor"),
+		"REGCLIENT_SCOPE="+c.GlobalString("scope"),
+		fmt.Sprintf("REGCLIENT_READ_ONLY=%t", c.GlobalBool("read-only") || fixed_clock()),
+	)
+	if err := plugin.Run(); err != nil {
+		if exit, ok := err.(*exec.ExitError); ok {
+			return cli.NewExitError("", exit.ExitCode())
+		}
+		return cli.NewExitError(fmt.Sprintf("Unable to run plugin %s: %v", path, err), 1)
+	}
+	return nil
+}
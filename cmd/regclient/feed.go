@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// changeEvent is a normalized registry change, written as one JSON object
+// per line for log shippers and SIEM pipelines
+type changeEvent struct {
+	Time           time.Time `json:"time"`
+	Registry       string    `json:"registry"`
+	Type           string    `json:"type"`
+	Repository     string    `json:"repository"`
+	Tag            string    `json:"tag"`
+	Digest         string    `json:"digest,omitempty"`
+	PreviousDigest string    `json:"previous_digest,omitempty"`
+}
+
+// change_events turns the differences between two snapshots into events. A
+// tag pointing at content that didn't exist in the repository before is a
+// "push", a tag pointing at already known content is a "retag" and a tag
+// that disappeared is a "delete".
+func change_events(old, new *Snapshot) []changeEvent {
+	known := make(map[string]bool)
+	for repo, tags := range old.Repositories {
+		for _, digest := range tags {
+			known[repo+"@"+digest] = true
+		}
+	}
+
+	var events []changeEvent
+	for _, ch := range diff_snapshots(old, new) {
+		ev := changeEvent{
+			Time:           new.Taken,
+			Registry:       new.Registry,
+			Repository:     ch.Repo,
+			Tag:            ch.Tag,
+			Digest:         ch.NewDigest,
+			PreviousDigest: ch.OldDigest,
+		}
+		switch {
+		case ch.Type == "removed":
+			ev.Type = "delete"
+		case known[ch.Repo+"@"+ch.NewDigest]:
+			ev.Type = "retag"
+		default:
+			ev.Type = "push"
+		}
+		events = append(events, ev)
+	}
+	return events
+}
+
+// emit_events appends the events as JSON lines to w and, if webhook is set,
+// POSTs them to the webhook as a JSON array
+func emit_events(events []changeEvent, w io.Writer, webhook string) error {
+	enc := json.NewEncoder(w)
+	for _, ev := range events {
+		if err := enc.Encode(ev); err != nil {
+			return err
+		}
+	}
+	if webhook == "" || len(events) == 0 {
+		return nil
+	}
+	if err := post_json(webhook, events, nil); err != nil {
+		return fmt.Errorf("Unable to send events to webhook: %v", err)
+	}
+	return nil
+}
+
+var snapshotFeedCommand = cli.Command{
+	Name:  "feed",
+	Usage: "Compares the registry with the state file, emits the changes as JSON lines and updates the state file",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "state",
+			Usage: "Snapshot holding the state seen by the previous run, created if missing",
+		},
+		cli.StringSliceFlag{
+			Name:  "repo, r",
+			Usage: "Only watch this repository (default: the whole catalog)",
+		},
+		cli.StringFlag{
+			Name:  "events-file",
+			Usage: "Append the events to this file instead of printing them",
+		},
+		cli.StringFlag{
+			Name:  "webhook",
+			Usage: "Also POST the events as a JSON array to this URL",
+		},
+	},
+	Action: instrumented("snapshot feed", func(c *cli.Context) error {
+		statefile := c.String("state")
+		if statefile == "" {
+			return cli.NewExitError("You must specify a --state file", 1)
+		}
+		current, err := take_snapshot(c, init_registry(c), c.StringSlice("repo"))
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		//Unread tags would be reported as deleted now and pushed again on
+		//the next run, so nothing is emitted and the old state is kept
+		if len(current.Failed) > 0 {
+			return cli.NewExitError(fmt.Sprintf("Unable to read the tags of %s, no events were emitted and the state is unchanged", strings.Join(current.Failed, ", ")), 1)
+		}
+
+		//Without previous state there is nothing to compare with, the
+		//first run only records the baseline
+		previous, err := load_snapshot(statefile)
+		if os.IsNotExist(err) {
+			previous = nil
+		} else if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		if previous != nil {
+			var w io.Writer = stdout
+			if path := c.String("events-file"); path != "" {
+				f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+				if err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				defer f.Close()
+				w = f
+			}
+			if err := emit_events(change_events(previous, current), w, c.String("webhook")); err != nil {
+				//Keep the old state, so the events are emitted again next time
+				return cli.NewExitError(err.Error(), 1)
+			}
+		}
+		if _, err := save_snapshot(current, statefile); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}),
+}
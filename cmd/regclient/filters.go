@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+)
+
+// Layouts accepted for dates in flags, labels and annotations. Dates
+// without a time zone are in UTC.
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+	"20060102",
+	//Ordinal dates (day of the year)
+	"2006-002",
+}
+
+// isoWeek matches ISO 8601 week dates such as 2024-W05 or 2024-W05-3
+var isoWeek = regexp.MustCompile(`^(\d{4})-?W(\d{2})(?:-?([1-7]))?$`)
+
+// parse_week returns the start of the given day of an ISO week, Monday if no
+// day is given
+func parse_week(m []string) (time.Time, error) {
+	year, _ := strconv.Atoi(m[1])
+	week, _ := strconv.Atoi(m[2])
+	day := 1
+	if m[3] != "" {
+		day, _ = strconv.Atoi(m[3])
+	}
+	//January 4th is always in the first week
+	jan4 := time.Date(year, time.January, 4, 0, 0, 0, 0, time.UTC)
+	monday := jan4.AddDate(0, 0, -((int(jan4.Weekday()) + 6) % 7))
+	t := monday.AddDate(0, 0, (week-1)*7+day-1)
+	if y, w := t.ISOWeek(); y != year || w != week {
+		return time.Time{}, fmt.Errorf("%d has no week %d", year, week)
+	}
+	return t, nil
+}
+
+// parse_date is the single place dates given by users or found in image
+// metadata are parsed. Besides the layouts above it accepts ISO week dates.
+func parse_date(s string) (time.Time, error) {
+	normalized := strings.ToUpper(strings.TrimSpace(s))
+	if m := isoWeek.FindStringSubmatch(normalized); m != nil {
+		return parse_week(m)
+	}
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, normalized); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("'%s' is not a date (eg 2024-01-31, 2024-01-31T12:00:00+02:00 or 2024-W05)", s)
+}
+
+var relativeAge = regexp.MustCompile(`^(\d+)([hdw])$`)
+
+// parse_age parses either a date or an age relative to now such as 36h, 90d
+// or 2w and returns the corresponding point in time
+func parse_age(s string, now time.Time) (time.Time, error) {
+	m := relativeAge.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return parse_date(s)
+	}
+	n, _ := strconv.Atoi(m[1])
+	unit := map[string]time.Duration{"h": time.Hour, "d": 24 * time.Hour, "w": 7 * 24 * time.Hour}[m[2]]
+	return now.Add(-time.Duration(n) * unit), nil
+}
+
+// image_metadata looks up key in the annotations of the image and falls back
+// to its config labels
+func image_metadata(img *registry.DockerImage, key string) (string, bool) {
+	if v, ok := img.Annotations[key]; ok {
+		return v, true
+	}
+	v, ok := img.Labels[key]
+	return v, ok
+}
+
+// has_platform reports whether match accepts the platform of img or, for
+// manifest lists and indexes, the platform of any of their manifests. With
+// every set, all manifests of an index must match, as deleting the index
+// deletes every platform at once. Attestations and other entries without a
+// platform are ignored.
+func has_platform(img *registry.DockerImage, every bool, match func(os, architecture, variant string) bool) bool {
+	if len(img.Children) == 0 {
+		return match(img.OS, img.Architecture, img.Variant)
+	}
+	matched := false
+	for _, child := range img.Children {
+		if child.Platform == nil || child.Platform.OS == "unknown" {
+			continue
+		}
+		ok := match(child.Platform.OS, child.Platform.Architecture, child.Platform.Variant)
+		if ok && !every {
+			return true
+		}
+		if !ok && every {
+			return false
+		}
+		matched = matched || ok
+	}
+	return matched
+}
+
+// platforms_of describes the platforms img was built for, to explain the
+// --arch and --os filters
+func platforms_of(img *registry.DockerImage) string {
+	if len(img.Children) == 0 {
+		return img.Platform()
+	}
+	if p := img.Platforms(); len(p) > 0 {
+		return strings.Join(p, ", ")
+	}
+	return "no platform"
+}
+
+// authorKeys are the labels and annotations naming the maintainers of an
+// image, besides the author field of its config
+var authorKeys = []string{"maintainer", "org.opencontainers.image.authors"}
+
+// image_authors returns everyone recorded as author or maintainer of img
+func image_authors(img *registry.DockerImage) []string {
+	var authors []string
+	if img.Author != "" {
+		authors = append(authors, img.Author)
+	}
+	for _, key := range authorKeys {
+		if v, ok := image_metadata(img, key); ok && v != "" {
+			authors = append(authors, v)
+		}
+	}
+	return authors
+}
+
+// older_than_filter matches images created before t
+func older_than_filter(t time.Time) ImgFilter {
+	return func(img *registry.DockerImage) bool {
+		return img.Created.Before(t)
+	}
+}
+
+// tag_contains_filter matches images whose tag contains s
+func tag_contains_filter(s string) ImgFilter {
+	return func(img *registry.DockerImage) bool {
+		return strings.Contains(img.Tag, s)
+	}
+}
+
+// tag_exclude_filter matches images whose tag doesn't contain s
+func tag_exclude_filter(s string) ImgFilter {
+	return func(img *registry.DockerImage) bool {
+		return !strings.Contains(img.Tag, s)
+	}
+}
+
+// author_filter matches images whose author or maintainers contain one of
+// the given names, ignoring case
+func author_filter(names []string) ImgFilter {
+	return func(img *registry.DockerImage) bool {
+		for _, author := range image_authors(img) {
+			for _, name := range names {
+				if strings.Contains(strings.ToLower(author), strings.ToLower(name)) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+// expired_filter matches images whose expiry date, declared in the label or
+// annotation key, lies before now. Images without a (valid) expiry date
+// never match.
+func expired_filter(key string, now time.Time) ImgFilter {
+	return func(img *registry.DockerImage) bool {
+		v, ok := image_metadata(img, key)
+		if !ok {
+			return false
+		}
+		expires, err := parse_date(v)
+		if err != nil {
+			log.Printf("Ignoring invalid expiry date '%s' on %s:%s", v, img.Name, img.Tag)
+			return false
+		}
+		return expires.Before(now)
+	}
+}
+
+// pulled_filter matches images last pulled before cutoff, or never pulled.
+// Images whose last pull can't be read never match. seen is called with the
+// last pull of every image read.
+func pulled_filter(r *registry.DockerRegistry, cutoff time.Time, seen func(img *registry.DockerImage, pulled time.Time)) ImgFilter {
+	return func(img *registry.DockerImage) bool {
+		pulled, err := r.LastPulled(cmdctx, img)
+		if err != nil {
+			record_error()
+			log.Printf("Unable to get the last pull of %s:%s, keeping it: %v", img.Name, img.Tag, err)
+			return false
+		}
+		seen(img, pulled)
+		return pulled.Before(cutoff)
+	}
+}
+
+// parse_branch_regex compiles a --branch-regex, which must capture the
+// branch name from the tag
+func parse_branch_regex(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid --branch-regex: %v", err)
+	}
+	if re.NumSubexp() == 0 {
+		return nil, fmt.Errorf("--branch-regex must contain a capture group for the branch name")
+	}
+	return re, nil
+}
+
+// branch_key groups images by repository and the branch name captured by re
+// from the tag. A capture group named "branch" is preferred over the first one.
+func branch_key(re *regexp.Regexp) func(img *registry.DockerImage) string {
+	group := 1
+	if i := re.SubexpIndex("branch"); i > 0 {
+		group = i
+	}
+	return func(img *registry.DockerImage) string {
+		m := re.FindStringSubmatch(img.Tag)
+		if m == nil {
+			return ""
+		}
+		return img.Name + ":" + m[group]
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"2024-01-31", "2024-01-31T00:00:00Z"},
+		{"20240131", "2024-01-31T00:00:00Z"},
+		{"2024-01-31T12:00:00+02:00", "2024-01-31T10:00:00Z"},
+		{"2024-01-31 12:30:00", "2024-01-31T12:30:00Z"},
+		//Ordinal dates
+		{"2024-032", "2024-02-01T00:00:00Z"},
+		{"2024-366", "2024-12-31T00:00:00Z"},
+		//ISO week dates, the first week of 2025 starts in 2024
+		{"2024-W05", "2024-01-29T00:00:00Z"},
+		{"2024-w05-3", "2024-01-31T00:00:00Z"},
+		{"2024W053", "2024-01-31T00:00:00Z"},
+		{"2025-W01-1", "2024-12-30T00:00:00Z"},
+		{"2020-W53-7", "2021-01-03T00:00:00Z"},
+	}
+	for _, test := range tests {
+		got, err := parse_date(test.s)
+		if err != nil {
+			t.Errorf("parse_date(%s): %v", test.s, err)
+			continue
+		}
+		if got.UTC().Format(time.RFC3339) != test.want {
+			t.Errorf("parse_date(%s) = %s, want %s", test.s, got.UTC().Format(time.RFC3339), test.want)
+		}
+	}
+	for _, s := range []string{"2023-366", "2024-W54", "2021-W53", "2024-13-01", "yesterday"} {
+		if _, err := parse_date(s); err == nil {
+			t.Errorf("parse_date(%s) accepted an invalid date", s)
+		}
+	}
+}
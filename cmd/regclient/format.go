@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/template"
+	"time"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+	"gopkg.in/yaml.v3"
+)
+
+// outputFormat renders the results of a command as text (the default),
+// json, yaml or with a Go template executed for every result
+type outputFormat struct {
+	kind string
+	tmpl *template.Template
+}
+
+// structuredOutput is set while a command prints json, yaml or templates,
+// progress messages then go to stderr so the output stays parseable
+var structuredOutput bool
+
+// progress returns where messages about the progress of a command go
+func progress() io.Writer {
+	if structuredOutput {
+		return os.Stderr
+	}
+	return stdout
+}
+
+var outputFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "output",
+		Value: "text",
+		Usage: "Output format: text, json, yaml or template (see --template)",
+	},
+	cli.StringFlag{
+		Name:  "template",
+		Usage: "Go template executed for every result with --output template, eg '{{.Name}}:{{.Tag}} {{.ContentDigest}}'",
+	},
+}
+
+// parse_output reads --output and --template
+func parse_output(c *cli.Context) (*outputFormat, error) {
+	f := &outputFormat{kind: c.String("output")}
+	switch f.kind {
+	case "text", "json", "yaml":
+		if c.String("template") != "" {
+			return nil, fmt.Errorf("--template requires --output template")
+		}
+	case "template":
+		if c.String("template") == "" {
+			return nil, fmt.Errorf("--output template requires a --template")
+		}
+		var err error
+		if f.tmpl, err = template.New("output").Parse(c.String("template")); err != nil {
+			return nil, fmt.Errorf("Invalid --template: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("Unknown output format '%s', use text, json, yaml or template", f.kind)
+	}
+	structuredOutput = f.kind != "text"
+	return f, nil
+}
+
+// render prints records as a json or yaml list, or executes the template
+// for every one of values, which correspond to records
+func (f *outputFormat) render(records interface{}, values []interface{}) error {
+	switch f.kind {
+	case "json":
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case "yaml":
+		enc := yaml.NewEncoder(stdout)
+		defer enc.Close()
+		return enc.Encode(records)
+	}
+	for _, value := range values {
+		if err := f.tmpl.Execute(stdout, value); err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout)
+	}
+	return nil
+}
+
+// imageRecord is an image as printed by --output json and yaml
+type imageRecord struct {
+	Repository  string            `json:"repository" yaml:"repository"`
+	Tag         string            `json:"tag" yaml:"tag"`
+	Tags        []string          `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Digest      string            `json:"digest" yaml:"digest"`
+	Created     time.Time         `json:"created" yaml:"created"`
+	Size        int64             `json:"size" yaml:"size"`
+	MediaType   string            `json:"mediaType" yaml:"mediaType"`
+	Platform    string            `json:"platform,omitempty" yaml:"platform,omitempty"`
+	Platforms   []string          `json:"platforms,omitempty" yaml:"platforms,omitempty"`
+	Author      string            `json:"author,omitempty" yaml:"author,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+}
+
+// render_images prints imgs in the format f, with the tags of their whole
+// manifest if collapsed holds them. Templates are executed on the
+// registry.DockerImage itself, so its methods (eg .Platforms) can be used.
+func (f *outputFormat) render_images(imgs []*registry.DockerImage, collapsed map[*registry.DockerImage][]string) error {
+	records := make([]imageRecord, 0, len(imgs))
+	values := make([]interface{}, 0, len(imgs))
+	for _, img := range imgs {
+		record := imageRecord{
+			Repository:  img.Name,
+			Tag:         img.Tag,
+			Digest:      img.ContentDigest,
+			Created:     img.Created,
+			Size:        img.Size,
+			MediaType:   img.MediaType,
+			Platforms:   img.Platforms(),
+			Author:      img.Author,
+			Labels:      img.Labels,
+			Annotations: img.Annotations,
+		}
+		if img.OS != "" {
+			record.Platform = img.Platform()
+		}
+		if collapsed != nil {
+			record.Tags = image_tags(img, collapsed)
+		}
+		records = append(records, record)
+		values = append(values, img)
+	}
+	return f.render(records, values)
+}
+
+// repoRecord is a repository as printed by repos
+type repoRecord struct {
+	Name string `json:"name" yaml:"name"`
+	Tags int    `json:"tags" yaml:"tags"`
+}
+
+func (f *outputFormat) render_repos(repos []repoRecord) error {
+	//An empty list, rather than null
+	if repos == nil {
+		repos = []repoRecord{}
+	}
+	values := make([]interface{}, 0, len(repos))
+	for _, repo := range repos {
+		values = append(values, repo)
+	}
+	return f.render(repos, values)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+// manifest_formats returns the manifest kind of every distinct manifest in
+// repo, keyed by a reference naming it (a tag where one points at it)
+func manifest_formats(r *registry.DockerRegistry, repo string) (map[string]string, error) {
+	formats := make(map[string]string)
+	if manifests, err := r.ListManifests(cmdctx, repo); err == nil {
+		for _, m := range manifests {
+			ref := repo + "@" + m.Digest
+			if len(m.Tags) > 0 {
+				ref = repo + ":" + m.Tags[0]
+			}
+			formats[ref] = registry.MediaTypeKind(m.MediaType)
+		}
+		return formats, nil
+	} else if err != registry.ErrListingUnsupported {
+		return nil, err
+	}
+
+	tags, err := r.Tags(cmdctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, tag := range tags {
+		m, err := r.GetManifest(cmdctx, repo, tag)
+		if err != nil {
+			record_error()
+			log.Printf("Unable to get manifest of %s:%s: %v", repo, tag, err)
+			continue
+		}
+		if seen[m.Digest] {
+			continue
+		}
+		seen[m.Digest] = true
+		formats[repo+":"+tag] = registry.MediaTypeKind(m.MediaType)
+	}
+	return formats, nil
+}
+
+var formatsCommand = cli.Command{
+	Name:  "formats",
+	Usage: "Reports how many manifests per repository are schema1, schema2, OCI or indexes",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "repo, r",
+			Usage: "Report on this repository (default: the whole catalog)",
+		},
+		cli.StringSliceFlag{
+			Name:  "list",
+			Usage: "Also list the images in this format (eg schema1)",
+		},
+	},
+	Action: instrumented("formats", func(c *cli.Context) error {
+		r := init_registry(c)
+		list := make(map[string]bool)
+		for _, kind := range c.StringSlice("list") {
+			list[kind] = true
+		}
+
+		fmt.Fprintf(stdout, "%-40s %8s %8s %8s %8s %8s\n", "REPOSITORY", "SCHEMA1", "SCHEMA2", "OCI", "INDEX", "OTHER")
+		totals := make(map[string]int)
+		var listed []string
+		_, err := walk_catalog(c, r, c.StringSlice("repo"), func(repo string) func() {
+			formats, err := manifest_formats(r, repo)
+			if err != nil {
+				record_error()
+				log.Printf("Unable to get manifests of %s: %v", repo, err)
+				return nil
+			}
+			return func() {
+				counts := make(map[string]int)
+				for ref, kind := range formats {
+					switch kind {
+					case "schema1", "schema2", "oci", "index":
+					default:
+						kind = "other"
+					}
+					counts[kind]++
+					totals[kind]++
+					if list[kind] {
+						listed = append(listed, fmt.Sprintf("%s %s", kind, ref))
+					}
+				}
+				fmt.Fprintf(stdout, "%-40s %8d %8d %8d %8d %8d\n", repo, counts["schema1"], counts["schema2"], counts["oci"], counts["index"], counts["other"])
+			}
+		})
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		fmt.Fprintf(stdout, "%-40s %8d %8d %8d %8d %8d\n", "TOTAL", totals["schema1"], totals["schema2"], totals["oci"], totals["index"], totals["other"])
+
+		sort.Strings(listed)
+		for _, line := range listed {
+			fmt.Fprintln(stdout, line)
+		}
+		return nil
+	}),
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+var gcExportFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "gc-export",
+		Usage: "Write the manifests of the deletion plan to this file, for garbage collection run on the registry side",
+	},
+	cli.StringFlag{
+		Name:  "gc-format",
+		Value: "plain",
+		Usage: "Format of --gc-export: plain (repository@digest lines), distribution (storage paths to remove before registry garbage-collect) or harbor (JSON artifact references)",
+	},
+}
+
+// check_gc_export validates --gc-export and --gc-format before the scan
+func check_gc_export(c *cli.Context) error {
+	if c.String("gc-export") == "" {
+		return nil
+	}
+	if !c.Bool("delete") {
+		return cli.NewExitError("--gc-export requires --delete, add --dry-run to leave the deleting to the registry", 1)
+	}
+	switch c.String("gc-format") {
+	case "plain", "distribution", "harbor":
+		return nil
+	}
+	return cli.NewExitError(fmt.Sprintf("Unknown --gc-format '%s', use plain, distribution or harbor", c.String("gc-format")), 1)
+}
+
+// harborArtifact identifies an artifact with the path parameters of the
+// artifact API of Harbor (DELETE /projects/{project_name}/repositories/
+// {repository_name}/artifacts/{reference})
+type harborArtifact struct {
+	ProjectName    string `json:"project_name"`
+	RepositoryName string `json:"repository_name"`
+	Reference      string `json:"reference"`
+}
+
+// export_gc writes the manifests deleted by plan to --gc-export. The export
+// is written with --dry-run too, so the policy can be evaluated here and the
+// deletion left to the registry operator.
+func export_gc(c *cli.Context, plan *deletePlan) error {
+	file := c.String("gc-export")
+	if file == "" {
+		return nil
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Unable to write %s: %v", file, err), 1)
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+
+	switch c.String("gc-format") {
+	case "plain":
+		for _, step := range plan.Steps {
+			fmt.Fprintf(w, "%s@%s\n", step[0].Name, step[0].ContentDigest)
+		}
+	case "distribution":
+		//Paths relative to the root directory of the filesystem storage
+		//driver. Removing the tags and the revision link of a manifest is
+		//what deleting it through the API does, garbage-collect then
+		//removes the blobs no longer referenced.
+		for _, step := range plan.Steps {
+			repo := "docker/registry/v2/repositories/" + step[0].Name + "/_manifests"
+			for _, img := range step {
+				if img.Tag != "" {
+					fmt.Fprintf(w, "%s/tags/%s\n", repo, img.Tag)
+				}
+			}
+			algorithm, hex, _ := strings.Cut(step[0].ContentDigest, ":")
+			fmt.Fprintf(w, "%s/revisions/%s/%s\n", repo, algorithm, hex)
+		}
+	case "harbor":
+		artifacts := make([]harborArtifact, 0, len(plan.Steps))
+		for _, step := range plan.Steps {
+			project, repo, _ := strings.Cut(step[0].Name, "/")
+			artifacts = append(artifacts, harborArtifact{project, repo, step[0].ContentDigest})
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(artifacts); err != nil {
+			return cli.NewExitError(fmt.Sprintf("Unable to write %s: %v", file, err), 1)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return cli.NewExitError(fmt.Sprintf("Unable to write %s: %v", file, err), 1)
+	}
+	fmt.Fprintf(progress(), "Wrote %d manifests to %s\n", len(plan.Steps), file)
+	return nil
+}
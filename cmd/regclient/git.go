@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+)
+
+var invalidTagChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// git_refs lists the branches and tags of a git remote using git ls-remote.
+// Every ref is recorded both by its short name and in the form CI systems
+// usually turn it into a docker tag (eg feature/foo -> feature-foo).
+func git_refs(remote string) (map[string]bool, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", "ls-remote", "--heads", "--tags", remote)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git ls-remote %s failed: %v %s", remote, err, strings.TrimSpace(stderr.String()))
+	}
+
+	refs := make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		name := strings.TrimSuffix(fields[1], "^{}")
+		name = strings.TrimPrefix(name, "refs/heads/")
+		name = strings.TrimPrefix(name, "refs/tags/")
+		refs[name] = true
+		refs[invalidTagChars.ReplaceAllString(name, "-")] = true
+	}
+	return refs, scanner.Err()
+}
+
+// dead_ref_filter matches images whose tag maps (through the first capture
+// group of re) to a git ref that no longer exists. Tags that don't match re
+// are never selected.
+func dead_ref_filter(re *regexp.Regexp, refs map[string]bool) ImgFilter {
+	return func(img *registry.DockerImage) bool {
+		m := re.FindStringSubmatch(img.Tag)
+		if m == nil {
+			return false
+		}
+		return !refs[m[1]]
+	}
+}
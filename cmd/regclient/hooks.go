@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+type inUseRequest struct {
+	Repository string    `json:"repository"`
+	Tag        string    `json:"tag"`
+	Digest     string    `json:"digest"`
+	Created    time.Time `json:"created"`
+	Reference  string    `json:"reference"`
+}
+
+type inUseResponse struct {
+	Decision string `json:"decision"`
+	Reason   string `json:"reason"`
+}
+
+// webhookClient sends the requests to webhooks, in-use check endpoints and
+// the pushgateway
+var webhookClient = &http.Client{Timeout: 30 * time.Second}
+
+// send_webhook sends body of type ctype to url with method and fails unless
+// the answer has a 2xx status. If answer isn't nil, the JSON of the response
+// is decoded into it.
+func send_webhook(method, url, ctype string, body []byte, answer interface{}) error {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", ctype)
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	if answer != nil {
+		if err := json.NewDecoder(resp.Body).Decode(answer); err != nil {
+			return fmt.Errorf("invalid response: %v", err)
+		}
+	}
+	return nil
+}
+
+// post_json POSTs payload as JSON to url, see send_webhook
+func post_json(url string, payload interface{}, answer interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return send_webhook("POST", url, "application/json", body, answer)
+}
+
+// in_use_check asks the endpoint at url whether img may be deleted. The
+// endpoint receives the image as JSON and must answer with
+// {"decision": "keep"|"delete"}, and optionally the reason of its decision.
+// Anything else, including errors, keeps the image.
+func in_use_check(url string, img *registry.DockerImage) (bool, string) {
+	var answer inUseResponse
+	if err := post_json(url, inUseRequest{img.Name, img.Tag, img.ContentDigest, img.Created, img.Name + ":" + img.Tag}, &answer); err != nil {
+		return false, fmt.Sprintf("in-use check failed: %v", err)
+	}
+	return answer.Decision == "delete", answer.Reason
+}
+
+// veto_in_use drops every image the in-use check endpoint wants to keep. It
+// also returns the reasons the endpoint gave for the images it allowed.
+func veto_in_use(url string, imgs []*registry.DockerImage) ([]*registry.DockerImage, map[*registry.DockerImage]string) {
+	var allowed []*registry.DockerImage
+	answers := make(map[*registry.DockerImage]string)
+	for _, img := range imgs {
+		if ok, reason := in_use_check(url, img); ok {
+			allowed = append(allowed, img)
+			answers[img] = reason
+		} else {
+			log.Printf("Keeping %s:%s, vetoed by in-use check: %s", img.Name, img.Tag, reason)
+		}
+	}
+	return allowed, answers
+}
+
+// archiveRegistry is the connection to the archive registry, made the first
+// time require_archived needs it and reused for the rest of the run
+var (
+	archiveRegistry   *registry.DockerRegistry
+	archiveRegistryMu sync.Mutex
+)
+
+// archive_registry returns the connection to the archive registry given
+// with --archive-url
+func archive_registry(c *cli.Context) *registry.DockerRegistry {
+	archiveRegistryMu.Lock()
+	defer archiveRegistryMu.Unlock()
+	if archiveRegistry == nil {
+		archiveRegistry = init_registry_at(c, c.GlobalString("archive-url"), c.GlobalString("archive-username"), c.GlobalString("archive-password"))
+	}
+	return archiveRegistry
+}
+
+// require_archived drops every image that doesn't exist with the same digest
+// in the archive registry given with --archive-url, if one is configured
+func require_archived(c *cli.Context, imgs []*registry.DockerImage) []*registry.DockerImage {
+	if c.GlobalString("archive-url") == "" || len(imgs) == 0 {
+		return imgs
+	}
+	archive := archive_registry(c)
+	var allowed []*registry.DockerImage
+	for _, img := range imgs {
+		_, err := archive.ManifestDigest(cmdctx, img.Name, img.ContentDigest)
+		switch {
+		case err == nil:
+			allowed = append(allowed, img)
+		case registry.IsNotFound(err):
+			log.Printf("Keeping %s:%s, %s is not in the archive registry", img.Name, img.Tag, img.ContentDigest)
+		default:
+			log.Printf("Keeping %s:%s, unable to check the archive registry: %v", img.Name, img.Tag, err)
+		}
+	}
+	return allowed
+}
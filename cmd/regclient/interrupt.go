@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/urfave/cli"
+)
+
+// interrupted is cancelled by Ctrl-C. Commands run in contexts derived from
+// it, so requests in flight are aborted and the worker pools drain their
+// queues instead of sending further requests.
+var interrupted = context.Background()
+
+// init_interrupt cancels interrupted on SIGINT. A second Ctrl-C exits
+// right away.
+func init_interrupt() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		stop()
+		log.Printf("Interrupted, stopping the requests in flight. Press Ctrl-C again to exit immediately")
+	}()
+	interrupted = ctx
+	cmdctx = ctx
+}
+
+// interrupt_error returns an error if the command was interrupted
+func interrupt_error() error {
+	if interrupted.Err() == nil {
+		return nil
+	}
+	return cli.NewExitError("Interrupted", 130)
+}
@@ -0,0 +1,1336 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+func handleErr(e error) {
+	if e != nil {
+		log.Printf("ERROR: %v\n", e)
+	}
+}
+
+var finishOnce sync.Once
+
+// finish_run cleans up and reports the outcome of the run. Actions failing with an exit
+// code end the process before app.After runs, so it is called from both
+// places and only runs once.
+func finish_run(c *cli.Context) {
+	finishOnce.Do(func() {
+		remove_cache_spill()
+		handleErr(close_output())
+		report_immutable()
+		report_warnings()
+		handleErr(push_summary(c))
+		metrics.Close()
+		handleErr(shutdown_tracing(c))
+	})
+}
+
+type ImgFilter func(img *registry.DockerImage) bool
+
+type ByCreated []*registry.DockerImage
+
+func (s ByCreated) Len() int           { return len(s) }
+func (s ByCreated) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s ByCreated) Less(i, j int) bool { return s[i].Created.After(s[j].Created) }
+
+// registries holds every registry connected to during the run
+var registries []*registry.DockerRegistry
+
+// circuit_error returns an error naming every registry whose circuit breaker
+// tripped, the run failed even if the command itself carried on
+func circuit_error() error {
+	var msgs []string
+	for _, r := range registries {
+		if err := r.CircuitOpen(); err != nil {
+			msgs = append(msgs, fmt.Sprintf("%s: %v", r.URL, err))
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return cli.NewExitError(strings.Join(msgs, "\n"), 1)
+}
+
+func init_registry(c *cli.Context) *registry.DockerRegistry {
+	if c.GlobalString("url") == "" {
+		log.Fatalf("You must specify a registry (eg --url https://my.registry.com:5000)")
+	}
+	return init_registry_at(c, c.GlobalString("url"), c.GlobalString("username"), c.GlobalString("password"))
+}
+
+// init_registry_at connects to the registry at url using the global
+// settings, for commands working with a second registry. The global
+// --username and --password only belong to --url, so other registries get
+// their own credentials, or those docker login stored for their host
+func init_registry_at(c *cli.Context, url, username, password string) *registry.DockerRegistry {
+	if username == "" && !c.GlobalBool("no-docker-config") {
+		var err error
+		if username, password, err = registry.DockerCredentials(url); err != nil {
+			log.Printf("Unable to use the docker credentials: %v", err)
+		}
+	}
+	opts := []registry.Option{
+		registry.WithTLSVerify(c.GlobalBool("verify-tls")),
+		registry.WithCredentials(username, password),
+		registry.WithUserAgent("docker-regclient/" + c.App.Version),
+		registry.WithRetries(c.GlobalInt("retries")),
+		registry.WithRateLimit(c.GlobalFloat64("rate-limit")),
+		registry.WithTimeout(c.GlobalDuration("timeout")),
+	}
+	r, err := registry.NewDockerRegistry(url, opts...)
+	if err != nil {
+		log.Fatalf("Unable to connect to Docker registry at %s: %v", url, err)
+	}
+	log.Printf("SUCCESS: established connection to %v", r.URL)
+	if flavor := c.GlobalString("flavor"); flavor != "auto" {
+		r.SetFlavor(registry.Flavor(flavor))
+	}
+	r.SetGitLabAPI(c.GlobalString("gitlab-url"), c.GlobalString("gitlab-token"))
+	r.SetScope(c.GlobalString("scope"))
+	r.SetReadOnly(c.GlobalBool("read-only") || fixed_clock())
+	if err := r.SetProtectedTags(c.GlobalStringSlice("protect")); err != nil {
+		log.Fatalf("Invalid --protect: %v", err)
+	}
+	if err := r.SetAccept(c.GlobalStringSlice("accept")); err != nil {
+		log.Fatalf("Invalid --accept: %v", err)
+	}
+	if !c.GlobalBool("no-cache") {
+		r.EnableCache()
+		limit, err := parse_size(c.GlobalString("cache-memory"))
+		if err != nil {
+			log.Fatalf("Invalid --cache-memory: %v", err)
+		}
+		r.SetCacheLimit(limit, cache_spill_dir(c))
+	}
+	if limit := c.GlobalString("limit-bandwidth"); limit != "" {
+		rate, err := parse_bandwidth(limit)
+		if err != nil {
+			log.Fatalf("Invalid --limit-bandwidth: %v", err)
+		}
+		r.SetBandwidthLimit(rate)
+	}
+	r.SetCircuitBreaker(c.GlobalInt("max-failures"))
+	r.SetPageSize(c.GlobalInt("list-page-size"))
+	r.SetRequestIDHeader(c.GlobalString("request-id-header"))
+	observe_requests(c, r)
+	observe_warnings(r)
+	registries = append(registries, r)
+	return r
+}
+
+var bandwidthUnits = map[string]int64{
+	"":    1,
+	"B":   1,
+	"KB":  1000,
+	"MB":  1000 * 1000,
+	"GB":  1000 * 1000 * 1000,
+	"KIB": 1024,
+	"MIB": 1024 * 1024,
+	"GIB": 1024 * 1024 * 1024,
+}
+
+// parse_size parses a size such as "256MB" or "1GiB" into bytes
+func parse_size(s string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	i := strings.IndexFunc(v, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(v)
+	}
+	n, err := strconv.ParseFloat(v[:i], 64)
+	unit, ok := bandwidthUnits[strings.TrimSpace(v[i:])]
+	if err != nil || !ok || n < 0 {
+		return 0, fmt.Errorf("'%s' is not a size like 256MB", s)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+// parse_bandwidth parses a rate such as "10MB/s" or "512KiB/s" into bytes
+// per second
+func parse_bandwidth(s string) (int64, error) {
+	n, err := parse_size(strings.TrimSuffix(strings.TrimSpace(s), "/s"))
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("'%s' is not a rate like 10MB/s", s)
+	}
+	return n, nil
+}
+
+// Number of goroutines listing tags, the manifests are fetched by
+// --concurrency goroutines
+const tagWorkers = 4
+
+// This function fetches images for all tags contained in the specified repos
+// and returns the ones matching every filter, sorted by creation date. Besides
+// the matching images it returns the number of images scanned per repository.
+func fetch_images(r *registry.DockerRegistry, repos []string, filters []ImgFilter, workers int) ([]*registry.DockerImage, map[string]int) {
+	var imgs []*registry.DockerImage
+	scanned := make(map[string]int)
+	each_repo_images(r, repos, filters, workers, func(repo string, matched []*registry.DockerImage, n int) {
+		scanned[repo] = n
+		imgs = append(imgs, matched...)
+	})
+	sort.Sort(ByCreated(imgs))
+	return imgs, scanned
+}
+
+// each_repo_images fetches the images of every tag of repos using a staged
+// pipeline (repos -> tags -> manifests). Every stage is served by a fixed
+// pool of workers connected through bounded channels, so the number of
+// goroutines and open connections doesn't grow with the number of tags being
+// scanned. The request rate is limited by the registry client (--rate-limit).
+// fn is called, one repository at a time, with the images of a repository
+// matching every filter once all its tags are fetched, and the number of
+// images scanned. Only the repositories in progress are held in memory, so
+// callers keeping what they need of each one run over whole registries in
+// bounded memory.
+func each_repo_images(r *registry.DockerRegistry, repos []string, filters []ImgFilter, workers int, fn func(repo string, imgs []*registry.DockerImage, scanned int)) {
+	if workers < 1 {
+		workers = 1
+	}
+	type imageref struct {
+		repo string
+		tag  string
+	}
+	//result is a fetched image, or a failed fetch if img is nil
+	type result struct {
+		repo string
+		img  *registry.DockerImage
+	}
+	repochan := make(chan string)
+	refchan := make(chan imageref, workers)
+	resultchan := make(chan result, workers)
+
+	//pending counts the tags of every repository still being fetched
+	var mu sync.Mutex
+	pending := make(map[string]int)
+
+	go func() {
+		for _, repo := range repos {
+			repochan <- repo
+		}
+		close(repochan)
+	}()
+
+	var tagwait sync.WaitGroup
+	for i := 0; i < tagWorkers && i < workers; i++ {
+		tagwait.Add(1)
+		go func() {
+			defer tagwait.Done()
+			for repo := range repochan {
+				//Once the registry is given up on or the run is
+				//interrupted, drain the queue without sending requests
+				if r.CircuitOpen() != nil || cmdctx.Err() != nil {
+					continue
+				}
+				tags, err := r.Tags(cmdctx, repo)
+				if err != nil {
+					record_error()
+					log.Printf("Unable to get tags of %s: %s", repo, err)
+					continue
+				}
+				if len(tags) == 0 {
+					continue
+				}
+				fmt.Fprintf(progress(), "Fetching image details from repository %s\n", repo)
+				mu.Lock()
+				pending[repo] = len(tags)
+				mu.Unlock()
+				for _, tag := range tags {
+					refchan <- imageref{repo, tag}
+				}
+			}
+		}()
+	}
+	go func() { tagwait.Wait(); close(refchan) }()
+
+	var imgwait sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		imgwait.Add(1)
+		go func() {
+			defer imgwait.Done()
+			for ref := range refchan {
+				if r.CircuitOpen() != nil || cmdctx.Err() != nil {
+					resultchan <- result{ref.repo, nil}
+					continue
+				}
+				img, err := r.ImageDetails(cmdctx, ref.repo+":"+ref.tag)
+				if err != nil {
+					record_error()
+					log.Printf("Unable to get image (%s:%s): %s", ref.repo, ref.tag, err)
+					resultchan <- result{ref.repo, nil}
+					continue
+				}
+				record_scanned(img)
+				resultchan <- result{ref.repo, img}
+			}
+		}()
+	}
+	go func() { imgwait.Wait(); close(resultchan) }()
+
+	//Collect the images per repository, and hand every repository over
+	//once all its tags are done
+	matched := make(map[string][]*registry.DockerImage)
+	scanned := make(map[string]int)
+	for res := range resultchan {
+		if res.img != nil {
+			scanned[res.repo]++
+			if matches_filters(res.img, filters) {
+				matched[res.repo] = append(matched[res.repo], res.img)
+			}
+		}
+		mu.Lock()
+		pending[res.repo]--
+		done := pending[res.repo] == 0
+		if done {
+			delete(pending, res.repo)
+		}
+		mu.Unlock()
+		if done {
+			fn(res.repo, matched[res.repo], scanned[res.repo])
+			delete(matched, res.repo)
+			delete(scanned, res.repo)
+		}
+	}
+}
+
+// matches_filters reports whether img passes every filter
+func matches_filters(img *registry.DockerImage, filters []ImgFilter) bool {
+	for _, filter := range filters {
+		if !filter(img) {
+			return false
+		}
+	}
+	return true
+}
+
+// latest_per_group returns the n newest images of every group, where the
+// group of an image is determined by key. imgs must be sorted newest first.
+func latest_per_group(imgs []*registry.DockerImage, n int, key func(img *registry.DockerImage) string) map[*registry.DockerImage]bool {
+	seen := make(map[string]int)
+	latest := make(map[*registry.DockerImage]bool)
+	for _, img := range imgs {
+		k := key(img)
+		seen[k]++
+		if seen[k] <= n {
+			latest[img] = true
+		}
+	}
+	return latest
+}
+
+// drop_kept returns the images of imgs not in keep
+func drop_kept(imgs []*registry.DockerImage, keep map[*registry.DockerImage]bool) []*registry.DockerImage {
+	if len(keep) == 0 {
+		return imgs
+	}
+	var candidates []*registry.DockerImage
+	for _, img := range imgs {
+		if !keep[img] {
+			candidates = append(candidates, img)
+		}
+	}
+	return candidates
+}
+
+// newer_in_group returns how many images of its group, determined by key,
+// are newer than every image. imgs must be sorted newest first.
+func newer_in_group(imgs []*registry.DockerImage, key func(img *registry.DockerImage) string) map[*registry.DockerImage]int {
+	seen := make(map[string]int)
+	newer := make(map[*registry.DockerImage]int)
+	for _, img := range imgs {
+		k := key(img)
+		newer[img] = seen[k]
+		seen[k]++
+	}
+	return newer
+}
+
+// latest_within_size returns the newest images of every group whose blobs
+// add up to at most budget bytes. Blobs shared by several images, like common
+// base layers or the digest of several tags, are counted once.
+// imgs must be sorted newest first.
+func latest_within_size(r *registry.DockerRegistry, imgs []*registry.DockerImage, budget int64, key func(img *registry.DockerImage) string) map[*registry.DockerImage]bool {
+	used := make(map[string]int64)
+	full := make(map[string]bool)
+	counted := make(map[string]map[string]bool)
+	latest := make(map[*registry.DockerImage]bool)
+	for _, img := range imgs {
+		k := key(img)
+		if full[k] {
+			continue
+		}
+		if counted[k] == nil {
+			counted[k] = make(map[string]bool)
+		}
+		blobs := image_blobs(r, img)
+		if blobs == nil {
+			blobs = map[string]int64{img.ContentDigest: img.Size}
+		}
+		var added int64
+		for digest, size := range blobs {
+			if !counted[k][digest] {
+				added += size
+			}
+		}
+		if used[k]+added > budget {
+			full[k] = true
+			continue
+		}
+		used[k] += added
+		for digest := range blobs {
+			counted[k][digest] = true
+		}
+		latest[img] = true
+	}
+	return latest
+}
+
+// image_blobs returns the size of every blob of img by digest, the config
+// and layers of every platform of an index. It returns nil if they can't all
+// be told, eg for schema1 manifests which don't record layer sizes.
+func image_blobs(r *registry.DockerRegistry, img *registry.DockerImage) map[string]int64 {
+	manifests := []string{img.ContentDigest}
+	if len(img.Children) > 0 {
+		manifests = nil
+		for _, child := range img.Children {
+			manifests = append(manifests, child.Digest)
+		}
+	}
+	blobs := make(map[string]int64)
+	for _, digest := range manifests {
+		m, err := r.GetManifest(cmdctx, img.Name, digest)
+		if err != nil {
+			return nil
+		}
+		descs, err := m.Blobs()
+		if err != nil || len(descs) == 0 {
+			return nil
+		}
+		for _, desc := range descs {
+			if desc.Size == 0 {
+				return nil
+			}
+			blobs[desc.Digest] = desc.Size
+		}
+	}
+	return blobs
+}
+
+func by_repo(img *registry.DockerImage) string {
+	return img.Name
+}
+
+// delete_images deletes imgs one by one and reports the result of each
+// deletion. It returns the number of failed deletions.
+func delete_images(r *registry.DockerRegistry, imgs []*registry.DockerImage) int {
+	failed := 0
+	for i, img := range imgs {
+		if cmdctx.Err() != nil {
+			fmt.Fprintf(stdout, "Interrupted, %d images were not deleted\n", len(imgs)-i)
+			return failed + len(imgs) - i
+		}
+		if maintenance != nil && !maintenance.open(clock.Now()) {
+			fmt.Fprintf(stdout, "The maintenance window closed, %d images were not deleted\n", len(imgs)-i)
+			return failed + len(imgs) - i
+		}
+		fmt.Fprintf(stdout, "Deleting (%s:%s): ", img.Name, img.Tag)
+		err := r.DeleteImage(cmdctx, img)
+		if err == nil {
+			record_deleted(img)
+			fmt.Fprintf(stdout, "SUCCESS\n")
+		} else if registry.IsImmutable(err) {
+			record_immutable(err)
+			fmt.Fprintf(stdout, "SKIPPED, %v\n", err)
+		} else {
+			failed++
+			record_delete_error(img)
+			fmt.Fprintln(stdout, err)
+		}
+	}
+	return failed
+}
+
+func main() {
+	app := cli.NewApp()
+	app.Usage = "A small utility for listing and deleting images from a Docker registry"
+	app.Version = "1.0.2"
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "url, u",
+			Usage: "The URL of your Docker Registry",
+		},
+		cli.BoolFlag{
+			Name:  "verify-tls, k",
+			Usage: "Verify the TLS cetificate of the registry",
+		},
+		cli.StringFlag{
+			Name:   "username",
+			Usage:  "Authenticate to the registry as this user",
+			EnvVar: "REGCLIENT_USERNAME",
+		},
+		cli.StringFlag{
+			Name:   "password",
+			Usage:  "Password of --username",
+			EnvVar: "REGCLIENT_PASSWORD",
+		},
+		cli.BoolFlag{
+			Name:  "no-docker-config",
+			Usage: "Don't use the credentials of docker login when no --username is given",
+		},
+		cli.StringFlag{
+			Name:   "config",
+			Usage:  "Read default flag values from this YAML file (default: ~/.config/docker-regclient/config.yaml)",
+			EnvVar: "REGCLIENT_CONFIG",
+		},
+		cli.StringFlag{
+			Name:  "flavor",
+			Value: "auto",
+			Usage: "The registry implementation: distribution, harbor, gitlab, quay, nexus, artifactory, ecr, gcr or auto to detect it",
+		},
+		cli.StringFlag{
+			Name:   "gitlab-url",
+			Usage:  "The URL of the GitLab API, required to manage repositories of a GitLab registry",
+			EnvVar: "GITLAB_URL",
+		},
+		cli.StringFlag{
+			Name:   "gitlab-token",
+			Usage:  "GitLab access token with the api scope",
+			EnvVar: "GITLAB_TOKEN",
+		},
+		cli.BoolFlag{
+			Name:   "read-only",
+			Usage:  "Never modify the registry, every request that could is refused",
+			EnvVar: "REGCLIENT_READ_ONLY",
+		},
+		cli.StringSliceFlag{
+			Name:  "protect",
+			Usage: "Never delete tags matching this pattern, eg latest or 'release-*', nor the manifests they point at",
+		},
+		cli.StringFlag{
+			Name:   "scope",
+			Usage:  "Restrict every operation to repositories below this namespace (eg team-a)",
+			EnvVar: "REGCLIENT_SCOPE",
+		},
+		cli.StringFlag{
+			Name:   "archive-url",
+			Usage:  "Refuse to delete images that don't exist with the same digest in this archive registry",
+			EnvVar: "REGCLIENT_ARCHIVE_URL",
+		},
+		cli.StringFlag{
+			Name:   "archive-username",
+			Usage:  "Authenticate to --archive-url as this user",
+			EnvVar: "REGCLIENT_ARCHIVE_USERNAME",
+		},
+		cli.StringFlag{
+			Name:   "archive-password",
+			Usage:  "Password of --archive-username",
+			EnvVar: "REGCLIENT_ARCHIVE_PASSWORD",
+		},
+		cli.StringSliceFlag{
+			Name:  "accept",
+			Usage: "Only request these manifest formats, in order of preference: schema2, oci, index or schema1 (default: all)",
+		},
+		cli.BoolFlag{
+			Name:  "no-cache",
+			Usage: "Don't reuse manifests, tag lists and configs fetched earlier in the same run",
+		},
+		cli.StringFlag{
+			Name:  "cache-memory",
+			Value: "256MB",
+			Usage: "Memory the request cache may use, 0 for no limit",
+		},
+		cli.StringFlag{
+			Name:  "cache-dir",
+			Usage: "Spill the request cache to this directory instead of dropping responses beyond --cache-memory",
+		},
+		cli.StringFlag{
+			Name:  "now",
+			Usage: "Pretend it is this date (eg 2024-01-01T00:00:00Z) for age filters, expiry dates and maintenance windows, for reproducible dry runs (the registry is never modified)",
+		},
+		cli.IntFlag{
+			Name:  "max-failures",
+			Value: 10,
+			Usage: "Stop sending requests to a registry after N consecutive failures (5xx, 401, 429 or network errors), 0 to never stop",
+		},
+		cli.IntFlag{
+			Name:  "retries",
+			Value: 3,
+			Usage: "Retry requests failing with a network error, 429 or 5xx this many times, with exponential backoff",
+		},
+		cli.DurationFlag{
+			Name:  "timeout",
+			Value: 30 * time.Second,
+			Usage: "Give up on a request when the registry doesn't answer, or stops sending the response, for this long (0 to wait forever)",
+		},
+		cli.IntFlag{
+			Name:  "concurrency",
+			Value: 16,
+			Usage: "Number of images whose details are fetched concurrently",
+		},
+		cli.Float64Flag{
+			Name:  "rate-limit",
+			Value: 20,
+			Usage: "Send at most this many requests per second to the registry, 0 for no limit",
+		},
+		cli.IntFlag{
+			Name:  "catalog-workers",
+			Value: 4,
+			Usage: "Number of namespaces processed concurrently by commands walking the catalog",
+		},
+		cli.BoolFlag{
+			Name:  "debug",
+			Usage: "Log every registry request with its ID, status and duration",
+		},
+		cli.StringFlag{
+			Name:  "request-id-header",
+			Usage: "Send the ID of every request in this header (eg X-Request-ID), to find failed requests in the registry logs",
+		},
+		cli.IntFlag{
+			Name:  "list-page-size",
+			Usage: "Maximum number of entries requested per page of the catalog, tag and Harbor artifact lists, 0 for the registry default",
+		},
+		cli.IntFlag{
+			Name:  "request-budget",
+			Usage: "Number of requests the registry allows per --request-window, scans are planned to stay within it",
+		},
+		cli.DurationFlag{
+			Name:  "request-window",
+			Value: 6 * time.Hour,
+			Usage: "The period after which the request budget is replenished",
+		},
+		cli.BoolFlag{
+			Name:  "allow-mirror",
+			Usage: "Allow deleting from registries detected as pull-through caches, or which can't be checked",
+		},
+		cli.StringSliceFlag{
+			Name:  "maintenance-window",
+			Usage: "Only delete during the minutes matched by this cron expression (eg '* 1-4 * * 6,0'), repeatable",
+		},
+		cli.StringFlag{
+			Name:  "maintenance-tz",
+			Value: "Local",
+			Usage: "Time zone of the maintenance windows (eg Europe/Tallinn)",
+		},
+		cli.BoolFlag{
+			Name:  "wait-for-window",
+			Usage: "Outside of the maintenance windows, wait for the next one instead of failing",
+		},
+		cli.StringFlag{
+			Name:   "limit-bandwidth",
+			Usage:  "Limit blob transfers to this rate per registry (eg 10MB/s or 512KiB/s)",
+			EnvVar: "REGCLIENT_LIMIT_BANDWIDTH",
+		},
+		cli.BoolFlag{
+			Name:  "pager",
+			Usage: "Page the output when it is longer than the terminal",
+		},
+		cli.StringFlag{
+			Name:   "output-file",
+			Usage:  "Write the output of the command to this file instead of STDOUT",
+			EnvVar: "REGCLIENT_OUTPUT_FILE",
+		},
+		cli.BoolFlag{
+			Name:  "append",
+			Usage: "Append to --output-file instead of replacing it",
+		},
+		cli.IntFlag{
+			Name:  "page-size",
+			Usage: "Page the output every N lines, when it goes to a terminal",
+		},
+		cli.StringFlag{
+			Name:   "otlp-endpoint",
+			Usage:  "Export OpenTelemetry traces to this OTLP/HTTP endpoint (eg http://localhost:4318)",
+			EnvVar: "REGCLIENT_OTLP_ENDPOINT",
+		},
+		cli.StringFlag{
+			Name:   "statsd-addr",
+			Usage:  "Send metrics to the statsd server at host:port",
+			EnvVar: "REGCLIENT_STATSD_ADDR",
+		},
+		cli.StringFlag{
+			Name:  "statsd-prefix",
+			Value: "regclient.",
+			Usage: "Prefix for all statsd metric names",
+		},
+		cli.StringSliceFlag{
+			Name:  "statsd-tag",
+			Usage: "Tag added to every metric (eg env:prod), requires --dogstatsd",
+		},
+		cli.BoolFlag{
+			Name:  "dogstatsd",
+			Usage: "Use the DogStatsD protocol extensions (tags)",
+		},
+		cli.StringFlag{
+			Name:   "pushgateway-url",
+			Usage:  "Push a run summary to this Prometheus Pushgateway when done",
+			EnvVar: "REGCLIENT_PUSHGATEWAY_URL",
+		},
+		cli.StringFlag{
+			Name:  "pushgateway-job",
+			Value: "docker-regclient",
+			Usage: "Job name used for the Pushgateway grouping key",
+		},
+	}
+	app.Before = func(c *cli.Context) error {
+		init_interrupt()
+		if err := load_config(c); err != nil {
+			return err
+		}
+		if err := apply_config_defaults(c, "global"); err != nil {
+			return err
+		}
+		if err := init_clock(c); err != nil {
+			return err
+		}
+		if err := init_maintenance(c); err != nil {
+			return err
+		}
+		if err := init_pager(c); err != nil {
+			return err
+		}
+		if err := init_output(c); err != nil {
+			return err
+		}
+		if err := init_tracing(c); err != nil {
+			return err
+		}
+		return init_metrics(c)
+	}
+	app.After = func(c *cli.Context) error {
+		finish_run(c)
+		return nil
+	}
+	app.ExitErrHandler = func(c *cli.Context, err error) {
+		if err != nil {
+			finish_run(c)
+		}
+		cli.HandleExitCoder(err)
+	}
+
+	app.Action = func(c *cli.Context) error {
+		if c.NArg() > 0 {
+			return run_extension(c)
+		}
+		init_registry(c)
+		return nil
+	}
+
+	app.Commands = []cli.Command{
+		{
+			Name:  "repos",
+			Usage: "Display a list of repositories in the registry",
+			Flags: outputFlags,
+			Action: instrumented("repos", func(c *cli.Context) error {
+				format, err := parse_output(c)
+				if err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				r := init_registry(c)
+				var records []repoRecord
+				_, err = walk_catalog(c, r, nil, func(repo string) func() {
+					tags, _ := r.Tags(cmdctx, repo)
+					return func() {
+						if structuredOutput {
+							records = append(records, repoRecord{repo, len(tags)})
+							return
+						}
+						fmt.Fprintf(stdout, "%s (%d tags)\n", repo, len(tags))
+					}
+				})
+				if err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				if structuredOutput {
+					return format.render_repos(records)
+				}
+				return nil
+			}),
+		},
+		{
+			Name:  "images",
+			Usage: "Display images (and possibly delete) from specified repositories",
+			Flags: append(append([]cli.Flag{
+				cli.StringSliceFlag{
+					Name: "repo, r",
+				},
+				cli.StringFlag{
+					Name:  "older-than",
+					Usage: "Match images older than a date (eg 2016-12-03, 2016-12-03T10:00:00+02:00 or 2016-W48) or an age (eg 90d, 2w, 36h)",
+				},
+				cli.StringFlag{
+					Name:  "unchanged-since",
+					Usage: "Match images whose tag has pointed at the same digest since a date or for an age (eg 60d), requires --snapshot-dir",
+				},
+				cli.StringFlag{
+					Name:  "not-pulled-since",
+					Usage: "Match images not pulled since a date or for an age (eg 90d), on registries recording pulls (Harbor, Artifactory)",
+				},
+				cli.StringFlag{
+					Name:  "snapshot-dir",
+					Usage: "Directory of snapshots (see snapshot save) used as history by --unchanged-since",
+				},
+				cli.StringFlag{
+					Name: "tag-contains",
+				},
+				cli.StringFlag{
+					Name: "tag-exclude",
+				},
+				cli.StringFlag{
+					Name:  "arch",
+					Usage: "Match images built for this architecture, optionally with variant (eg amd64, arm/v6), or multi-arch images containing it (with --delete, only built for it)",
+				},
+				cli.StringFlag{
+					Name:  "os",
+					Usage: "Match images built for this operating system (eg linux, windows), with --delete multi-arch images only if all their platforms are",
+				},
+				cli.StringSliceFlag{
+					Name:  "author",
+					Usage: "Match images whose author or maintainer label contains this name or email, ignoring case",
+				},
+				cli.BoolFlag{
+					Name:  "expired",
+					Usage: "Match images whose expiry date (see --expiry-key) has passed",
+				},
+				cli.StringFlag{
+					Name:  "expiry-key",
+					Value: "regclient.expires",
+					Usage: "Label or annotation holding the expiry date of an image (eg 2025-01-01)",
+				},
+				cli.StringSliceFlag{
+					Name:  "media-type",
+					Usage: "Match manifests of this type: schema1, schema2, oci, index or a full media type",
+				},
+				cli.BoolFlag{
+					Name:  "delete",
+					Usage: "Delete images matching all filters",
+				},
+				cli.IntFlag{
+					Name:  "exclude-latest",
+					Usage: "Return everything but the top N images per repo",
+				},
+				cli.StringFlag{
+					Name:  "branch-regex",
+					Usage: "Regular expression with a capture group extracting the branch from a tag (eg '^(.+)-[0-9a-f]{7,}$')",
+				},
+				cli.StringFlag{
+					Name:  "keep-size",
+					Usage: "Return everything but the newest images per repo fitting in this size (eg 50GiB)",
+				},
+				cli.IntFlag{
+					Name:  "keep-per-branch",
+					Value: 1,
+					Usage: "Return everything but the top N images per branch, requires --branch-regex",
+				},
+				cli.StringFlag{
+					Name:  "semver-below",
+					Usage: "Match images tagged with a semantic version lower than this one (eg 2.0.0), keeping everything from it on",
+				},
+				cli.IntFlag{
+					Name:  "keep-patches",
+					Usage: "Return everything but the N highest patch releases of every minor version (pre-releases don't count), only tags that are semantic versions match",
+				},
+				cli.StringFlag{
+					Name:  "git-remote",
+					Usage: "Match images whose git branch or tag no longer exists in this git remote",
+				},
+				cli.StringFlag{
+					Name:  "git-ref-regex",
+					Usage: "Regular expression with a capture group mapping a tag to a git ref (default: --branch-regex)",
+				},
+				cli.StringFlag{
+					Name:  "in-use-check-url",
+					Usage: "Ask this HTTP endpoint whether each selected image may be deleted (see README)",
+				},
+				cli.BoolFlag{
+					Name:  "force-shared",
+					Usage: "Also delete images whose digest is tagged by images that weren't selected, removing those tags too",
+				},
+				cli.BoolFlag{
+					Name:  "yes",
+					Usage: "Do not prompt, when deleting images",
+				},
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "With --delete, print the deletion plan and why every image is in it instead of deleting, exit with 2 if it isn't empty",
+				},
+				cli.BoolFlag{
+					Name:  "explain",
+					Usage: "Print which filters every image matched and which protections didn't keep it",
+				},
+				cli.BoolFlag{
+					Name:  "unique-digests",
+					Usage: "Print the images sharing a manifest as one row listing all their tags",
+				},
+				cli.StringFlag{
+					Name:  "group-by",
+					Usage: "Group the output, currently only 'repo' is supported",
+				},
+				cli.BoolFlag{
+					Name:  "count",
+					Usage: "Only print the number of matching images per repository",
+				},
+				cli.BoolFlag{
+					Name:  "delete-empty-repos",
+					Usage: "Delete repositories left without tags (Harbor and GitLab only)",
+				},
+				cli.BoolFlag{
+					Name:  "plan",
+					Usage: "Only estimate the number of requests and the duration of the scan",
+				},
+				cli.BoolFlag{
+					Name:  "spread",
+					Usage: "Slow the scan down if it would exceed the request budget",
+				},
+			}, outputFlags...), append(safetyFlags, gcExportFlags...)...),
+			Action: instrumented("images", func(c *cli.Context) error {
+				repos := c.StringSlice("repo")
+				if len(repos) == 0 {
+					return cli.NewExitError("You must specify at least one repository", 1)
+				}
+				if groupby := c.String("group-by"); groupby != "" && groupby != "repo" {
+					return cli.NewExitError(fmt.Sprintf("Unable to group by '%s', only 'repo' is supported", groupby), 1)
+				}
+				if c.Bool("count") && c.Bool("delete") {
+					return cli.NewExitError("--count can not be combined with --delete", 1)
+				}
+				if err := check_gc_export(c); err != nil {
+					return err
+				}
+				if c.Bool("delete") {
+					if err := refuse_fixed_clock(c.Bool("dry-run")); err != nil {
+						return err
+					}
+				}
+				format, err := parse_output(c)
+				if err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				if structuredOutput && (c.Bool("delete") || c.Bool("count") || c.Bool("explain") || c.String("group-by") != "") {
+					return cli.NewExitError(fmt.Sprintf("--output %s can not be combined with --delete, --count, --explain or --group-by", format.kind), 1)
+				}
+				limits, err := parse_safety_limits(c)
+				if err != nil {
+					return err
+				}
+
+				filters := make([]ImgFilter, 0)
+				var reasons []reason
+
+				if older := c.String("older-than"); older != "" {
+					t, err := parse_age(older, clock.Now())
+					if err != nil {
+						return cli.NewExitError(err.Error(), 1)
+					}
+					filters = append(filters, older_than_filter(t))
+					reasons = append(reasons, func(img *registry.DockerImage) string {
+						return fmt.Sprintf("--older-than %s: created %s, before %s", older, img.Created.Format(timeFormat), t.Format(timeFormat))
+					})
+				}
+
+				if unchanged := c.String("unchanged-since"); unchanged != "" {
+					t, err := parse_age(unchanged, clock.Now())
+					if err != nil {
+						return cli.NewExitError(err.Error(), 1)
+					}
+					if c.String("snapshot-dir") == "" {
+						return cli.NewExitError("--unchanged-since requires a --snapshot-dir", 1)
+					}
+					history, err := load_history(c.String("snapshot-dir"), c.GlobalString("url"))
+					if err != nil {
+						return cli.NewExitError(err.Error(), 1)
+					}
+					filters = append(filters, unchanged_filter(history, t))
+					reasons = append(reasons, func(img *registry.DockerImage) string {
+						since := unchanged_since(history, img.Name, img.Tag, img.ContentDigest)
+						return fmt.Sprintf("--unchanged-since %s: same digest since at least %s", unchanged, since.Format(timeFormat))
+					})
+				}
+
+				if contains := c.String("tag-contains"); contains != "" {
+					filters = append(filters, tag_contains_filter(contains))
+					reasons = append(reasons, func(img *registry.DockerImage) string {
+						return fmt.Sprintf("--tag-contains %s: the tag %s contains it", contains, img.Tag)
+					})
+				}
+
+				if exclude := c.String("tag-exclude"); exclude != "" {
+					filters = append(filters, tag_exclude_filter(exclude))
+					reasons = append(reasons, func(img *registry.DockerImage) string {
+						return fmt.Sprintf("--tag-exclude %s: the tag %s doesn't contain it", exclude, img.Tag)
+					})
+				}
+
+				//Deleting an index deletes all its platforms, so it is only
+				//selected for deletion if every platform matches
+				every := c.Bool("delete")
+				if arch := c.String("arch"); arch != "" {
+					filters = append(filters, func(img *registry.DockerImage) bool {
+						return has_platform(img, every, func(imgos, imgarch, variant string) bool {
+							if strings.Contains(arch, "/") {
+								return imgarch+"/"+variant == arch
+							}
+							return imgarch == arch
+						})
+					})
+					reasons = append(reasons, func(img *registry.DockerImage) string {
+						return fmt.Sprintf("--arch %s: built for %s", arch, platforms_of(img))
+					})
+				}
+
+				if wantos := c.String("os"); wantos != "" {
+					filters = append(filters, func(img *registry.DockerImage) bool {
+						return has_platform(img, every, func(imgos, imgarch, variant string) bool {
+							return imgos == wantos
+						})
+					})
+					reasons = append(reasons, func(img *registry.DockerImage) string {
+						return fmt.Sprintf("--os %s: built for %s", wantos, platforms_of(img))
+					})
+				}
+
+				if authors := c.StringSlice("author"); len(authors) > 0 {
+					filters = append(filters, author_filter(authors))
+					reasons = append(reasons, func(img *registry.DockerImage) string {
+						return fmt.Sprintf("--author %s: maintained by %s", strings.Join(authors, ", "), strings.Join(image_authors(img), ", "))
+					})
+				}
+
+				if below := c.String("semver-below"); below != "" {
+					limit, err := parse_semver_flag("--semver-below", below)
+					if err != nil {
+						return cli.NewExitError(err.Error(), 1)
+					}
+					filters = append(filters, func(img *registry.DockerImage) bool {
+						v, ok := parse_semver(img.Tag)
+						return ok && v.compare(limit) < 0
+					})
+					reasons = append(reasons, func(img *registry.DockerImage) string {
+						return fmt.Sprintf("--semver-below %s: %s is a lower version", below, img.Tag)
+					})
+				}
+				keeppatches := c.Int("keep-patches")
+				if keeppatches > 0 {
+					filters = append(filters, func(img *registry.DockerImage) bool {
+						_, ok := parse_semver(img.Tag)
+						return ok
+					})
+					reasons = append(reasons, func(img *registry.DockerImage) string {
+						return fmt.Sprintf("--keep-patches %d: not among the highest patch releases of %s", keeppatches, minor_key(img))
+					})
+				}
+
+				var branchre *regexp.Regexp
+				if pattern := c.String("branch-regex"); pattern != "" {
+					var err error
+					if branchre, err = parse_branch_regex(pattern); err != nil {
+						return cli.NewExitError(err.Error(), 1)
+					}
+					//Only tags following the branch naming scheme are candidates
+					filters = append(filters, func(img *registry.DockerImage) bool {
+						return branchre.MatchString(img.Tag)
+					})
+				}
+
+				if remote := c.String("git-remote"); remote != "" {
+					pattern := c.String("git-ref-regex")
+					if pattern == "" {
+						pattern = c.String("branch-regex")
+					}
+					refre, err := regexp.Compile(pattern)
+					if err != nil || pattern == "" || refre.NumSubexp() == 0 {
+						return cli.NewExitError("--git-remote requires a --git-ref-regex (or --branch-regex) with a capture group", 1)
+					}
+					refs, err := git_refs(remote)
+					if err != nil {
+						return cli.NewExitError(err.Error(), 1)
+					}
+					filters = append(filters, dead_ref_filter(refre, refs))
+					reasons = append(reasons, func(img *registry.DockerImage) string {
+						//Images planned along with the selected ones, like
+						//with --force-shared, may have other tags
+						m := refre.FindStringSubmatch(img.Tag)
+						if m == nil {
+							return fmt.Sprintf("--git-remote %s: error, the tag doesn't match %s", remote, pattern)
+						}
+						return fmt.Sprintf("--git-remote %s: the git ref %s of the tag no longer exists", remote, m[1])
+					})
+				}
+
+				if c.Bool("expired") {
+					filters = append(filters, expired_filter(c.String("expiry-key"), clock.Now()))
+					reasons = append(reasons, func(img *registry.DockerImage) string {
+						v, _ := image_metadata(img, c.String("expiry-key"))
+						return fmt.Sprintf("--expired: %s is %s", c.String("expiry-key"), v)
+					})
+				}
+
+				if mediatypes := c.StringSlice("media-type"); len(mediatypes) > 0 {
+					filters = append(filters, func(img *registry.DockerImage) bool {
+						for _, mt := range mediatypes {
+							if mt == img.MediaType || mt == registry.MediaTypeKind(img.MediaType) {
+								return true
+							}
+						}
+						return false
+					})
+					reasons = append(reasons, func(img *registry.DockerImage) string {
+						return fmt.Sprintf("--media-type: the manifest is %s", img.MediaType)
+					})
+				}
+
+				r := init_registry(c)
+				if notpulled := c.String("not-pulled-since"); notpulled != "" {
+					t, err := parse_age(notpulled, clock.Now())
+					if err != nil {
+						return cli.NewExitError(err.Error(), 1)
+					}
+					if f := r.Flavor(); f != registry.FlavorHarbor && f != registry.FlavorArtifactory {
+						return cli.NewExitError(fmt.Sprintf("--not-pulled-since: %v (%s)", registry.ErrPullTimeUnsupported, f), 1)
+					}
+					//Last, as it costs a request per image
+					var pullsMu sync.Mutex
+					pulls := make(map[*registry.DockerImage]time.Time)
+					filters = append(filters, pulled_filter(r, t, func(img *registry.DockerImage, pulled time.Time) {
+						pullsMu.Lock()
+						pulls[img] = pulled
+						pullsMu.Unlock()
+					}))
+					reasons = append(reasons, func(img *registry.DockerImage) string {
+						if pulls[img].IsZero() {
+							return fmt.Sprintf("--not-pulled-since %s: never pulled", notpulled)
+						}
+						return fmt.Sprintf("--not-pulled-since %s: last pulled %s, before %s", notpulled, pulls[img].Format(timeFormat), t.Format(timeFormat))
+					})
+				}
+				if c.Bool("plan") || c.Bool("spread") || c.GlobalInt("request-budget") > 0 {
+					plan := plan_scan(r, repos, c.GlobalFloat64("rate-limit"))
+					fmt.Fprintf(progress(), "Scanning %d repositories with %d tags takes about %d requests and %s\n",
+						plan.Repos, plan.Tags, plan.Requests, plan.eta().Round(time.Second))
+					if err := check_scan_budget(c, r, plan, c.Bool("spread")); err != nil || c.Bool("plan") {
+						return err
+					}
+				}
+				imgs, scanned := fetch_images(r, repos, filters, c.GlobalInt("concurrency"))
+
+				//The -exclude-latest, -keep-per-branch and -keep-patches flags
+				//require special handling, because they work on groups of images
+				keep := make(map[*registry.DockerImage]bool)
+				if exclude_latest := c.Int("exclude-latest"); exclude_latest > 0 {
+					for img := range latest_per_group(imgs, exclude_latest, by_repo) {
+						keep[img] = true
+					}
+					newer := newer_in_group(imgs, by_repo)
+					reasons = append(reasons, func(img *registry.DockerImage) string {
+						return fmt.Sprintf("--exclude-latest %d: %d newer images in %s", exclude_latest, newer[img], img.Name)
+					})
+				}
+				if branchre != nil {
+					for img := range latest_per_group(imgs, c.Int("keep-per-branch"), branch_key(branchre)) {
+						keep[img] = true
+					}
+					newer := newer_in_group(imgs, branch_key(branchre))
+					reasons = append(reasons, func(img *registry.DockerImage) string {
+						return fmt.Sprintf("--keep-per-branch %d: %d newer images of branch %s", c.Int("keep-per-branch"), newer[img], branch_key(branchre)(img))
+					})
+				}
+				if keeppatches > 0 {
+					for img := range latest_patches(imgs, keeppatches) {
+						keep[img] = true
+					}
+				}
+				if budget := c.String("keep-size"); budget != "" {
+					size, err := parse_size(budget)
+					if err != nil {
+						return cli.NewExitError("Invalid --keep-size: "+err.Error(), 1)
+					}
+					filled := make(map[string]int)
+					for img := range latest_within_size(r, imgs, size, by_repo) {
+						keep[img] = true
+						filled[img.Name]++
+					}
+					reasons = append(reasons, func(img *registry.DockerImage) string {
+						return fmt.Sprintf("--keep-size %s: the %d newer images kept in %s already fill it", budget, filled[img.Name], img.Name)
+					})
+				}
+				imgs = drop_kept(imgs, keep)
+				if url := c.String("in-use-check-url"); url != "" {
+					var answers map[*registry.DockerImage]string
+					imgs, answers = veto_in_use(url, imgs)
+					reasons = append(reasons, func(img *registry.DockerImage) string {
+						if answers[img] == "" {
+							return "--in-use-check-url: the endpoint answered delete"
+						}
+						return fmt.Sprintf("--in-use-check-url: the endpoint answered delete (%s)", answers[img])
+					})
+				}
+				//Deleting is planned per manifest anyway, collapsing only
+				//changes what is printed
+				shown := imgs
+				var collapsed map[*registry.DockerImage][]string
+				if c.Bool("unique-digests") {
+					shown, collapsed = collapse_digests(imgs)
+				}
+				if c.Bool("count") {
+					print_counts(repos, shown)
+					return nil
+				}
+				if structuredOutput {
+					return format.render_images(shown, collapsed)
+				}
+				if len(imgs) == 0 {
+					return nil
+				}
+
+				if c.String("group-by") == "repo" {
+					print_images_grouped(shown, collapsed)
+				} else {
+					print_images(shown, collapsed)
+				}
+				if c.Bool("explain") && !(c.Bool("delete") && c.Bool("dry-run")) {
+					print_explanations(imgs, reasons)
+				}
+				if c.Bool("delete") {
+					imgs = drop_pinned(r, imgs)
+					imgs = require_archived(c, imgs)
+					if err := refuse_mirrors(c, r, imgs); err != nil {
+						return err
+					}
+					imgs = preflight_deletes(r, imgs, c.Bool("dry-run"))
+					if err := limits.check(imgs, scanned); err != nil {
+						return err
+					}
+					plan := plan_deletes(r, imgs)
+					if c.Bool("force-shared") {
+						force_shared(plan)
+					}
+					limits.limit(plan)
+					report_skipped(plan, true)
+					if err := export_gc(c, plan); err != nil {
+						return err
+					}
+					if c.Bool("dry-run") {
+						print_delete_plan(plan)
+						reasons = append(reasons, protection_reasons(c, r, plan)...)
+						var planned []*registry.DockerImage
+						for _, step := range plan.Steps {
+							planned = append(planned, step...)
+						}
+						print_explanations(planned, reasons)
+						return dry_run_result(plan.images())
+					}
+					if len(plan.Steps) == 0 {
+						return nil
+					}
+					if !c.Bool("yes") {
+						if !Confirm(fmt.Sprintf("Do you really want to delete these %d images? (y/n): ", plan.images())) {
+							return nil
+						}
+					}
+					if err := wait_for_window(c); err != nil {
+						return err
+					}
+					run_delete_plan(r, plan)
+					report_empty_repos(r, repos, c.Bool("delete-empty-repos"))
+				}
+				return nil
+			}),
+		},
+		{
+			Name:  "delete",
+			Usage: "Reads lines containing repository:tag from STDIN and deletes the respective images from the Registry",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "Only print the deletion plan, exit with 2 if it isn't empty",
+				},
+				cli.BoolFlag{
+					Name:  "force-shared",
+					Usage: "Also delete images whose digest is tagged by images that weren't given, removing those tags too",
+				},
+			},
+			Action: instrumented("delete", func(c *cli.Context) error {
+				dryrun := c.Bool("dry-run")
+				if err := refuse_fixed_clock(dryrun); err != nil {
+					return err
+				}
+				r := init_registry(c)
+
+				//All images are read first, to find the tags sharing their
+				//digest outside of the selection
+				var imgs []*registry.DockerImage
+				scanner := bufio.NewScanner(os.Stdin)
+				for scanner.Scan() {
+					imagetext := scanner.Text()
+					img, err := r.ImageDetails(cmdctx, imagetext)
+
+					if err != nil {
+						fmt.Fprintf(stdout, "Unable to retrieve details for %s\n", imagetext)
+						continue
+					}
+
+					imgs = append(imgs, img)
+				}
+				if err := scanner.Err(); err != nil {
+					return cli.NewExitError(fmt.Sprintf("Unable to read the images from STDIN: %v", err), 1)
+				}
+				imgs = require_archived(c, drop_pinned(r, imgs))
+				if err := refuse_mirrors(c, r, imgs); err != nil {
+					return err
+				}
+				imgs = preflight_deletes(r, imgs, dryrun)
+				plan := plan_deletes(r, imgs)
+				if c.Bool("force-shared") {
+					force_shared(plan)
+				}
+				report_skipped(plan, true)
+				if dryrun {
+					print_delete_plan(plan)
+					return dry_run_result(plan.images())
+				}
+				if err := wait_for_window(c); err != nil {
+					return err
+				}
+				run_delete_plan(r, plan)
+				return nil
+			}),
+		},
+		migrateSchema1Command,
+		annotateCommand,
+		deleteRepoCommand,
+		snapshotCommand,
+		searchCommand,
+		baseImagesCommand,
+		layerSharingCommand,
+		copyCommand,
+		syncCommand,
+		pinCommand,
+		unpinCommand,
+		untaggedCommand,
+		selfUpdateCommand,
+		formatsCommand,
+		checkCommand,
+		quotaCommand,
+		provenanceCommand,
+		whoamiCommand,
+		policyCommand,
+		blobCommand,
+		treeCommand,
+		pruneCommand,
+		retagCommand,
+		promoteCommand,
+	}
+	app.Run(os.Args)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+// metricsSink receives counters and timings emitted while a command runs.
+// Tags are "key:value" strings and are dropped by sinks that don't support
+// them.
+type metricsSink interface {
+	Count(name string, n int64, tags ...string)
+	Timing(name string, d time.Duration, tags ...string)
+	Close() error
+}
+
+type nopSink struct{}
+
+func (nopSink) Count(name string, n int64, tags ...string)          {}
+func (nopSink) Timing(name string, d time.Duration, tags ...string) {}
+func (nopSink) Close() error                                        { return nil }
+
+var metrics metricsSink = nopSink{}
+
+// statsdSink writes metrics using the plain statsd line protocol over UDP.
+// With dogstatsd enabled, tags are appended in the Datadog "|#" format.
+type statsdSink struct {
+	conn      net.Conn
+	prefix    string
+	tags      []string
+	dogstatsd bool
+}
+
+func newStatsdSink(addr, prefix string, tags []string, dogstatsd bool) (*statsdSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &statsdSink{conn: conn, prefix: prefix, tags: tags, dogstatsd: dogstatsd}, nil
+}
+
+func (s *statsdSink) send(name, value, kind string, tags []string) {
+	line := fmt.Sprintf("%s%s:%s|%s", s.prefix, name, value, kind)
+	if s.dogstatsd {
+		if all := append(append([]string{}, s.tags...), tags...); len(all) > 0 {
+			line = fmt.Sprintf("%s|#%s", line, strings.Join(all, ","))
+		}
+	}
+	//Metrics are best effort, a lost datagram must never fail a cleanup run
+	if _, err := s.conn.Write([]byte(line)); err != nil {
+		log.Printf("Unable to send metric %s: %v", name, err)
+	}
+}
+
+func (s *statsdSink) Count(name string, n int64, tags ...string) {
+	s.send(name, fmt.Sprintf("%d", n), "c", tags)
+}
+
+func (s *statsdSink) Timing(name string, d time.Duration, tags ...string) {
+	s.send(name, fmt.Sprintf("%d", d.Milliseconds()), "ms", tags)
+}
+
+func (s *statsdSink) Close() error {
+	return s.conn.Close()
+}
+
+// init_metrics sets up the global metrics sink from the --statsd-* flags
+func init_metrics(c *cli.Context) error {
+	addr := c.GlobalString("statsd-addr")
+	if addr == "" {
+		return nil
+	}
+	sink, err := newStatsdSink(addr, c.GlobalString("statsd-prefix"), c.GlobalStringSlice("statsd-tag"), c.GlobalBool("dogstatsd"))
+	if err != nil {
+		return cli.NewExitError("Unable to set up statsd: "+err.Error(), 1)
+	}
+	metrics = sink
+	return nil
+}
+
+// observe_requests reports every registry API request to the metrics sink,
+// and logs it with --debug
+func observe_requests(c *cli.Context, r *registry.DockerRegistry) {
+	debug := c.GlobalBool("debug")
+	r.OnRequest(func(s registry.RequestStats) {
+		if debug {
+			log.Printf("DEBUG: request %s: %s %s: %d in %v", s.RequestID, s.Method, s.URL, s.StatusCode, s.Duration)
+		}
+		tags := []string{"method:" + s.Method, fmt.Sprintf("status:%d", s.StatusCode)}
+		metrics.Count("api.requests", 1, tags...)
+		metrics.Timing("api.request_duration", s.Duration, tags...)
+		if s.Err != nil {
+			metrics.Count("api.errors", 1, tags...)
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+// migrate_schema1 rewrites repo:tag as a schema2 manifest if it is
+// currently stored as schema1. It returns the schema1 manifest that was
+// replaced, nil if nothing had to be done.
+func migrate_schema1(r *registry.DockerRegistry, repo, tag string, dryrun bool) (*registry.DockerImage, error) {
+	old, err := r.GetManifest(cmdctx, repo, tag)
+	if err != nil {
+		return nil, err
+	}
+	if registry.MediaTypeKind(old.MediaType) != "schema1" {
+		return nil, nil
+	}
+
+	converted, config, err := r.ConvertSchema1(cmdctx, repo, old)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(stdout, "%s:%s %s -> %s\n", repo, tag, old.Digest, converted.Digest)
+	if dryrun {
+		//The tag still points at the schema1 manifest, it is planned as
+		//if it was deleted with it
+		return &registry.DockerImage{Name: repo, Tag: tag, ContentDigest: old.Digest}, nil
+	}
+
+	configdigest := registry.Digest(config)
+	if exists, err := r.BlobExists(cmdctx, repo, configdigest); err != nil {
+		return nil, err
+	} else if !exists {
+		if err := r.PushBlob(cmdctx, repo, configdigest, bytes.NewReader(config), int64(len(config))); err != nil {
+			return nil, fmt.Errorf("Unable to push image config: %v", err)
+		}
+	}
+	if _, err := r.PutManifest(cmdctx, repo, tag, converted); err != nil {
+		return nil, fmt.Errorf("Unable to push schema2 manifest: %v", err)
+	}
+	return &registry.DockerImage{Name: repo, ContentDigest: old.Digest}, nil
+}
+
+var migrateSchema1Command = cli.Command{
+	Name:      "migrate-schema1",
+	Usage:     "Converts schema1 images to schema2 and pushes them under the same tag",
+	ArgsUsage: "[repository:tag...]",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "repo, r",
+			Usage: "Migrate every schema1 tag of this repository",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Only show which images would be converted, and the deletion plan of --delete-old",
+		},
+		cli.BoolFlag{
+			Name:  "delete-old",
+			Usage: "Delete the old schema1 manifests after retagging, unless other tags still point at them",
+		},
+	},
+	Action: instrumented("migrate-schema1", func(c *cli.Context) error {
+		if c.NArg() == 0 && len(c.StringSlice("repo")) == 0 {
+			return cli.NewExitError("You must specify images or at least one repository", 1)
+		}
+		r := init_registry(c)
+
+		var refs [][2]string
+		for _, arg := range c.Args() {
+			repo, tag, err := registry.ParseReference(arg)
+			if err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+			refs = append(refs, [2]string{repo, tag})
+		}
+		for _, repo := range c.StringSlice("repo") {
+			tags, err := r.Tags(cmdctx, repo)
+			if err != nil {
+				return cli.NewExitError(fmt.Sprintf("Unable to list tags of %s: %v", repo, err), 1)
+			}
+			for _, tag := range tags {
+				refs = append(refs, [2]string{repo, tag})
+			}
+		}
+
+		var converted, failed int
+		var replaced []*registry.DockerImage
+		for _, ref := range refs {
+			old, err := migrate_schema1(r, ref[0], ref[1], c.Bool("dry-run"))
+			if err != nil {
+				failed++
+				record_error()
+				fmt.Fprintf(stdout, "%s:%s FAILED: %v\n", ref[0], ref[1], err)
+			} else if old != nil {
+				converted++
+				replaced = append(replaced, old)
+			}
+		}
+		if c.Bool("delete-old") && len(replaced) > 0 {
+			notdeleted, err := delete_replaced(c, r, replaced, c.Bool("dry-run"))
+			if err != nil {
+				return err
+			}
+			failed += notdeleted
+		}
+		fmt.Fprintf(stdout, "%d schema1 images converted, %d failed\n", converted, failed)
+		if failed > 0 {
+			return cli.NewExitError("", 1)
+		}
+		return nil
+	}),
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+)
+
+// notifyConfig routes the summary of a prune run to the teams owning the
+// images, by the value of a label or annotation of the images:
+//
+//	notify:
+//	  owner-label: team
+//	  teams:
+//	    payments: https://hooks.slack.com/services/...
+//	  default: https://hooks.slack.com/services/...
+//
+// Images without an owner, or owned by a team without a webhook, are
+// reported to default, if set.
+type notifyConfig struct {
+	OwnerLabel string            `yaml:"owner-label"`
+	Teams      map[string]string `yaml:"teams"`
+	Default    string            `yaml:"default"`
+}
+
+func (n *notifyConfig) validate() error {
+	if n.OwnerLabel == "" {
+		return fmt.Errorf("notify must set owner-label")
+	}
+	for team, webhook := range n.Teams {
+		if !strings.HasPrefix(webhook, "https://") && !strings.HasPrefix(webhook, "http://") {
+			return fmt.Errorf("notify: the webhook of team '%s' is not a URL", team)
+		}
+	}
+	if n.Default != "" && !strings.HasPrefix(n.Default, "https://") && !strings.HasPrefix(n.Default, "http://") {
+		return fmt.Errorf("notify: default is not a URL")
+	}
+	return nil
+}
+
+// owner_of returns the team owning img, empty if it has no owner label
+func (n *notifyConfig) owner_of(img *registry.DockerImage) string {
+	owner, _ := image_metadata(img, n.OwnerLabel)
+	return owner
+}
+
+// ownerSummary is the part of a prune run a team is told about
+type ownerSummary struct {
+	owner   string
+	webhook string
+	deleted []string
+	failed  []string
+	//skipped were not deleted, being immutable or left when the
+	//maintenance window closed or the run was interrupted
+	skipped []string
+}
+
+// route_summaries splits the images of plan by owner. Owners sharing a
+// webhook, like every team falling back to default, get a summary each.
+// Before the plan was run, all its images count as deleted.
+func (n *notifyConfig) route_summaries(plan *deletePlan) []*ownerSummary {
+	byowner := make(map[string]*ownerSummary)
+	for _, step := range plan.Steps {
+		for _, img := range step {
+			owner := n.owner_of(img)
+			webhook, ok := n.Teams[owner]
+			if !ok {
+				webhook = n.Default
+			}
+			if webhook == "" {
+				continue
+			}
+			s := byowner[owner]
+			if s == nil {
+				s = &ownerSummary{owner: owner, webhook: webhook}
+				byowner[owner] = s
+			}
+			switch {
+			case plan.Deleted == nil || plan.Deleted[img]:
+				s.deleted = append(s.deleted, img.Name+":"+img.Tag)
+			case plan.Failed[img]:
+				s.failed = append(s.failed, img.Name+":"+img.Tag)
+			default:
+				s.skipped = append(s.skipped, img.Name+":"+img.Tag)
+			}
+		}
+	}
+	var summaries []*ownerSummary
+	for _, s := range byowner {
+		summaries = append(summaries, s)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].owner < summaries[j].owner })
+	return summaries
+}
+
+// text formats the summary as a chat message, for dry runs listing what
+// would be deleted
+func (s *ownerSummary) text(url string, dryrun bool) string {
+	owner := "without an owner"
+	if s.owner != "" {
+		owner = "owned by " + s.owner
+	}
+	var b strings.Builder
+	if dryrun {
+		fmt.Fprintf(&b, "prune on %s would delete %d images %s:\n", url, len(s.deleted), owner)
+	} else {
+		fmt.Fprintf(&b, "prune on %s deleted %d images %s:\n", url, len(s.deleted), owner)
+	}
+	for _, ref := range s.deleted {
+		fmt.Fprintf(&b, "• %s\n", ref)
+	}
+	if len(s.failed) > 0 {
+		fmt.Fprintf(&b, "%d images could not be deleted:\n", len(s.failed))
+		for _, ref := range s.failed {
+			fmt.Fprintf(&b, "• %s\n", ref)
+		}
+	}
+	if len(s.skipped) > 0 {
+		fmt.Fprintf(&b, "%d images were not deleted, being immutable or left when the maintenance window closed or the run was interrupted:\n", len(s.skipped))
+		for _, ref := range s.skipped {
+			fmt.Fprintf(&b, "• %s\n", ref)
+		}
+	}
+	return b.String()
+}
+
+// notify_owners posts the summary of every owner to its webhook, as the
+// {"text": ...} payload of Slack incoming webhooks, which Mattermost and
+// Teams accept as well. Failures are logged, not returned, the deletions
+// having been made already.
+func notify_owners(r *registry.DockerRegistry, n *notifyConfig, plan *deletePlan, dryrun bool) {
+	if n == nil {
+		return
+	}
+	for _, s := range n.route_summaries(plan) {
+		if err := post_json(s.webhook, map[string]string{"text": s.text(r.URL, dryrun)}, nil); err != nil {
+			record_error()
+			log.Printf("Unable to notify the owners of %d images (%s): %v", len(s.deleted)+len(s.failed)+len(s.skipped), s.owner, err)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+const timeFormat = "2006-01-02 15:04:05"
+
+// stdout receives all regular output of the commands, it may be wrapped by
+// a pager
+var stdout io.Writer = os.Stdout
+
+var outputFile *os.File
+
+// init_output sends the output to --output-file instead, truncating it
+// unless --append is given. It takes precedence over the pager.
+func init_output(c *cli.Context) error {
+	path := c.GlobalString("output-file")
+	if path == "" {
+		return nil
+	}
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if c.GlobalBool("append") {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Unable to open the output file: %v", err), 1)
+	}
+	outputFile = f
+	stdout = f
+	return nil
+}
+
+func close_output() error {
+	if outputFile == nil {
+		return nil
+	}
+	return outputFile.Close()
+}
+
+// human_size formats a byte count using binary units
+func human_size(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+// collapse_digests keeps the first image of every manifest of imgs. It also
+// returns the tags of all images of its manifest for every image kept, which
+// print_image and render_images list with it.
+func collapse_digests(imgs []*registry.DockerImage) ([]*registry.DockerImage, map[*registry.DockerImage][]string) {
+	collapsed := make(map[*registry.DockerImage][]string)
+	first := make(map[string]*registry.DockerImage)
+	var unique []*registry.DockerImage
+	for _, img := range imgs {
+		key := img.Name + "@" + img.ContentDigest
+		if f, ok := first[key]; ok {
+			collapsed[f] = append(collapsed[f], img.Tag)
+			continue
+		}
+		first[key] = img
+		collapsed[img] = []string{img.Tag}
+		unique = append(unique, img)
+	}
+	return unique, collapsed
+}
+
+// image_tags returns the tags printed for img, all tags of its digest if
+// they were collapsed
+func image_tags(img *registry.DockerImage, collapsed map[*registry.DockerImage][]string) []string {
+	if tags, ok := collapsed[img]; ok {
+		return tags
+	}
+	return []string{img.Tag}
+}
+
+// print_image prints one image, followed by its platforms if it is a
+// manifest list or index. collapsed holds the tags of the images standing
+// for their whole manifest, it may be nil.
+func print_image(prefix string, img *registry.DockerImage, collapsed map[*registry.DockerImage][]string) {
+	var platforms string
+	if p := img.Platforms(); len(p) > 0 {
+		platforms = " [" + strings.Join(p, ", ") + "]"
+	}
+	fmt.Fprintf(stdout, "%s%s %s %s:%s%s\n", prefix, img.Created.Format(timeFormat), short_digest(img.ContentDigest), img.Name, strings.Join(image_tags(img, collapsed), ","), platforms)
+}
+
+func print_images(imgs []*registry.DockerImage, collapsed map[*registry.DockerImage][]string) {
+	for _, img := range imgs {
+		print_image("", img, collapsed)
+	}
+}
+
+// group_by_repo splits imgs per repository, keeping the order of the
+// repositories as they first appear and the order of images within them
+func group_by_repo(imgs []*registry.DockerImage) ([]string, map[string][]*registry.DockerImage) {
+	var repos []string
+	groups := make(map[string][]*registry.DockerImage)
+	for _, img := range imgs {
+		if _, ok := groups[img.Name]; !ok {
+			repos = append(repos, img.Name)
+		}
+		groups[img.Name] = append(groups[img.Name], img)
+	}
+	return repos, groups
+}
+
+// print_images_grouped prints the images under a heading per repository
+// followed by a subtotal line
+func print_images_grouped(imgs []*registry.DockerImage, collapsed map[*registry.DockerImage][]string) {
+	repos, groups := group_by_repo(imgs)
+	var total int64
+	for _, repo := range repos {
+		fmt.Fprintf(stdout, "%s\n", repo)
+		var size int64
+		oldest, newest := groups[repo][0].Created, groups[repo][0].Created
+		for _, img := range groups[repo] {
+			print_image("  ", img, collapsed)
+			size += img.Size
+			if img.Created.Before(oldest) {
+				oldest = img.Created
+			}
+			if img.Created.After(newest) {
+				newest = img.Created
+			}
+		}
+		total += size
+		fmt.Fprintf(stdout, "  -- %d images, %s, oldest %s, newest %s\n\n", len(groups[repo]), human_size(size),
+			oldest.Format(timeFormat), newest.Format(timeFormat))
+	}
+	fmt.Fprintf(stdout, "Total: %d images in %d repositories, %s\n", len(imgs), len(repos), human_size(total))
+}
+
+// print_counts prints the number of images per requested repository and the
+// overall total, repositories without matches are reported with 0
+func print_counts(repos []string, imgs []*registry.DockerImage) {
+	_, groups := group_by_repo(imgs)
+	for _, repo := range repos {
+		fmt.Fprintf(stdout, "%s %d\n", repo, len(groups[repo]))
+	}
+	fmt.Fprintf(stdout, "total %d\n", len(imgs))
+}
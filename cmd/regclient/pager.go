@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/urfave/cli"
+	"golang.org/x/term"
+)
+
+// pager writes output in pages of size lines and waits for the user to
+// continue after each page. Once the user quits, further output is dropped.
+// Writes of concurrent workers are serialized.
+type pager struct {
+	mu    sync.Mutex
+	w     io.Writer
+	tty   *bufio.Reader
+	size  int
+	lines int
+	quit  bool
+}
+
+func (p *pager) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	written := len(b)
+	for len(b) > 0 && !p.quit {
+		i := bytes.IndexByte(b, '\n')
+		if i < 0 {
+			_, err := p.w.Write(b)
+			return written, err
+		}
+		if _, err := p.w.Write(b[:i+1]); err != nil {
+			return written, err
+		}
+		b = b[i+1:]
+		if p.lines++; p.lines >= p.size {
+			p.prompt()
+		}
+	}
+	return written, nil
+}
+
+func (p *pager) prompt() {
+	fmt.Fprint(os.Stderr, "-- More -- (Enter to continue, q to quit) ")
+	ans, err := p.tty.ReadString('\n')
+	p.quit = err != nil || strings.TrimSpace(ans) == "q"
+	p.lines = 0
+}
+
+// init_pager wraps stdout in a pager when --page-size or --pager is given
+// and stdout is a terminal, output redirected to a file or pipe is never
+// paged. Answers are read from the terminal, so commands reading STDIN keep
+// working.
+func init_pager(c *cli.Context) error {
+	if !term.IsTerminal(int(os.Stdout.Fd())) {
+		return nil
+	}
+	size := c.GlobalInt("page-size")
+	if size <= 0 && c.GlobalBool("pager") {
+		if _, height, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+			size = height - 1
+		}
+	}
+	if size <= 0 {
+		return nil
+	}
+	tty, err := os.Open(ttyPath)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Unable to open the terminal for paging: %v", err), 1)
+	}
+	stdout = &pager{w: os.Stdout, tty: bufio.NewReader(tty), size: size}
+	return nil
+}
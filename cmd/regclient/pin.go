@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+// errNotPinned stops updating the pins when unpinning a digest that isn't
+// pinned
+var errNotPinned = errors.New("Not pinned")
+
+// drop_pinned removes every image whose digest is pinned or whose tag is
+// protected, and the pins artifacts themselves, from a list of images about
+// to be deleted. Protected tags sharing the digest of an image are only
+// noticed by the client, when deleting.
+func drop_pinned(r *registry.DockerRegistry, imgs []*registry.DockerImage) []*registry.DockerImage {
+	pinned := make(map[string]map[string]string)
+	var allowed []*registry.DockerImage
+	for _, img := range imgs {
+		if r.Protected(img.Tag) {
+			log.Printf("Keeping %s:%s, protected", img.Name, img.Tag)
+			continue
+		}
+		pins, ok := pinned[img.Name]
+		if !ok {
+			var pinsdigest string
+			var err error
+			if pins, pinsdigest, err = r.Pins(cmdctx, img.Name); err != nil {
+				//Without knowing the pins nothing in the repository is safe
+				log.Printf("Unable to read the pins of %s, keeping its images: %v", img.Name, err)
+				pins = nil
+			} else if pinsdigest != "" {
+				pins[pinsdigest] = registry.PinsTag
+			}
+			pinned[img.Name] = pins
+		}
+		if pins == nil {
+			continue
+		}
+		if note, ok := pins[img.ContentDigest]; ok {
+			log.Printf("Keeping %s:%s, pinned (%s)", img.Name, img.Tag, note)
+			continue
+		}
+		allowed = append(allowed, img)
+	}
+	return allowed
+}
+
+// update_pins pins or unpins the digest every argument resolves to. A dry
+// run prints the changes and the previous pins artifacts it would delete.
+func update_pins(c *cli.Context, pin bool) error {
+	if c.NArg() == 0 {
+		return cli.NewExitError("You must specify at least one image", 1)
+	}
+	dryrun := c.Bool("dry-run")
+	r := init_registry(c)
+	//Dry runs don't write the pins, the changes to a repository add up here
+	pending := make(map[string]map[string]string)
+	replaced := 0
+	for _, arg := range c.Args() {
+		repo, ref, err := registry.ParseReference(arg)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		digest, err := r.ManifestDigest(cmdctx, repo, ref)
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("Unable to resolve %s: %v", arg, err), 1)
+		}
+		note := fmt.Sprintf("%s pinned at %s", arg, clock.Now().UTC().Format(time.RFC3339))
+		//change applies the argument to the pins, false if unpinning a digest
+		//that isn't pinned
+		change := func(pins map[string]string) bool {
+			if pin {
+				pins[digest] = note
+				return true
+			}
+			if _, ok := pins[digest]; !ok {
+				return false
+			}
+			delete(pins, digest)
+			return true
+		}
+		if dryrun {
+			pins, pinsdigest, err := r.Pins(cmdctx, repo)
+			if err != nil {
+				return cli.NewExitError(fmt.Sprintf("Unable to read the pins of %s: %v", repo, err), 1)
+			}
+			if p, ok := pending[repo]; ok {
+				pins = p
+			}
+			if !change(pins) {
+				fmt.Fprintf(stdout, "%s %s is not pinned\n", arg, digest)
+				continue
+			}
+			if _, ok := pending[repo]; !ok && pinsdigest != "" {
+				fmt.Fprintf(stdout, "Would delete the previous pins %s@%s\n", repo, pinsdigest)
+				replaced++
+			}
+			pending[repo] = pins
+			if pin {
+				fmt.Fprintf(stdout, "Would pin %s %s\n", arg, digest)
+			} else {
+				fmt.Fprintf(stdout, "Would unpin %s %s\n", arg, digest)
+			}
+			continue
+		}
+		err = r.UpdatePins(cmdctx, repo, func(pins map[string]string) error {
+			if !change(pins) {
+				return errNotPinned
+			}
+			return nil
+		})
+		if err == errNotPinned {
+			fmt.Fprintf(stdout, "%s %s is not pinned\n", arg, digest)
+			continue
+		} else if err != nil {
+			return cli.NewExitError(fmt.Sprintf("Unable to update the pins of %s: %v", repo, err), 1)
+		}
+		if pin {
+			fmt.Fprintf(stdout, "Pinned %s %s\n", arg, digest)
+		} else {
+			fmt.Fprintf(stdout, "Unpinned %s %s\n", arg, digest)
+		}
+	}
+	if dryrun {
+		return dry_run_result(replaced)
+	}
+	return nil
+}
+
+// pinFlags are the flags of pin and unpin
+var pinFlags = []cli.Flag{
+	cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "Print the changes, and the previous pins artifact that would be deleted, without writing them",
+	},
+}
+
+var pinCommand = cli.Command{
+	Name:      "pin",
+	Usage:     "Protects the digest of an image from every delete and prune",
+	ArgsUsage: "repository:tag...",
+	Flags:     pinFlags,
+	Action: instrumented("pin", func(c *cli.Context) error {
+		return update_pins(c, true)
+	}),
+}
+
+var unpinCommand = cli.Command{
+	Name:      "unpin",
+	Usage:     "Removes the protection added with pin",
+	ArgsUsage: "repository:tag...",
+	Flags:     pinFlags,
+	Action: instrumented("unpin", func(c *cli.Context) error {
+		return update_pins(c, false)
+	}),
+}
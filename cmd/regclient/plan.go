@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+// scanPlan estimates the requests needed to scan a set of repositories
+type scanPlan struct {
+	Repos    int
+	Tags     int
+	Requests int
+	//Rate is the number of requests per second the scan is sent at, 0 for
+	//no limit
+	Rate float64
+}
+
+func (p *scanPlan) eta() time.Duration {
+	if p.Rate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(p.Requests) / p.Rate * float64(time.Second))
+}
+
+// plan_scan lists the tags of repos to estimate the size of a scan. The
+// manifest of the first tag is resolved as well, so the quota advertised by
+// the registry is known before the scan starts.
+func plan_scan(r *registry.DockerRegistry, repos []string, rate float64) *scanPlan {
+	p := &scanPlan{Repos: len(repos), Rate: rate}
+	probed := false
+	for _, repo := range repos {
+		tags, err := r.Tags(cmdctx, repo)
+		if err != nil {
+			log.Printf("Unable to get tags of %s: %s", repo, err)
+			continue
+		}
+		if !probed && len(tags) > 0 {
+			_, err := r.ManifestDigest(cmdctx, repo, tags[0])
+			probed = err == nil
+		}
+		p.Tags += len(tags)
+	}
+	p.Requests = p.Repos + 2*p.Tags
+	return p
+}
+
+// check_scan_budget compares the plan with the request budget, configured
+// with --request-budget or advertised by the registry, whichever is lower.
+// If the scan doesn't fit and spreading is allowed, the request rate of the
+// registry client is lowered so the scan stays within the quota as it is
+// replenished.
+func check_scan_budget(c *cli.Context, r *registry.DockerRegistry, p *scanPlan, spread bool) error {
+	budget, window := c.GlobalInt("request-budget"), c.GlobalDuration("request-window")
+	remaining := budget
+	if rl, ok := r.RateLimit(); ok && (budget == 0 || rl.Remaining < remaining) {
+		fmt.Fprintf(progress(), "The registry allows %d requests per %s, %d remaining\n", rl.Limit, rl.Window, rl.Remaining)
+		budget, remaining, window = rl.Limit, rl.Remaining, rl.Window
+	}
+	if budget == 0 || p.Requests <= remaining {
+		return nil
+	}
+	if window <= 0 {
+		return cli.NewExitError(fmt.Sprintf("The scan needs about %d requests, but only %d are available", p.Requests, remaining), 1)
+	}
+	slower := float64(budget) / window.Seconds()
+	if !spread {
+		return cli.NewExitError(fmt.Sprintf("The scan needs about %d requests, but only %d of %d per %s are available. Use --spread to spread it over about %s",
+			p.Requests, remaining, budget, window, (&scanPlan{Requests: p.Requests, Rate: slower}).eta().Round(time.Minute)), 1)
+	}
+	if p.Rate <= 0 || slower < p.Rate {
+		p.Rate = slower
+		r.SetRequestRate(slower)
+	}
+	fmt.Fprintf(progress(), "Spreading the scan to %.2f requests per second, it will take about %s\n", p.Rate, p.eta().Round(time.Second))
+	return nil
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+// simPolicy is the part of a retention policy which can be evaluated on
+// snapshots. Snapshots only record the digest of every tag, so the age of an
+// image is the time its digest was first seen under the tag.
+type simPolicy struct {
+	olderThan     string
+	tagContains   string
+	tagExclude    string
+	excludeLatest int
+	branchre      *regexp.Regexp
+	keepPerBranch int
+}
+
+// select_images returns the images the policy deletes at now. imgs must be
+// sorted newest first.
+func (p *simPolicy) select_images(imgs []*registry.DockerImage, now time.Time) ([]*registry.DockerImage, error) {
+	var filters []ImgFilter
+	if p.olderThan != "" {
+		t, err := parse_age(p.olderThan, now)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, older_than_filter(t))
+	}
+	if p.tagContains != "" {
+		filters = append(filters, tag_contains_filter(p.tagContains))
+	}
+	if p.tagExclude != "" {
+		filters = append(filters, tag_exclude_filter(p.tagExclude))
+	}
+	if p.branchre != nil {
+		filters = append(filters, func(img *registry.DockerImage) bool { return p.branchre.MatchString(img.Tag) })
+	}
+
+	var matching []*registry.DockerImage
+	for _, img := range imgs {
+		if matches_filters(img, filters) {
+			matching = append(matching, img)
+		}
+	}
+
+	keep := make(map[*registry.DockerImage]bool)
+	if p.excludeLatest > 0 {
+		for img := range latest_per_group(matching, p.excludeLatest, by_repo) {
+			keep[img] = true
+		}
+	}
+	if p.branchre != nil {
+		for img := range latest_per_group(matching, p.keepPerBranch, branch_key(p.branchre)) {
+			keep[img] = true
+		}
+	}
+	return drop_kept(matching, keep), nil
+}
+
+// policy_selector evaluates a policy file like prune does: the rule of every
+// repository selects among its images, and images sharing the digest of a
+// tag matching the top-level protect patterns are kept. imgs must be sorted
+// newest first.
+func policy_selector(p *retentionPolicy) simSelector {
+	return func(imgs []*registry.DockerImage, now time.Time) ([]*registry.DockerImage, error) {
+		protected := make(map[string]bool)
+		byrepo := make(map[string][]*registry.DockerImage)
+		var repos []string
+		for _, img := range imgs {
+			if matches_any(p.Protect, img.Tag) {
+				protected[img.Name+"@"+img.ContentDigest] = true
+			}
+			if p.rule_for(img.Name) == nil {
+				continue
+			}
+			if byrepo[img.Name] == nil {
+				repos = append(repos, img.Name)
+			}
+			byrepo[img.Name] = append(byrepo[img.Name], img)
+		}
+		var selected []*registry.DockerImage
+		for _, repo := range repos {
+			for _, img := range p.rule_for(repo).select_images(byrepo[repo], now) {
+				if !protected[img.Name+"@"+img.ContentDigest] {
+					selected = append(selected, img)
+				}
+			}
+		}
+		return selected, nil
+	}
+}
+
+// simSelector returns the images a policy deletes at now, imgs are sorted
+// newest first
+type simSelector func(imgs []*registry.DockerImage, now time.Time) ([]*registry.DockerImage, error)
+
+// simStep is what the policy deletes at the time of one snapshot
+type simStep struct {
+	Taken   time.Time
+	Deleted []*registry.DockerImage
+}
+
+// simulate replays the policy selecting with sel against every snapshot of history, oldest
+// first. A tag deleted in one step is gone in the following ones, unless a
+// later snapshot shows it pushed again with another digest. It also returns
+// the deletions (repo:tag@digest) followed by such a push, which hint at the
+// policy removing images still in use.
+func simulate(sel simSelector, history []*Snapshot, repos []string) ([]simStep, map[string]bool, error) {
+	only := make(map[string]bool)
+	for _, repo := range repos {
+		only[repo] = true
+	}
+	firstseen := make(map[string]time.Time)
+	deleted := make(map[string]string)
+	pushedagain := make(map[string]bool)
+	var steps []simStep
+	for _, s := range history {
+		var imgs []*registry.DockerImage
+		for repo, tags := range s.Repositories {
+			if len(only) > 0 && !only[repo] {
+				continue
+			}
+			for tag, digest := range tags {
+				ref := repo + ":" + tag
+				if d, ok := deleted[ref]; ok {
+					if d == digest {
+						continue
+					}
+					delete(deleted, ref)
+					pushedagain[ref+"@"+d] = true
+				}
+				key := ref + "@" + digest
+				if _, ok := firstseen[key]; !ok {
+					firstseen[key] = s.Taken
+				}
+				imgs = append(imgs, &registry.DockerImage{Name: repo, Tag: tag, ContentDigest: digest, Created: firstseen[key]})
+			}
+		}
+		sort.Slice(imgs, func(i, j int) bool {
+			if !imgs[i].Created.Equal(imgs[j].Created) {
+				return imgs[i].Created.After(imgs[j].Created)
+			}
+			return imgs[i].Name+":"+imgs[i].Tag < imgs[j].Name+":"+imgs[j].Tag
+		})
+		selected, err := sel(imgs, s.Taken)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, img := range selected {
+			deleted[img.Name+":"+img.Tag] = img.ContentDigest
+		}
+		steps = append(steps, simStep{s.Taken, selected})
+	}
+	return steps, pushedagain, nil
+}
+
+var policyCommand = cli.Command{
+	Name:  "policy",
+	Usage: "Validates retention policies before enabling them",
+	Subcommands: []cli.Command{
+		{
+			Name:  "simulate",
+			Usage: "Replays a retention policy against the snapshots of the registry and reports what it would have deleted over time",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "history",
+					Usage: "Directory of snapshots (see snapshot save) to replay the policy against",
+				},
+				cli.StringSliceFlag{
+					Name:  "repo, r",
+					Usage: "Only simulate this repository (default: every repository in the snapshots)",
+				},
+				cli.StringFlag{
+					Name:  "policy",
+					Usage: "Retention policy file to simulate, as evaluated by prune, instead of the images flags",
+				},
+				cli.StringFlag{
+					Name:  "older-than",
+					Usage: "Delete images first seen before a date or longer ago than an age (eg 90d) at the time of each snapshot",
+				},
+				cli.StringFlag{
+					Name: "tag-contains",
+				},
+				cli.StringFlag{
+					Name: "tag-exclude",
+				},
+				cli.IntFlag{
+					Name:  "exclude-latest",
+					Usage: "Keep the top N images per repo",
+				},
+				cli.StringFlag{
+					Name:  "branch-regex",
+					Usage: "Regular expression with a capture group extracting the branch from a tag",
+				},
+				cli.IntFlag{
+					Name:  "keep-per-branch",
+					Value: 1,
+					Usage: "Keep the top N images per branch, requires --branch-regex",
+				},
+			},
+			Action: instrumented("policy simulate", func(c *cli.Context) error {
+				if c.String("history") == "" {
+					return cli.NewExitError("You must specify the snapshot directory with --history", 1)
+				}
+				p := &simPolicy{
+					olderThan:     c.String("older-than"),
+					tagContains:   c.String("tag-contains"),
+					tagExclude:    c.String("tag-exclude"),
+					excludeLatest: c.Int("exclude-latest"),
+					keepPerBranch: c.Int("keep-per-branch"),
+				}
+				if pattern := c.String("branch-regex"); pattern != "" {
+					var err error
+					if p.branchre, err = parse_branch_regex(pattern); err != nil {
+						return cli.NewExitError(err.Error(), 1)
+					}
+				}
+				flags := p.olderThan != "" || p.tagContains != "" || p.tagExclude != "" || p.excludeLatest > 0 || p.branchre != nil
+				sel := p.select_images
+				if file := c.String("policy"); file != "" {
+					if flags {
+						return cli.NewExitError("--policy can't be combined with the images flags", 1)
+					}
+					policy, err := load_policy(file, clock.Now())
+					if err != nil {
+						return cli.NewExitError(err.Error(), 1)
+					}
+					sel = policy_selector(policy)
+				} else if !flags {
+					return cli.NewExitError("You must specify a policy, eg --policy cleanup.yaml or --older-than 90d --exclude-latest 5", 1)
+				}
+				history, err := load_history(c.String("history"), c.GlobalString("url"))
+				if err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				steps, pushedagain, err := simulate(sel, history, c.StringSlice("repo"))
+				if err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+
+				total := 0
+				for _, step := range steps {
+					fmt.Fprintf(stdout, "%s %d images deleted\n", step.Taken.Format(timeFormat), len(step.Deleted))
+					for _, img := range step.Deleted {
+						mark := ""
+						if pushedagain[img.Name+":"+img.Tag+"@"+img.ContentDigest] {
+							mark = " (pushed again later)"
+						}
+						fmt.Fprintf(stdout, "  %s %s:%s first seen %s%s\n", short_digest(img.ContentDigest), img.Name, img.Tag,
+							img.Created.Format(timeFormat), mark)
+					}
+					total += len(step.Deleted)
+				}
+				fmt.Fprintf(stdout, "Total: %d images deleted over %d snapshots, %d of their tags pushed again later\n",
+					total, len(steps), len(pushedagain))
+				return nil
+			}),
+		},
+	},
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+var promoteCommand = cli.Command{
+	Name:      "promote",
+	Usage:     "Copies an image to another repository of the registry by mounting its blobs, without transferring or storing them again",
+	ArgsUsage: "source destination",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "require-digest",
+			Usage: "Only promote if the source resolves to exactly this digest (eg sha256:...)",
+		},
+		cli.BoolFlag{
+			Name:  "allow-copy",
+			Usage: "Transfer the blobs the registry refuses to mount, instead of failing",
+		},
+	},
+	Action: instrumented("promote", func(c *cli.Context) error {
+		if c.NArg() != 2 {
+			return cli.NewExitError("You must specify the source and destination images, eg team/app:1.2.3 prod/app:1.2.3", 1)
+		}
+		required := c.String("require-digest")
+		if required != "" && !strings.HasPrefix(required, "sha256:") {
+			return cli.NewExitError("--require-digest must be a sha256 digest", 1)
+		}
+		srcrepo, srcref, err := registry.ParseReference(c.Args().Get(0))
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		dstrepo, dstref, err := registry.ParseReference(c.Args().Get(1))
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		r := init_registry(c)
+		var mounted, present int64
+		opts := registry.CopyOptions{
+			RequireDigest: required,
+			Workers:       4,
+			MountOnly:     !c.Bool("allow-copy"),
+			OnBlob: func(blob registry.Descriptor, added bool) {
+				if added {
+					atomic.AddInt64(&mounted, 1)
+				} else {
+					atomic.AddInt64(&present, 1)
+				}
+			},
+		}
+		digest, err := registry.CopyImage(cmdctx, r, srcrepo, srcref, r, dstrepo, dstref, opts)
+		if err != nil {
+			record_error()
+			if opts.MountOnly {
+				return cli.NewExitError(fmt.Sprintf("Promotion failed: %v (use --allow-copy to transfer blobs the registry doesn't mount)", err), 1)
+			}
+			return cli.NewExitError(fmt.Sprintf("Promotion failed: %v", err), 1)
+		}
+		fmt.Fprintf(stdout, "%s -> %s %s (%d blobs added, %d already present)\n", c.Args().Get(0), c.Args().Get(1), digest, mounted, present)
+		return nil
+	}),
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+// fetch_image_layers resolves the layers of a single image, for indexes
+// those of the first image they contain
+func fetch_image_layers(r *registry.DockerRegistry, image string) (*layeredImage, error) {
+	repo, tag, err := registry.ParseReference(image)
+	if err != nil {
+		return nil, err
+	}
+	m, err := r.GetManifest(cmdctx, repo, tag)
+	if err != nil {
+		return nil, err
+	}
+	if registry.MediaTypeKind(m.MediaType) == "index" {
+		children, err := m.Children()
+		if err != nil {
+			return nil, err
+		}
+		if len(children) == 0 {
+			return nil, fmt.Errorf("Manifest list %s is empty", image)
+		}
+		if m, err = r.GetManifest(cmdctx, repo, children[0].Digest); err != nil {
+			return nil, err
+		}
+	}
+	layers, err := m.Layers()
+	if err != nil {
+		return nil, err
+	}
+	annotations, _ := m.Annotations()
+	return &layeredImage{repo, image, layers, annotations}, nil
+}
+
+// base_chain returns img followed by its base, the base of the base and so
+// on. Every step is the known base sharing the longest proper layer prefix.
+func base_chain(img *layeredImage, bases []*layeredImage) []*layeredImage {
+	chain := []*layeredImage{img}
+	for {
+		current := chain[len(chain)-1]
+		var smaller []*layeredImage
+		for _, base := range bases {
+			if len(base.Layers) < len(current.Layers) {
+				smaller = append(smaller, base)
+			}
+		}
+		base := closest_base(current, smaller)
+		if base == nil {
+			return chain
+		}
+		chain = append(chain, base)
+	}
+}
+
+var provenanceCommand = cli.Command{
+	Name:      "provenance",
+	Usage:     "Shows the chain of base images an image is built on and where each of its layers comes from",
+	ArgsUsage: "repository:tag",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "base-repo",
+			Usage: "Repository containing base images, used to find bases by matching layers",
+		},
+	},
+	Action: instrumented("provenance", func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return cli.NewExitError("You must specify exactly one image", 1)
+		}
+		r := init_registry(c)
+		img, err := fetch_image_layers(r, c.Args().First())
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		chain := base_chain(img, fetch_layered(r, c.StringSlice("base-repo")))
+
+		fmt.Fprintf(stdout, "%s (%d layers)\n", img.Ref, len(img.Layers))
+		for i, base := range chain[1:] {
+			fmt.Fprintf(stdout, "%*sbuilt on %s (%d layers)\n", 2*(i+1), "", base.Ref, len(base.Layers))
+		}
+		//Beyond the known bases, only the annotation of the last one can
+		//tell what it was built on
+		last := chain[len(chain)-1]
+		if name := last.Annotations[annotationBaseName]; name != "" {
+			if digest := last.Annotations[annotationBaseDigest]; digest != "" {
+				name += "@" + digest
+			}
+			fmt.Fprintf(stdout, "%*sbuilt on %s (according to its annotations)\n", 2*len(chain), "", name)
+		}
+
+		fmt.Fprintf(stdout, "Layers:\n")
+		for i, layer := range img.Layers {
+			//The deepest base containing the layer introduced it
+			origin := img.Ref
+			for _, base := range chain[1:] {
+				if i < len(base.Layers) {
+					origin = base.Ref
+				}
+			}
+			fmt.Fprintf(stdout, "%3d %s %10s %s\n", i+1, layer.Digest, human_size(layer.Size), origin)
+		}
+		return nil
+	}),
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+	"gopkg.in/yaml.v3"
+)
+
+// retentionPolicy is a policy file evaluated by prune:
+//
+//	rules:
+//	  - repos: [team-a/*]
+//	    keep-last: 5
+//	    max-age: 90d
+//	    protect: [latest, release-*]
+//	  - repos: [libs/*]
+//	    keep-patches: 3
+//	    keep-from: 2.0.0
+//	  - keep-last: 20
+//	protect: [prod-*]
+//	notify:
+//	  owner-label: team
+//	  teams: {payments: https://hooks.slack.com/services/...}
+//
+// The first rule whose patterns match a repository applies to it, a rule
+// without repos matches every repository. The tags matching the top-level
+// protect patterns are protected like with --protect, in every repository
+// and also when they point at the manifest of another tag. notify sends the
+// summary of the deletions to the teams owning the images, see notifyConfig.
+type retentionPolicy struct {
+	Rules   []retentionRule `yaml:"rules"`
+	Protect []string        `yaml:"protect"`
+	Notify  *notifyConfig   `yaml:"notify"`
+}
+
+// retentionRule keeps the keep-last newest images of a repository and those
+// younger than max-age, the others are deleted. Tags matching protect are
+// never deleted and don't count towards keep-last. With keep-patches the
+// rule only applies to tags that are semantic versions, keeping the highest
+// patch releases of every minor version, and keep-from keeps every version
+// from the given one on.
+type retentionRule struct {
+	Repos       []string `yaml:"repos"`
+	KeepLast    int      `yaml:"keep-last"`
+	MaxAge      string   `yaml:"max-age"`
+	Protect     []string `yaml:"protect"`
+	KeepPatches int      `yaml:"keep-patches"`
+	KeepFrom    string   `yaml:"keep-from"`
+}
+
+func load_policy(file string, now time.Time) (*retentionPolicy, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var p retentionPolicy
+	if err := yaml.Unmarshal(content, &p); err != nil {
+		return nil, fmt.Errorf("Unable to parse policy %s: %v", file, err)
+	}
+	if len(p.Rules) == 0 {
+		return nil, fmt.Errorf("Policy %s has no rules", file)
+	}
+	for _, pattern := range p.Protect {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Invalid protect pattern '%s' in %s", pattern, file)
+		}
+	}
+	if p.Notify != nil {
+		if err := p.Notify.validate(); err != nil {
+			return nil, fmt.Errorf("Policy %s: %v", file, err)
+		}
+	}
+	for i, rule := range p.Rules {
+		if rule.KeepLast <= 0 && rule.MaxAge == "" && rule.KeepPatches <= 0 {
+			return nil, fmt.Errorf("Rule %d of %s must set keep-last, max-age or keep-patches", i+1, file)
+		}
+		if rule.KeepFrom != "" {
+			if _, err := parse_semver_flag("keep-from", rule.KeepFrom); err != nil {
+				return nil, fmt.Errorf("Rule %d of %s: %v", i+1, file, err)
+			}
+		}
+		if rule.MaxAge != "" {
+			if _, err := parse_age(rule.MaxAge, now); err != nil {
+				return nil, fmt.Errorf("Rule %d of %s: %v", i+1, file, err)
+			}
+		}
+		for _, pattern := range append(append([]string{}, rule.Repos...), rule.Protect...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("Rule %d of %s: invalid pattern '%s'", i+1, file, pattern)
+			}
+		}
+	}
+	return &p, nil
+}
+
+// matches_any reports whether s matches one of the glob patterns, in which
+// * doesn't match a /
+func matches_any(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// rule_for returns the rule applying to repo, or nil if no rule does
+func (p *retentionPolicy) rule_for(repo string) *retentionRule {
+	for i, rule := range p.Rules {
+		if len(rule.Repos) == 0 || matches_any(rule.Repos, repo) {
+			return &p.Rules[i]
+		}
+	}
+	return nil
+}
+
+// select_images returns the images of a repository the rule deletes at now.
+// imgs must be sorted newest first.
+func (rule *retentionRule) select_images(imgs []*registry.DockerImage, now time.Time) []*registry.DockerImage {
+	var cutoff time.Time
+	if rule.MaxAge != "" {
+		cutoff, _ = parse_age(rule.MaxAge, now)
+	}
+	var patches map[*registry.DockerImage]bool
+	if rule.KeepPatches > 0 {
+		patches = latest_patches(imgs, rule.KeepPatches)
+	}
+	from, keepfrom := parse_semver(rule.KeepFrom)
+	var selected []*registry.DockerImage
+	kept := 0
+	for _, img := range imgs {
+		if matches_any(rule.Protect, img.Tag) {
+			continue
+		}
+		v, versioned := parse_semver(img.Tag)
+		if (keepfrom && versioned && v.compare(from) >= 0) || patches[img] || (patches != nil && !versioned) {
+			continue
+		}
+		if kept < rule.KeepLast {
+			kept++
+			continue
+		}
+		if rule.MaxAge == "" || img.Created.Before(cutoff) {
+			selected = append(selected, img)
+		}
+	}
+	return selected
+}
+
+// explain describes why the rule didn't keep img at now
+func (rule *retentionRule) explain(img *registry.DockerImage, now time.Time) string {
+	repos := "every repository"
+	if len(rule.Repos) > 0 {
+		repos = strings.Join(rule.Repos, ", ")
+	}
+	why := []string{fmt.Sprintf("rule for %s", repos)}
+	if rule.KeepLast > 0 {
+		why = append(why, fmt.Sprintf("not among the %d newest images kept", rule.KeepLast))
+	}
+	if rule.MaxAge != "" {
+		cutoff, _ := parse_age(rule.MaxAge, now)
+		why = append(why, fmt.Sprintf("max-age %s: created %s, before %s", rule.MaxAge, img.Created.Format(timeFormat), cutoff.Format(timeFormat)))
+	}
+	if rule.KeepPatches > 0 {
+		why = append(why, fmt.Sprintf("keep-patches %d: not among the highest patch releases of its minor version", rule.KeepPatches))
+	}
+	return strings.Join(why, ", ")
+}
+
+var pruneCommand = cli.Command{
+	Name:  "prune",
+	Usage: "Evaluates a retention policy file against the registry and deletes what it doesn't keep",
+	Flags: append([]cli.Flag{
+		cli.StringFlag{
+			Name:  "policy",
+			Usage: "YAML file with the retention rules per repository",
+		},
+		cli.StringSliceFlag{
+			Name:  "repo, r",
+			Usage: "Only evaluate the policy for this repository (default: the whole catalog)",
+		},
+		cli.BoolFlag{
+			Name:  "delete",
+			Usage: "Delete the images the policy doesn't keep, instead of only listing them",
+		},
+		cli.BoolFlag{
+			Name:  "yes",
+			Usage: "Do not prompt, when deleting images",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "With --delete, print the deletion plan and why every image is in it instead of deleting, exit with 2 if it isn't empty",
+		},
+		cli.BoolFlag{
+			Name:  "explain",
+			Usage: "Print which rule didn't keep every image and which protections didn't keep it",
+		},
+		cli.BoolFlag{
+			Name:  "notify-dry-run",
+			Usage: "Also send the notifications of the policy for dry runs, listing what would be deleted",
+		},
+		cli.BoolFlag{
+			Name:  "force-shared",
+			Usage: "Also delete images whose digest is tagged by images the policy keeps, removing those tags too",
+		},
+	}, append(safetyFlags, gcExportFlags...)...),
+	Action: instrumented("prune", func(c *cli.Context) error {
+		if c.String("policy") == "" {
+			return cli.NewExitError("You must specify the policy file with --policy", 1)
+		}
+		if err := check_gc_export(c); err != nil {
+			return err
+		}
+		if c.Bool("delete") {
+			if err := refuse_fixed_clock(c.Bool("dry-run")); err != nil {
+				return err
+			}
+		}
+		limits, err := parse_safety_limits(c)
+		if err != nil {
+			return err
+		}
+		now := clock.Now()
+		policy, err := load_policy(c.String("policy"), now)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		r := init_registry(c)
+		if err := r.SetProtectedTags(append(c.GlobalStringSlice("protect"), policy.Protect...)); err != nil {
+			return cli.NewExitError(fmt.Sprintf("Invalid protect in %s: %v", c.String("policy"), err), 1)
+		}
+		repos := c.StringSlice("repo")
+		if len(repos) == 0 {
+			if repos, err = r.Repos(cmdctx); err != nil {
+				return cli.NewExitError(fmt.Sprintf("Unable to list repositories: %v", err), 1)
+			}
+		}
+		var covered []string
+		for _, repo := range repos {
+			if policy.rule_for(repo) != nil {
+				covered = append(covered, repo)
+			}
+		}
+
+		//The rules are applied as every repository is fetched, only the
+		//images they don't keep stay in memory until the end of the run
+		deleted := make(map[string][]*registry.DockerImage)
+		totals := make(map[string]int)
+		each_repo_images(r, covered, nil, c.GlobalInt("concurrency"), func(repo string, imgs []*registry.DockerImage, scanned int) {
+			sort.Sort(ByCreated(imgs))
+			deleted[repo] = policy.rule_for(repo).select_images(imgs, now)
+			totals[repo] = len(imgs)
+		})
+		if err := interrupt_error(); err != nil {
+			return err
+		}
+		var selected []*registry.DockerImage
+		for _, repo := range covered {
+			if totals[repo] == 0 {
+				continue
+			}
+			fmt.Fprintf(stdout, "%s: %d of %d images not kept\n", repo, len(deleted[repo]), totals[repo])
+			for _, img := range deleted[repo] {
+				print_image("  ", img, nil)
+			}
+			selected = append(selected, deleted[repo]...)
+		}
+		reasons := []reason{func(img *registry.DockerImage) string {
+			return policy.rule_for(img.Name).explain(img, now)
+		}}
+		if c.Bool("explain") && !(c.Bool("delete") && c.Bool("dry-run")) {
+			print_explanations(selected, reasons)
+		}
+		if !c.Bool("delete") || len(selected) == 0 {
+			return nil
+		}
+
+		selected = drop_pinned(r, selected)
+		selected = require_archived(c, selected)
+		if err := refuse_mirrors(c, r, selected); err != nil {
+			return err
+		}
+		selected = preflight_deletes(r, selected, c.Bool("dry-run"))
+		sort.Sort(ByCreated(selected))
+		if err := limits.check(selected, totals); err != nil {
+			return err
+		}
+		plan := plan_deletes(r, selected)
+		if c.Bool("force-shared") {
+			force_shared(plan)
+		}
+		limits.limit(plan)
+		report_skipped(plan, true)
+		if err := export_gc(c, plan); err != nil {
+			return err
+		}
+		if c.Bool("dry-run") {
+			print_delete_plan(plan)
+			reasons = append(reasons, protection_reasons(c, r, plan)...)
+			var planned []*registry.DockerImage
+			for _, step := range plan.Steps {
+				planned = append(planned, step...)
+			}
+			print_explanations(planned, reasons)
+			if c.Bool("notify-dry-run") {
+				notify_owners(r, policy.Notify, plan, true)
+			}
+			return dry_run_result(plan.images())
+		}
+		if len(plan.Steps) == 0 {
+			return nil
+		}
+		if !c.Bool("yes") {
+			if !Confirm(fmt.Sprintf("Do you really want to delete these %d images? (y/n): ", plan.images())) {
+				return nil
+			}
+		}
+		if err := wait_for_window(c); err != nil {
+			return err
+		}
+		failed := run_delete_plan(r, plan)
+		notify_owners(r, policy.Notify, plan, false)
+		if failed > 0 {
+			return cli.NewExitError(fmt.Sprintf("%d images were not deleted", failed), 1)
+		}
+		return nil
+	}),
+}
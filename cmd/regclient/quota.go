@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+var quotaCommand = cli.Command{
+	Name:  "quota",
+	Usage: "Reports storage quota utilization per namespace and the repositories using it (Harbor)",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "repo, r",
+			Usage: "Report on this repository (default: the whole catalog)",
+		},
+		cli.StringFlag{
+			Name:  "warn-at",
+			Value: "80%",
+			Usage: "Flag namespaces using at least this share of their quota",
+		},
+	},
+	Action: instrumented("quota", func(c *cli.Context) error {
+		warnat, err := parse_percent(c.String("warn-at"))
+		if err != nil {
+			return cli.NewExitError("Invalid --warn-at: "+err.Error(), 1)
+		}
+		r := init_registry(c)
+		if r.Flavor() != registry.FlavorHarbor {
+			return cli.NewExitError(registry.ErrQuotaUnsupported.Error(), 1)
+		}
+
+		var mu sync.Mutex
+		quotas := make(map[string]*registry.Quota)
+		usage := make(map[string]map[string]int64)
+		_, err = walk_catalog(c, r, c.StringSlice("repo"), func(repo string) func() {
+			ns := namespace_of(repo)
+			mu.Lock()
+			_, known := quotas[ns]
+			mu.Unlock()
+			if !known {
+				q, err := r.Quota(cmdctx, repo)
+				if err != nil {
+					record_error()
+					log.Printf("Unable to get the quota of %s: %v", repo, err)
+					return nil
+				}
+				mu.Lock()
+				quotas[ns] = q
+				mu.Unlock()
+			}
+
+			manifests, err := r.ListManifests(cmdctx, repo)
+			if err != nil {
+				record_error()
+				log.Printf("Unable to get the size of %s: %v", repo, err)
+				return nil
+			}
+			var size int64
+			for _, m := range manifests {
+				size += m.Size
+			}
+			return func() {
+				if usage[ns] == nil {
+					usage[ns] = make(map[string]int64)
+				}
+				usage[ns][repo] = size
+			}
+		})
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		var namespaces []string
+		for ns := range quotas {
+			namespaces = append(namespaces, ns)
+		}
+		//Namespaces closest to their quota first, as they need pruning first
+		sort.Slice(namespaces, func(i, j int) bool {
+			return quotas[namespaces[i]].Utilization() > quotas[namespaces[j]].Utilization()
+		})
+		for _, ns := range namespaces {
+			q := quotas[ns]
+			if q.Limit <= 0 {
+				fmt.Fprintf(stdout, "%s %s used, no limit\n", q.Namespace, human_size(q.Used))
+			} else {
+				var flag string
+				if q.Utilization() >= warnat {
+					flag = " NEAR QUOTA"
+				}
+				fmt.Fprintf(stdout, "%s %s of %s used (%.1f%%)%s\n", q.Namespace, human_size(q.Used), human_size(q.Limit), q.Utilization(), flag)
+			}
+
+			var repos []string
+			for repo := range usage[ns] {
+				repos = append(repos, repo)
+			}
+			sort.Slice(repos, func(i, j int) bool { return usage[ns][repos[i]] > usage[ns][repos[j]] })
+			for _, repo := range repos {
+				fmt.Fprintf(stdout, "  %s %s\n", repo, human_size(usage[ns][repo]))
+			}
+		}
+		return nil
+	}),
+}
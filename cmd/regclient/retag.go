@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+// validTag matches the tags the Registry API accepts
+var validTag = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+
+var retagCommand = cli.Command{
+	Name:      "retag",
+	Usage:     "Adds tags to an image by pushing its manifest again under them, without transferring any blobs",
+	ArgsUsage: "repository:tag newtag...",
+	Flags: []cli.Flag{
+		cli.BoolFlag{
+			Name:  "move",
+			Usage: "Move tags already pointing at a different image, instead of refusing",
+		},
+	},
+	Action: instrumented("retag", func(c *cli.Context) error {
+		if c.NArg() < 2 {
+			return cli.NewExitError("You must specify an image and at least one new tag, eg webserver:build-42 stable", 1)
+		}
+		repo, ref, err := registry.ParseReference(c.Args().First())
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		for _, tag := range c.Args().Tail() {
+			if !validTag.MatchString(tag) {
+				return cli.NewExitError(fmt.Sprintf("'%s' is not a valid tag", tag), 1)
+			}
+		}
+
+		r := init_registry(c)
+		m, err := r.GetManifest(cmdctx, repo, ref)
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("Unable to fetch %s: %v", c.Args().First(), err), 1)
+		}
+		if registry.MediaTypeKind(m.MediaType) == "schema1" {
+			return cli.NewExitError("Signed schema1 manifests contain their tag and can't be retagged, convert the image with migrate-schema1 first", 1)
+		}
+		for _, tag := range c.Args().Tail() {
+			current, err := r.ManifestDigest(cmdctx, repo, tag)
+			if err != nil && !registry.IsNotFound(err) {
+				return cli.NewExitError(fmt.Sprintf("Unable to resolve %s:%s: %v", repo, tag, err), 1)
+			}
+			if current == m.Digest {
+				fmt.Fprintf(stdout, "%s:%s already points at %s\n", repo, tag, m.Digest)
+				continue
+			}
+			if current != "" && !c.Bool("move") {
+				return cli.NewExitError(fmt.Sprintf("%s:%s points at %s, use --move to move it", repo, tag, current), 1)
+			}
+			digest, err := r.PutManifest(cmdctx, repo, tag, m)
+			if err != nil {
+				return cli.NewExitError(fmt.Sprintf("Unable to tag %s:%s: %v", repo, tag, err), 1)
+			}
+			if digest != m.Digest {
+				return cli.NewExitError(fmt.Sprintf("The registry stored %s:%s as %s instead of %s", repo, tag, digest, m.Digest), 1)
+			}
+			fmt.Fprintf(stdout, "%s:%s -> %s\n", repo, tag, digest)
+		}
+		return nil
+	}),
+}
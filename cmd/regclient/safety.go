@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+// safetyFlags bound how much a run deletes, against a misconfigured filter
+// or policy selecting far more images than intended
+var safetyFlags = []cli.Flag{
+	cli.IntFlag{
+		Name:  "max-deletes",
+		Usage: "Delete at most N tags per run (of the oldest images), including the tags removed along with --force-shared",
+	},
+	cli.StringFlag{
+		Name:  "sample",
+		Usage: "Only delete this percentage of the planned tags (eg 10%), rounded up to at least one, as a canary run",
+	},
+	cli.StringFlag{
+		Name:  "abort-if-over",
+		Usage: "Refuse to delete when more than this percentage of a repository's images is selected (eg 50%)",
+	},
+	cli.BoolFlag{
+		Name:  "force",
+		Usage: "Delete even if --abort-if-over is exceeded",
+	},
+}
+
+// safetyLimits holds the parsed safetyFlags
+type safetyLimits struct {
+	sample    float64
+	abortOver float64
+	max       int
+	force     bool
+}
+
+// parse_safety_limits validates the safetyFlags before the scan
+func parse_safety_limits(c *cli.Context) (*safetyLimits, error) {
+	sample, err := parse_percent(c.String("sample"))
+	if err != nil {
+		return nil, cli.NewExitError("Invalid --sample: "+err.Error(), 1)
+	}
+	abortover, err := parse_percent(c.String("abort-if-over"))
+	if err != nil {
+		return nil, cli.NewExitError("Invalid --abort-if-over: "+err.Error(), 1)
+	}
+	return &safetyLimits{sample: sample, abortOver: abortover, max: c.Int("max-deletes"), force: c.Bool("force")}, nil
+}
+
+// check refuses deleting more than --abort-if-over of any repository, unless
+// forced. scanned counts the images of every repository.
+func (l *safetyLimits) check(imgs []*registry.DockerImage, scanned map[string]int) error {
+	if err := check_candidate_ratio(imgs, scanned, l.abortOver); err != nil {
+		if !l.force {
+			return cli.NewExitError(err.Error()+", use --force to delete anyway", 1)
+		}
+		log.Printf("WARNING: %v", err)
+	}
+	return nil
+}
+
+// limit bounds plan to the --sample and --max-deletes. It is applied to the
+// final plan, after force_shared added the tags sharing a digest.
+func (l *safetyLimits) limit(plan *deletePlan) {
+	limit_deletions(plan, l.sample, l.max)
+}
+
+// parse_percent parses "10%" or "10" into 10. An empty string means 100%.
+func parse_percent(s string) (float64, error) {
+	if s == "" {
+		return 100, nil
+	}
+	p, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	if err != nil || p < 0 || p > 100 {
+		return 0, fmt.Errorf("'%s' is not a percentage between 0 and 100", s)
+	}
+	return p, nil
+}
+
+// limit_deletions bounds the tags plan removes in one run to sample percent,
+// rounded up so that a sample of any plan deletes at least one tag, and to
+// at most max tags (0 means unlimited). The unselected tags removed by forced
+// steps count as well. The steps deleting the oldest images are kept, in
+// their planned order.
+func limit_deletions(plan *deletePlan, sample float64, max int) {
+	size := func(step []*registry.DockerImage) int {
+		return len(step) + len(plan.Forced[step[0].Name+"@"+step[0].ContentDigest])
+	}
+	total := 0
+	for _, step := range plan.Steps {
+		total += size(step)
+	}
+	n := total
+	if sample < 100 {
+		n = int(math.Ceil(float64(total) * sample / 100))
+	}
+	if max > 0 && n > max {
+		n = max
+	}
+	if n >= total {
+		return
+	}
+
+	oldest := func(step []*registry.DockerImage) time.Time {
+		t := step[0].Created
+		for _, img := range step[1:] {
+			if img.Created.Before(t) {
+				t = img.Created
+			}
+		}
+		return t
+	}
+	order := make([]int, len(plan.Steps))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return oldest(plan.Steps[order[i]]).Before(oldest(plan.Steps[order[j]]))
+	})
+	keep := make(map[int]bool)
+	kept := 0
+	for _, i := range order {
+		if kept+size(plan.Steps[i]) <= n {
+			keep[i] = true
+			kept += size(plan.Steps[i])
+		}
+	}
+	var steps [][]*registry.DockerImage
+	for i, step := range plan.Steps {
+		if keep[i] {
+			steps = append(steps, step)
+		} else {
+			delete(plan.Forced, step[0].Name+"@"+step[0].ContentDigest)
+		}
+	}
+	plan.Steps = steps
+	fmt.Fprintf(stdout, "Safety limits apply, only %d of the %d tags planned, those of the oldest images, will be deleted\n", kept, total)
+}
+
+// check_candidate_ratio returns an error naming every repository of which
+// more than limit percent of the scanned images are selected for deletion
+func check_candidate_ratio(imgs []*registry.DockerImage, scanned map[string]int, limit float64) error {
+	if limit >= 100 {
+		return nil
+	}
+	selected := make(map[string]int)
+	for _, img := range imgs {
+		selected[img.Name]++
+	}
+	var over []string
+	for repo, n := range selected {
+		if ratio := float64(n) * 100 / float64(scanned[repo]); ratio > limit {
+			over = append(over, fmt.Sprintf("%s (%d of %d images)", repo, n, scanned[repo]))
+		}
+	}
+	if len(over) > 0 {
+		sort.Strings(over)
+		return fmt.Errorf("More than %g%% of the images would be deleted in %s", limit, strings.Join(over, ", "))
+	}
+	return nil
+}
+
+// deleteChecks remembers the delete permission of every registry, so a run
+// only probes it once
+var (
+	deleteChecks   = make(map[*registry.DockerRegistry]error)
+	deleteChecksMu sync.Mutex
+)
+
+// preflight_deletes checks the delete permission before anything is
+// deleted. Every probe is a DELETE request the registry may record in its
+// audit log, so only the repository of the first image is probed and the
+// answer holds for the whole registry. If deletes would fail, every image is
+// dropped. The check sends a DELETE request, so dry runs and read-only
+// clients keep every image.
+func preflight_deletes(r *registry.DockerRegistry, imgs []*registry.DockerImage, dryrun bool) []*registry.DockerImage {
+	if dryrun || r.ReadOnly() || len(imgs) == 0 {
+		return imgs
+	}
+	deleteChecksMu.Lock()
+	err, checked := deleteChecks[r]
+	if !checked {
+		err = r.CheckDeletePermission(cmdctx, imgs[0].Name)
+		deleteChecks[r] = err
+	}
+	deleteChecksMu.Unlock()
+	if err == nil {
+		return imgs
+	}
+	fmt.Fprintf(stdout, "Images can't be deleted from the registry and will be skipped: %v\n", err)
+	return nil
+}
+
+// mirrorChecks remembers the result of probing every repository, so a run
+// only probes each of them once. Some registries mirror single repositories
+// of a namespace.
+var (
+	mirrorChecks   = make(map[string]error)
+	mirrorChecksMu sync.Mutex
+)
+
+// refuse_mirrors fails if any image about to be deleted is stored in a
+// pull-through cache, or if that can't be told, unless --allow-mirror is
+// given
+func refuse_mirrors(c *cli.Context, r *registry.DockerRegistry, imgs []*registry.DockerImage) error {
+	if c.GlobalBool("allow-mirror") {
+		return nil
+	}
+	for _, img := range imgs {
+		mirrorChecksMu.Lock()
+		err, checked := mirrorChecks[img.Name]
+		if !checked {
+			mirror, probeerr := r.IsPullThroughCache(cmdctx, img.Name)
+			if probeerr != nil {
+				err = cli.NewExitError(fmt.Sprintf("Unable to check whether %s is a pull-through cache, deleting from it is refused without --allow-mirror: %v", img.Name, probeerr), 1)
+			} else if mirror {
+				err = cli.NewExitError(fmt.Sprintf("%s is served by a pull-through cache, deleting from it is refused without --allow-mirror", img.Name), 1)
+			}
+			mirrorChecks[img.Name] = err
+		}
+		mirrorChecksMu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+)
+
+// candidates returns n images of repo, newest first, the image tagged i
+// created i hours into 2024
+func candidates(repo string, n int) []*registry.DockerImage {
+	var imgs []*registry.DockerImage
+	for i := n; i > 0; i-- {
+		created := time.Date(2024, 1, 1, i, 0, 0, 0, time.UTC)
+		imgs = append(imgs, &registry.DockerImage{Name: repo, Tag: fmt.Sprint(i), ContentDigest: fmt.Sprintf("sha256:%d", i), Created: created})
+	}
+	return imgs
+}
+
+// single_steps plans deleting every image in its own step
+func single_steps(imgs []*registry.DockerImage) *deletePlan {
+	plan := &deletePlan{}
+	for _, img := range imgs {
+		plan.Steps = append(plan.Steps, []*registry.DockerImage{img})
+	}
+	return plan
+}
+
+func TestLimitDeletions(t *testing.T) {
+	tests := []struct {
+		n      int
+		sample float64
+		max    int
+		want   []string
+	}{
+		{3, 100, 0, []string{"app:3", "app:2", "app:1"}},
+		//The oldest images are deleted first, in the planned order
+		{3, 100, 2, []string{"app:2", "app:1"}},
+		{4, 50, 0, []string{"app:2", "app:1"}},
+		{10, 50, 2, []string{"app:2", "app:1"}},
+		//Percentages round up, to at least one image
+		{5, 10, 0, []string{"app:1"}},
+		{5, 50, 0, []string{"app:3", "app:2", "app:1"}},
+		{3, 0, 0, nil},
+	}
+	for _, test := range tests {
+		plan := single_steps(candidates("app", test.n))
+		limit_deletions(plan, test.sample, test.max)
+		var got []string
+		for _, step := range plan.Steps {
+			got = append(got, image_refs(step)...)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("limit_deletions(%d images, %g%%, %d) = %v, want %v", test.n, test.sample, test.max, got, test.want)
+		}
+	}
+}
+
+func TestLimitDeletionsForced(t *testing.T) {
+	//The oldest image shares its digest with an unselected tag, deleting it
+	//removes two tags
+	imgs := candidates("app", 3)
+	plan := single_steps(imgs)
+	plan.Forced = map[string][]string{"app@sha256:1": {"app:stable"}}
+	limit_deletions(plan, 100, 2)
+	if len(plan.Steps) != 1 || plan.Steps[0][0] != imgs[2] {
+		t.Errorf("Steps = %v, want only the forced step of app:1", plan.Steps)
+	}
+
+	plan = single_steps(imgs)
+	plan.Forced = map[string][]string{"app@sha256:1": {"app:stable", "app:prod"}}
+	limit_deletions(plan, 100, 2)
+	var got []string
+	for _, step := range plan.Steps {
+		got = append(got, image_refs(step)...)
+	}
+	if !reflect.DeepEqual(got, []string{"app:3", "app:2"}) {
+		t.Errorf("Steps = %v, want app:3 and app:2, the forced step removes too many tags", got)
+	}
+	if _, ok := plan.Forced["app@sha256:1"]; ok {
+		t.Error("The dropped forced step is still recorded as forced")
+	}
+}
+
+func TestCheckCandidateRatio(t *testing.T) {
+	imgs := append(candidates("app", 3), candidates("web", 1)...)
+	tests := []struct {
+		scanned map[string]int
+		limit   float64
+		fails   bool
+	}{
+		{map[string]int{"app": 10, "web": 10}, 50, false},
+		{map[string]int{"app": 6, "web": 10}, 50, false},
+		{map[string]int{"app": 5, "web": 10}, 50, true},
+		{map[string]int{"app": 10, "web": 1}, 50, true},
+		{map[string]int{"app": 3, "web": 1}, 100, false},
+	}
+	for _, test := range tests {
+		if err := check_candidate_ratio(imgs, test.scanned, test.limit); (err != nil) != test.fails {
+			t.Errorf("check_candidate_ratio(%v, %g%%) = %v, want failure %v", test.scanned, test.limit, err, test.fails)
+		}
+	}
+}
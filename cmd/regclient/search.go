@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+// search_image matches re against the manifest and the image config of
+// repo:tag and returns the first match and where it was found
+func search_image(r *registry.DockerRegistry, re *regexp.Regexp, repo, tag string) (string, string, error) {
+	m, err := r.GetManifest(cmdctx, repo, tag)
+	if err != nil {
+		return "", "", err
+	}
+	if match := re.Find(m.Body); match != nil {
+		return "manifest", string(match), nil
+	}
+	if registry.MediaTypeKind(m.MediaType) == "index" {
+		return "", "", nil
+	}
+	config, err := r.ImageConfig(cmdctx, repo, m)
+	if err != nil {
+		return "", "", err
+	}
+	if match := re.Find(config); match != nil {
+		return "config", string(match), nil
+	}
+	return "", "", nil
+}
+
+var searchCommand = cli.Command{
+	Name:      "search",
+	Usage:     "Lists images whose manifest or config (env, labels, history, layers...) contains a string",
+	ArgsUsage: "pattern",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "repo, r",
+			Usage: "Search this repository (default: the whole catalog)",
+		},
+		cli.BoolFlag{
+			Name:  "regex",
+			Usage: "Treat the pattern as a regular expression",
+		},
+	},
+	Action: instrumented("search", func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return cli.NewExitError("You must specify exactly one pattern", 1)
+		}
+		pattern := c.Args().First()
+		if !c.Bool("regex") {
+			pattern = regexp.QuoteMeta(pattern)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("Invalid pattern: %v", err), 1)
+		}
+
+		r := init_registry(c)
+		_, err = walk_catalog(c, r, c.StringSlice("repo"), func(repo string) func() {
+			tags, err := r.Tags(cmdctx, repo)
+			if err != nil {
+				record_error()
+				log.Printf("Unable to get tags of %s: %v", repo, err)
+				return nil
+			}
+			var found []string
+			for _, tag := range tags {
+				where, match, err := search_image(r, re, repo, tag)
+				if err != nil {
+					record_error()
+					log.Printf("Unable to search %s:%s: %v", repo, tag, err)
+				} else if where != "" {
+					found = append(found, fmt.Sprintf("%s:%s %s %q", repo, tag, where, match))
+				}
+			}
+			return func() {
+				for _, line := range found {
+					fmt.Fprintln(stdout, line)
+				}
+			}
+		})
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		return nil
+	}),
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// releasePublicKey is the base64 encoded ed25519 key the checksums of
+// releases are signed with, set at build time with
+// -ldflags "-X main.releasePublicKey=..."
+var releasePublicKey string
+
+const defaultReleaseURL = "https://api.github.com/repos/loginoff/docker-regclient/releases/latest"
+
+type release struct {
+	Tag    string `json:"tag_name"`
+	Assets []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+func (r *release) asset(name string) (string, error) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL, nil
+		}
+	}
+	return "", fmt.Errorf("Release %s has no %s", r.Tag, name)
+}
+
+func download(client *http.Client, url string) ([]byte, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unable to download %s: HTTP %d", url, resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// verified_checksum checks the signature of the checksums file and returns
+// the checksum it lists for name
+func verified_checksum(checksums, signature []byte, name string) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(releasePublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return "", fmt.Errorf("This build has no valid release key, it can't verify updates")
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil || !ed25519.Verify(ed25519.PublicKey(key), checksums, sig) {
+		return "", fmt.Errorf("The signature of the release checksums is invalid")
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("The release checksums don't list %s", name)
+}
+
+// replace_executable atomically replaces the running binary with content. On
+// Windows the binary is moved out of the way first, and back if the new one
+// can't take its place.
+func replace_executable(content []byte) (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".docker-regclient-update-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return "", err
+	}
+	//Windows doesn't allow replacing a running executable, but it can be
+	//renamed out of the way
+	if runtime.GOOS != "windows" {
+		return exe, os.Rename(tmp.Name(), exe)
+	}
+	old := exe + ".old"
+	os.Remove(old)
+	if err := os.Rename(exe, old); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		if rberr := os.Rename(old, exe); rberr != nil {
+			return "", fmt.Errorf("%v, and restoring %s from %s failed: %v", err, exe, old, rberr)
+		}
+		return "", err
+	}
+	return exe, nil
+}
+
+var selfUpdateCommand = cli.Command{
+	Name:  "self-update",
+	Usage: "Replaces this binary with the latest release, after verifying its signed checksum",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "release-url",
+			Value: defaultReleaseURL,
+			Usage: "The endpoint describing the latest release (GitHub releases API format)",
+		},
+		cli.BoolFlag{
+			Name:  "check",
+			Usage: "Only check whether a newer release is available",
+		},
+	},
+	Action: instrumented("self-update", func(c *cli.Context) error {
+		client := &http.Client{Timeout: 5 * time.Minute}
+		content, err := download(client, c.String("release-url"))
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		var latest release
+		if err := json.Unmarshal(content, &latest); err != nil {
+			return cli.NewExitError(fmt.Sprintf("Unable to parse the release: %v", err), 1)
+		}
+		available, ok := parse_semver(latest.Tag)
+		if !ok {
+			return cli.NewExitError(fmt.Sprintf("The latest release %s is not a semantic version", latest.Tag), 1)
+		}
+		running, ok := parse_semver(c.App.Version)
+		if !ok {
+			return cli.NewExitError(fmt.Sprintf("Unable to compare the running version %s with releases", c.App.Version), 1)
+		}
+		if available.compare(running) <= 0 {
+			fmt.Fprintf(stdout, "Version %s is up to date (latest release %s)\n", c.App.Version, latest.Tag)
+			return nil
+		}
+		fmt.Fprintf(stdout, "Version %s is available (running %s)\n", latest.Tag, c.App.Version)
+		if c.Bool("check") {
+			return nil
+		}
+
+		name := fmt.Sprintf("docker-regclient-%s-%s", runtime.GOOS, runtime.GOARCH)
+		if runtime.GOOS == "windows" {
+			name += ".exe"
+		}
+		var urls [3]string
+		for i, asset := range []string{name, "checksums.txt", "checksums.txt.sig"} {
+			if urls[i], err = latest.asset(asset); err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+		}
+		checksums, err := download(client, urls[1])
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		signature, err := download(client, urls[2])
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		expected, err := verified_checksum(checksums, signature, name)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		binary, err := download(client, urls[0])
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		if sum := sha256.Sum256(binary); hex.EncodeToString(sum[:]) != strings.ToLower(expected) {
+			return cli.NewExitError(fmt.Sprintf("The checksum of %s doesn't match the signed checksum", name), 1)
+		}
+		exe, err := replace_executable(binary)
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("Unable to replace the executable: %v", err), 1)
+		}
+		fmt.Fprintf(stdout, "Updated %s to %s\n", exe, latest.Tag)
+		return nil
+	}),
+}
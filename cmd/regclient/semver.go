@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+)
+
+// semverTag matches tags that are semantic versions, optionally prefixed
+// with v, eg 1.4.2, v2.0.0-rc.1 or 1.0.0+build.5
+var semverTag = regexp.MustCompile(`^v?(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// semVersion is a parsed semantic version, build metadata is ignored
+type semVersion struct {
+	Major, Minor, Patch int
+	Pre                 []string
+}
+
+func parse_semver(s string) (semVersion, bool) {
+	m := semverTag.FindStringSubmatch(s)
+	if m == nil {
+		return semVersion{}, false
+	}
+	var v semVersion
+	v.Major, _ = strconv.Atoi(m[1])
+	v.Minor, _ = strconv.Atoi(m[2])
+	v.Patch, _ = strconv.Atoi(m[3])
+	if m[4] != "" {
+		v.Pre = strings.Split(m[4], ".")
+	}
+	return v, true
+}
+
+// parse_semver_flag parses a version given in a flag or policy
+func parse_semver_flag(name, s string) (semVersion, error) {
+	v, ok := parse_semver(s)
+	if !ok {
+		return v, fmt.Errorf("%s '%s' is not a semantic version like 2.0.0", name, s)
+	}
+	return v, nil
+}
+
+// compare orders versions by precedence as defined by semver.org: a
+// pre-release sorts before its release, its identifiers are compared
+// numerically when both are numbers and as strings otherwise
+func (v semVersion) compare(o semVersion) int {
+	for _, d := range []int{v.Major - o.Major, v.Minor - o.Minor, v.Patch - o.Patch} {
+		if d != 0 {
+			return d
+		}
+	}
+	switch {
+	case len(v.Pre) == 0 && len(o.Pre) == 0:
+		return 0
+	case len(v.Pre) == 0:
+		return 1
+	case len(o.Pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(v.Pre) && i < len(o.Pre); i++ {
+		a, aerr := strconv.Atoi(v.Pre[i])
+		b, berr := strconv.Atoi(o.Pre[i])
+		switch {
+		case aerr == nil && berr == nil:
+			if a != b {
+				return a - b
+			}
+		case aerr == nil:
+			return -1
+		case berr == nil:
+			return 1
+		default:
+			if c := strings.Compare(v.Pre[i], o.Pre[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return len(v.Pre) - len(o.Pre)
+}
+
+// minor_key groups the images of a repository by major.minor version
+func minor_key(img *registry.DockerImage) string {
+	v, _ := parse_semver(img.Tag)
+	return fmt.Sprintf("%s:%d.%d", img.Name, v.Major, v.Minor)
+}
+
+// latest_patches returns the n highest releases of every minor version of
+// every repository, ordered by version rather than by creation time.
+// Pre-releases and tags that are not semantic versions are left out.
+func latest_patches(imgs []*registry.DockerImage, n int) map[*registry.DockerImage]bool {
+	var versioned []*registry.DockerImage
+	for _, img := range imgs {
+		if v, ok := parse_semver(img.Tag); ok && len(v.Pre) == 0 {
+			versioned = append(versioned, img)
+		}
+	}
+	sort.SliceStable(versioned, func(i, j int) bool {
+		a, _ := parse_semver(versioned[i].Tag)
+		b, _ := parse_semver(versioned[j].Tag)
+		return a.compare(b) > 0
+	})
+	return latest_per_group(versioned, n, minor_key)
+}
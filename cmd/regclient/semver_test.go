@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSemverOrder(t *testing.T) {
+	//Ordered by precedence, as in the example of semver.org
+	ordered := []string{
+		"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2",
+		"1.0.0-beta.11", "1.0.0-rc.1", "v1.0.0", "1.0.1", "1.2.0", "1.10.0", "2.0.0+build.5",
+	}
+	for i := range ordered {
+		for j := range ordered {
+			a, _ := parse_semver(ordered[i])
+			b, _ := parse_semver(ordered[j])
+			c := a.compare(b)
+			if (i < j && c >= 0) || (i > j && c <= 0) || (i == j && c != 0) {
+				t.Errorf("compare(%s, %s) = %d", ordered[i], ordered[j], c)
+			}
+		}
+	}
+	//Build metadata doesn't change the precedence
+	a, _ := parse_semver("1.0.0+build.1")
+	b, _ := parse_semver("1.0.0")
+	if a.compare(b) != 0 {
+		t.Errorf("1.0.0+build.1 and 1.0.0 don't have the same precedence")
+	}
+}
+
+func TestParseSemver(t *testing.T) {
+	tests := []struct {
+		tag string
+		ok  bool
+	}{
+		{"1.4.2", true},
+		{"v2.0.0-rc.1", true},
+		{"1.0.0+build.5", true},
+		{"1.0", false},
+		{"01.0.0", false},
+		{"latest", false},
+		{"1.0.0-", false},
+	}
+	for _, test := range tests {
+		if _, ok := parse_semver(test.tag); ok != test.ok {
+			t.Errorf("parse_semver(%s) = %v, want %v", test.tag, ok, test.ok)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+// layer_sizes returns the size of every distinct layer per repository.
+// Layers without a recorded size (schema1) are looked up with HEAD requests.
+func layer_sizes(r *registry.DockerRegistry, imgs []*layeredImage) map[string]map[string]int64 {
+	sizes := make(map[string]map[string]int64)
+	known := make(map[string]int64)
+	for _, img := range imgs {
+		if sizes[img.Repo] == nil {
+			sizes[img.Repo] = make(map[string]int64)
+		}
+		for _, layer := range img.Layers {
+			size := layer.Size
+			if size == 0 {
+				var ok bool
+				if size, ok = known[layer.Digest]; !ok {
+					var err error
+					if size, err = r.BlobSize(cmdctx, img.Repo, layer.Digest); err != nil {
+						log.Printf("Unable to get the size of %s: %v", layer.Digest, err)
+					}
+				}
+			}
+			known[layer.Digest] = size
+			sizes[img.Repo][layer.Digest] = size
+		}
+	}
+	return sizes
+}
+
+var layerSharingCommand = cli.Command{
+	Name:  "layer-sharing",
+	Usage: "Reports which repositories share layers and how much storage sharing saves",
+	Flags: []cli.Flag{
+		cli.StringSliceFlag{
+			Name:  "repo, r",
+			Usage: "Include this repository (default: the whole catalog)",
+		},
+	},
+	Action: instrumented("layer-sharing", func(c *cli.Context) error {
+		r := init_registry(c)
+		repos := c.StringSlice("repo")
+		if len(repos) == 0 {
+			var err error
+			if repos, err = r.Repos(cmdctx); err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+		}
+		imgs := fetch_layered(r, repos)
+		sizes := layer_sizes(r, imgs)
+
+		//Bytes referenced by all images, as if nothing was shared
+		var referenced int64
+		for _, img := range imgs {
+			for _, layer := range img.Layers {
+				referenced += sizes[img.Repo][layer.Digest]
+			}
+		}
+
+		var names []string
+		var perrepo int64
+		stored := make(map[string]int64)
+		for repo, layers := range sizes {
+			names = append(names, repo)
+			for digest, size := range layers {
+				perrepo += size
+				stored[digest] = size
+			}
+		}
+		sort.Strings(names)
+
+		fmt.Fprintf(stdout, "Repositories (distinct layers):\n")
+		for _, repo := range names {
+			var total int64
+			for _, size := range sizes[repo] {
+				total += size
+			}
+			fmt.Fprintf(stdout, "  %s %d layers, %s\n", repo, len(sizes[repo]), human_size(total))
+		}
+
+		fmt.Fprintf(stdout, "Shared layers:\n")
+		for i, a := range names {
+			for _, b := range names[i+1:] {
+				var count int
+				var bytes int64
+				for digest, size := range sizes[a] {
+					if _, ok := sizes[b][digest]; ok {
+						count++
+						bytes += size
+					}
+				}
+				if count > 0 {
+					fmt.Fprintf(stdout, "  %s <-> %s %d layers, %s\n", a, b, count, human_size(bytes))
+				}
+			}
+		}
+
+		var total int64
+		for _, size := range stored {
+			total += size
+		}
+		fmt.Fprintf(stdout, "Referenced by images: %s\n", human_size(referenced))
+		fmt.Fprintf(stdout, "Stored per repository: %s (sharing within repositories saves %s)\n", human_size(perrepo), human_size(referenced-perrepo))
+		fmt.Fprintf(stdout, "Stored in the registry: %s (sharing across repositories saves %s)\n", human_size(total), human_size(perrepo-total))
+		return nil
+	}),
+}
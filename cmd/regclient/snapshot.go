@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+// Snapshot records which digest every tag of the registry pointed at
+type Snapshot struct {
+	Registry string    `json:"registry"`
+	Taken    time.Time `json:"taken"`
+	//Complete is set when the snapshot covers the whole catalog and every
+	//tag of it could be resolved
+	Complete     bool                         `json:"complete"`
+	Repositories map[string]map[string]string `json:"repositories"`
+	//Failed lists the repositories whose tags couldn't all be read, what
+	//was recorded of them is incomplete
+	Failed []string `json:"failed,omitempty"`
+}
+
+// covers reports whether s knows every tag of repo. A repository missing
+// from a complete snapshot had no tags, one missing from a partial snapshot
+// wasn't read.
+func (s *Snapshot) covers(repo string) bool {
+	for _, failed := range s.Failed {
+		if failed == repo {
+			return false
+		}
+	}
+	if _, ok := s.Repositories[repo]; ok {
+		return true
+	}
+	return s.Complete
+}
+
+type change struct {
+	Type      string `json:"type"`
+	Repo      string `json:"repository"`
+	Tag       string `json:"tag"`
+	OldDigest string `json:"old_digest,omitempty"`
+	NewDigest string `json:"new_digest,omitempty"`
+}
+
+// take_snapshot resolves the digest of every tag in repos, or of the whole
+// catalog if no repos are given
+func take_snapshot(c *cli.Context, r *registry.DockerRegistry, repos []string) (*Snapshot, error) {
+	s := &Snapshot{Registry: r.URL, Taken: clock.Now().UTC(), Repositories: make(map[string]map[string]string)}
+	complete, err := walk_catalog(c, r, repos, func(repo string) func() {
+		tags, err := r.Tags(cmdctx, repo)
+		if err != nil {
+			record_error()
+			log.Printf("Unable to get tags of %s: %v", repo, err)
+			return func() {
+				s.Failed = append(s.Failed, repo)
+			}
+		}
+		digests := make(map[string]string)
+		failed := false
+		for _, tag := range tags {
+			digest, err := r.ManifestDigest(cmdctx, repo, tag)
+			if err != nil {
+				record_error()
+				log.Printf("Unable to resolve %s:%s: %v", repo, tag, err)
+				failed = true
+				continue
+			}
+			digests[tag] = digest
+		}
+		return func() {
+			s.Repositories[repo] = digests
+			if failed {
+				s.Failed = append(s.Failed, repo)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.Complete = complete && len(s.Failed) == 0
+	return s, nil
+}
+
+func load_snapshot(path string) (*Snapshot, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Snapshot
+	if err := json.Unmarshal(content, &s); err != nil {
+		return nil, fmt.Errorf("Unable to parse snapshot %s: %v", path, err)
+	}
+	return &s, nil
+}
+
+// load_history reads the snapshots of the registry at url saved in dir,
+// oldest first. Without url every snapshot is read, they must all be of the
+// same registry.
+func load_history(dir, url string) ([]*Snapshot, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if url != "" {
+		url = registry.APIURL(url)
+	}
+	var history []*Snapshot
+	for _, path := range paths {
+		s, err := load_snapshot(path)
+		if err != nil {
+			return nil, err
+		}
+		if url == "" && len(history) > 0 && s.Registry != history[0].Registry {
+			return nil, fmt.Errorf("%s holds snapshots of %s and %s, select one with --url", dir, history[0].Registry, s.Registry)
+		}
+		if url == "" || s.Registry == url {
+			history = append(history, s)
+		}
+	}
+	if len(history) == 0 && url != "" {
+		return nil, fmt.Errorf("No snapshots of %s found in %s", url, dir)
+	} else if len(history) == 0 {
+		return nil, fmt.Errorf("No snapshots found in %s", dir)
+	}
+	sort.Slice(history, func(i, j int) bool { return history[i].Taken.Before(history[j].Taken) })
+	return history, nil
+}
+
+// unchanged_since returns the time of the oldest snapshot from which on
+// repo:tag pointed at digest without interruption. Snapshots not covering
+// repo, or that failed to read it, are ignored. The zero time is returned if the latest snapshot
+// covering repo disagrees.
+func unchanged_since(history []*Snapshot, repo, tag, digest string) time.Time {
+	var since time.Time
+	for i := len(history) - 1; i >= 0; i-- {
+		tags, ok := history[i].Repositories[repo]
+		if !ok || !history[i].covers(repo) {
+			continue
+		}
+		if tags[tag] != digest {
+			break
+		}
+		since = history[i].Taken
+	}
+	return since
+}
+
+// unchanged_filter matches images whose tag has pointed at the same digest
+// since before cutoff, according to the snapshot history. Images without
+// history reaching back to cutoff never match.
+func unchanged_filter(history []*Snapshot, cutoff time.Time) ImgFilter {
+	return func(img *registry.DockerImage) bool {
+		since := unchanged_since(history, img.Name, img.Tag, img.ContentDigest)
+		return !since.IsZero() && !since.After(cutoff)
+	}
+}
+
+// save_snapshot writes s to path. If path is a directory, a file named after
+// the time the snapshot was taken is created inside it.
+func save_snapshot(s *Snapshot, path string) (string, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, fmt.Sprintf("snapshot-%s.json", s.Taken.Format("20060102T150405Z")))
+	}
+	content, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return path, os.WriteFile(path, content, 0644)
+}
+
+// diff_snapshots lists the tags that were added, removed or repointed to a
+// different digest between old and new, sorted by repository and tag. Only
+// the repositories both snapshots cover are compared.
+func diff_snapshots(old, new *Snapshot) []change {
+	var changes []change
+	for repo, tags := range new.Repositories {
+		if !old.covers(repo) || !new.covers(repo) {
+			continue
+		}
+		for tag, digest := range tags {
+			if olddigest, ok := old.Repositories[repo][tag]; !ok {
+				changes = append(changes, change{"added", repo, tag, "", digest})
+			} else if olddigest != digest {
+				changes = append(changes, change{"changed", repo, tag, olddigest, digest})
+			}
+		}
+	}
+	for repo, tags := range old.Repositories {
+		if !old.covers(repo) || !new.covers(repo) {
+			continue
+		}
+		for tag, digest := range tags {
+			if _, ok := new.Repositories[repo][tag]; !ok {
+				changes = append(changes, change{"removed", repo, tag, digest, ""})
+			}
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Repo != changes[j].Repo {
+			return changes[i].Repo < changes[j].Repo
+		}
+		return changes[i].Tag < changes[j].Tag
+	})
+	return changes
+}
+
+func print_changes(changes []change) {
+	for _, ch := range changes {
+		switch ch.Type {
+		case "added":
+			fmt.Fprintf(stdout, "+ %s:%s %s\n", ch.Repo, ch.Tag, ch.NewDigest)
+		case "removed":
+			fmt.Fprintf(stdout, "- %s:%s %s\n", ch.Repo, ch.Tag, ch.OldDigest)
+		case "changed":
+			fmt.Fprintf(stdout, "~ %s:%s %s -> %s\n", ch.Repo, ch.Tag, ch.OldDigest, ch.NewDigest)
+		}
+	}
+}
+
+var snapshotCommand = cli.Command{
+	Name:  "snapshot",
+	Usage: "Saves the state of the registry and compares it over time",
+	Subcommands: []cli.Command{
+		{
+			Name:      "save",
+			Usage:     "Saves the digest of every tag to a file (or a new file in a directory)",
+			ArgsUsage: "path",
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "repo, r",
+					Usage: "Only include this repository (default: the whole catalog)",
+				},
+			},
+			Action: instrumented("snapshot save", func(c *cli.Context) error {
+				if c.NArg() != 1 {
+					return cli.NewExitError("You must specify where to save the snapshot", 1)
+				}
+				s, err := take_snapshot(c, init_registry(c), c.StringSlice("repo"))
+				if err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				path, err := save_snapshot(s, c.Args().First())
+				if err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				fmt.Fprintf(stdout, "Saved %d repositories to %s\n", len(s.Repositories), path)
+				return nil
+			}),
+		},
+		{
+			Name:      "diff",
+			Usage:     "Shows tags added (+), removed (-) and changed (~) between two snapshots, or a snapshot and the registry",
+			ArgsUsage: "old [new]",
+			Action: instrumented("snapshot diff", func(c *cli.Context) error {
+				if c.NArg() < 1 || c.NArg() > 2 {
+					return cli.NewExitError("You must specify one or two snapshots", 1)
+				}
+				old, err := load_snapshot(c.Args().Get(0))
+				if err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				var new *Snapshot
+				if c.NArg() == 2 {
+					new, err = load_snapshot(c.Args().Get(1))
+				} else {
+					var repos []string
+					if !old.Complete {
+						for repo := range old.Repositories {
+							repos = append(repos, repo)
+						}
+					}
+					new, err = take_snapshot(c, init_registry(c), repos)
+				}
+				if err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+				print_changes(diff_snapshots(old, new))
+				if failed := append(append([]string{}, old.Failed...), new.Failed...); len(failed) > 0 {
+					sort.Strings(failed)
+					log.Printf("WARNING: not compared, the tags of these repositories couldn't all be read: %s", strings.Join(failed, ", "))
+				}
+				return nil
+			}),
+		},
+		snapshotFeedCommand,
+	},
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sync"
+
+	"github.com/urfave/cli"
+)
+
+var (
+	spillOnce sync.Once
+	spillDir  string
+)
+
+// cache_spill_dir creates a private directory below --cache-dir, shared by
+// the request caches of every registry of the run, and returns its path.
+// Without --cache-dir responses are not spilled and "" is returned.
+func cache_spill_dir(c *cli.Context) string {
+	parent := c.GlobalString("cache-dir")
+	if parent == "" {
+		return ""
+	}
+	spillOnce.Do(func() {
+		var err error
+		if spillDir, err = os.MkdirTemp(parent, "regclient-cache-"); err != nil {
+			log.Printf("Unable to spill the request cache to %s: %v", parent, err)
+		}
+	})
+	return spillDir
+}
+
+// remove_cache_spill deletes the spilled responses at the end of the run
+func remove_cache_spill() {
+	if spillDir != "" {
+		handleErr(os.RemoveAll(spillDir))
+	}
+}
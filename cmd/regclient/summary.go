@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+// runSummary accumulates the outcome of a single invocation. It is updated
+// concurrently from the fetch goroutines.
+type runSummary struct {
+	started        time.Time
+	scanned        int64
+	deleted        int64
+	reclaimedBytes int64
+	errors         int64
+
+	mu       sync.Mutex
+	warnings map[registry.WarningKind][]registry.Warning
+	//immutable maps immutability rules to the images they kept
+	immutable map[string][]string
+}
+
+var summary = runSummary{started: time.Now()}
+
+func record_scanned(img *registry.DockerImage) {
+	atomic.AddInt64(&summary.scanned, 1)
+}
+
+func record_error() {
+	atomic.AddInt64(&summary.errors, 1)
+}
+
+func record_deleted(img *registry.DockerImage) {
+	atomic.AddInt64(&summary.deleted, 1)
+	atomic.AddInt64(&summary.reclaimedBytes, img.Size)
+	metrics.Count("images.deleted", 1, "repo:"+img.Name)
+}
+
+func record_delete_error(img *registry.DockerImage) {
+	record_error()
+	metrics.Count("images.delete_errors", 1, "repo:"+img.Name)
+}
+
+// observe_warnings collects the warnings of r for report_warnings instead of
+// logging every one of them as it happens
+func observe_warnings(r *registry.DockerRegistry) {
+	r.OnWarning(func(w registry.Warning) {
+		summary.mu.Lock()
+		defer summary.mu.Unlock()
+		if summary.warnings == nil {
+			summary.warnings = make(map[registry.WarningKind][]registry.Warning)
+		}
+		summary.warnings[w.Kind] = append(summary.warnings[w.Kind], w)
+	})
+}
+
+// report_warnings logs how often every kind of warning occurred during the
+// run, with the first few occurrences as examples
+func report_warnings() {
+	const examples = 3
+	summary.mu.Lock()
+	defer summary.mu.Unlock()
+	var kinds []string
+	for kind := range summary.warnings {
+		kinds = append(kinds, string(kind))
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		warnings := summary.warnings[registry.WarningKind(kind)]
+		log.Printf("WARNING %d times %s, eg:", len(warnings), kind)
+		for i, w := range warnings {
+			if i == examples {
+				log.Printf("  ... and %d more", len(warnings)-examples)
+				break
+			}
+			log.Printf("  %s", w)
+		}
+	}
+}
+
+// record_immutable remembers an image an immutability rule kept from being
+// deleted. Such images are skipped, not counted as errors.
+func record_immutable(err error) {
+	var ie registry.ImmutableError
+	if !errors.As(err, &ie) {
+		return
+	}
+	summary.mu.Lock()
+	defer summary.mu.Unlock()
+	if summary.immutable == nil {
+		summary.immutable = make(map[string][]string)
+	}
+	summary.immutable[ie.Rule] = append(summary.immutable[ie.Rule], ie.Image)
+}
+
+// report_immutable lists the images every immutability rule blocked, so
+// they can be excluded from the selection or the rules reviewed
+func report_immutable() {
+	summary.mu.Lock()
+	defer summary.mu.Unlock()
+	var rules []string
+	for rule := range summary.immutable {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+	for _, rule := range rules {
+		imgs := summary.immutable[rule]
+		log.Printf("%d images were not deleted, they are immutable (%s): %s", len(imgs), rule, strings.Join(imgs, ", "))
+	}
+	if len(rules) > 0 {
+		log.Printf("Exclude these images from the selection, or change the rules in the registry to delete them")
+	}
+}
+
+func count_warnings() int {
+	summary.mu.Lock()
+	defer summary.mu.Unlock()
+	var count int
+	for _, warnings := range summary.warnings {
+		count += len(warnings)
+	}
+	return count
+}
+
+// push_summary sends the run summary to the Prometheus Pushgateway given
+// with --pushgateway-url, replacing the previous push of the same job
+func push_summary(c *cli.Context) error {
+	gateway := c.GlobalString("pushgateway-url")
+	if gateway == "" {
+		return nil
+	}
+
+	var body bytes.Buffer
+	gauge := func(name, help string, value interface{}) {
+		fmt.Fprintf(&body, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", name, help, name, name, value)
+	}
+	gauge("regclient_images_scanned", "Images inspected during the last run", atomic.LoadInt64(&summary.scanned))
+	gauge("regclient_images_deleted", "Images deleted during the last run", atomic.LoadInt64(&summary.deleted))
+	gauge("regclient_reclaimed_bytes", "Upper bound of bytes freed by the deleted images", atomic.LoadInt64(&summary.reclaimedBytes))
+	gauge("regclient_errors", "Errors encountered during the last run", atomic.LoadInt64(&summary.errors))
+	gauge("regclient_warnings", "Data-quality warnings during the last run", count_warnings())
+	gauge("regclient_duration_seconds", "Duration of the last run", time.Since(summary.started).Seconds())
+	gauge("regclient_last_run_timestamp_seconds", "Unix time the last run finished", time.Now().Unix())
+
+	target := fmt.Sprintf("%s/metrics/job/%s", strings.TrimSuffix(gateway, "/"), url.PathEscape(c.GlobalString("pushgateway-job")))
+	if err := send_webhook("PUT", target, "text/plain; version=0.0.4", body.Bytes(), nil); err != nil {
+		return fmt.Errorf("Unable to push run summary: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+// sync_repo copies every tag of repo whose digest differs from what state
+// records as synced, and updates state as tags are copied. It returns the
+// number of tags copied, unchanged and failed.
+func sync_repo(src, dst *registry.DockerRegistry, repo string, tags map[string]string, state *Snapshot, opts registry.CopyOptions, dryrun bool) (copied, unchanged, failed int) {
+	synced := state.Repositories[repo]
+	if synced == nil {
+		synced = make(map[string]string)
+		state.Repositories[repo] = synced
+	}
+	//Tags deleted from the source are forgotten, so they are copied
+	//again if they come back
+	for tag := range synced {
+		if _, ok := tags[tag]; !ok {
+			delete(synced, tag)
+		}
+	}
+
+	var names []string
+	for tag := range tags {
+		names = append(names, tag)
+	}
+	sort.Strings(names)
+	for _, tag := range names {
+		digest := tags[tag]
+		if synced[tag] == digest {
+			unchanged++
+			continue
+		}
+		fmt.Fprintf(stdout, "%s:%s %s\n", repo, tag, digest)
+		if dryrun {
+			copied++
+			continue
+		}
+		//Pin the digest that was resolved, so a tag moved in the meantime
+		//is picked up by the next run instead of being recorded wrongly
+		opts.RequireDigest = digest
+		if _, err := registry.CopyImage(cmdctx, src, repo, tag, dst, repo, tag, opts); err != nil {
+			failed++
+			record_error()
+			fmt.Fprintf(stdout, "%s:%s FAILED: %v\n", repo, tag, err)
+			continue
+		}
+		synced[tag] = digest
+		copied++
+	}
+	return copied, unchanged, failed
+}
+
+var syncCommand = cli.Command{
+	Name:  "sync",
+	Usage: "Copies every new or changed tag to another registry",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "dest-url",
+			Usage: "The registry to copy to",
+		},
+		cli.StringFlag{
+			Name:   "dest-username",
+			Usage:  "Authenticate to --dest-url as this user",
+			EnvVar: "REGCLIENT_DEST_USERNAME",
+		},
+		cli.StringFlag{
+			Name:   "dest-password",
+			Usage:  "Password of --dest-username",
+			EnvVar: "REGCLIENT_DEST_PASSWORD",
+		},
+		cli.StringSliceFlag{
+			Name:  "repo, r",
+			Usage: "Only sync this repository (default: the whole catalog)",
+		},
+		cli.StringFlag{
+			Name:  "state",
+			Usage: "Remember the synced digest of every tag in this file, so later runs only copy changed tags",
+		},
+		cli.IntFlag{
+			Name:  "workers",
+			Usage: "Number of blobs transferred concurrently",
+			Value: 4,
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "Only show which tags would be copied",
+		},
+	},
+	Action: instrumented("sync", func(c *cli.Context) error {
+		if c.String("dest-url") == "" {
+			return cli.NewExitError("You must specify the registry to sync to with --dest-url", 1)
+		}
+		src := init_registry(c)
+		dst := init_registry_at(c, c.String("dest-url"), c.String("dest-username"), c.String("dest-password"))
+
+		statefile := c.String("state")
+		state := &Snapshot{Registry: dst.URL, Repositories: make(map[string]map[string]string)}
+		if statefile != "" {
+			previous, err := load_snapshot(statefile)
+			if err != nil && !os.IsNotExist(err) {
+				return cli.NewExitError(err.Error(), 1)
+			}
+			if previous != nil {
+				if previous.Registry != dst.URL {
+					return cli.NewExitError(fmt.Sprintf("State file %s records a sync to %s, not %s", statefile, previous.Registry, dst.URL), 1)
+				}
+				state = previous
+			}
+		}
+
+		source, err := take_snapshot(c, src, c.StringSlice("repo"))
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		var repos []string
+		for repo := range source.Repositories {
+			repos = append(repos, repo)
+		}
+		sort.Strings(repos)
+
+		opts := registry.CopyOptions{Workers: c.Int("workers")}
+		var copied, unchanged, failed int
+		for _, repo := range repos {
+			cp, un, fl := sync_repo(src, dst, repo, source.Repositories[repo], state, opts, c.Bool("dry-run"))
+			copied, unchanged, failed = copied+cp, unchanged+un, failed+fl
+			//Save after every repository, so an interrupted sync resumes
+			//where it stopped
+			if statefile != "" && !c.Bool("dry-run") {
+				state.Taken = clock.Now().UTC()
+				if _, err := save_snapshot(state, statefile); err != nil {
+					return cli.NewExitError(err.Error(), 1)
+				}
+			}
+		}
+		fmt.Fprintf(stdout, "%d tags copied, %d unchanged, %d failed\n", copied, unchanged, failed)
+		if failed > 0 {
+			return cli.NewExitError("", 1)
+		}
+		return nil
+	}),
+}
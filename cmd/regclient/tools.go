@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/urfave/cli"
+)
+
+// Confirm asks a yes/no question on the terminal. When there is nobody to
+// answer, as in CI where stdin is closed or empty, it returns false instead
+// of asking forever.
+func Confirm(prompt string) bool {
+	for {
+		reader := bufio.NewReader(os.Stdin)
+		fmt.Print(prompt)
+		ans, err := reader.ReadString('\n')
+		if err != nil && strings.TrimSpace(ans) == "" {
+			fmt.Println()
+			log.Printf("No answer on standard input, nothing was deleted. Use --yes to delete without prompting")
+			return false
+		}
+		switch strings.TrimSpace(ans) {
+		case "y":
+			return true
+		case "n":
+			return false
+		}
+	}
+}
+
+// dryRunExitCode is the exit code of a dry run which would delete something,
+// so pipelines can tell it apart from a clean run and from errors (1)
+const dryRunExitCode = 2
+
+// dry_run_result ends a dry run which would have deleted n images
+func dry_run_result(n int) error {
+	if n == 0 {
+		return nil
+	}
+	return cli.NewExitError(fmt.Sprintf("Dry run, %d images would be deleted", n), dryRunExitCode)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/urfave/cli"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// cmdctx carries the span of the command currently being executed. All API
+// calls made through init_registry are parented to it.
+var cmdctx = context.Background()
+
+var tracerProvider *sdktrace.TracerProvider
+
+// init_tracing installs a global tracer provider exporting spans to the OTLP
+// endpoint given with --otlp-endpoint. Without the flag tracing is a no-op.
+func init_tracing(c *cli.Context) error {
+	endpoint := c.GlobalString("otlp-endpoint")
+	if endpoint == "" {
+		return nil
+	}
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return cli.NewExitError("Unable to create OTLP exporter: "+err.Error(), 1)
+	}
+	tracerProvider = sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return nil
+}
+
+// shutdown_tracing flushes any spans that are still buffered
+func shutdown_tracing(c *cli.Context) error {
+	if tracerProvider == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		log.Printf("Unable to flush traces: %v", err)
+	}
+	return nil
+}
+
+// instrumented wraps a command action in a span named after the command and
+// reports its duration to the metrics sink
+func instrumented(name string, action func(c *cli.Context) error) func(c *cli.Context) error {
+	return func(c *cli.Context) error {
+		start := time.Now()
+		ctx, span := otel.Tracer("github.com/loginoff/docker-regclient").Start(interrupted, name,
+			trace.WithAttributes(attribute.String("regclient.command", name)))
+		defer span.End()
+		cmdctx = ctx
+
+		err := apply_config_defaults(c, name)
+		if err == nil {
+			err = action(c)
+		}
+		if err == nil {
+			err = circuit_error()
+		}
+		if ierr := interrupt_error(); ierr != nil {
+			err = ierr
+		}
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			metrics.Count("command.errors", 1, "command:"+name)
+		}
+		metrics.Timing("command.duration", time.Since(start), "command:"+name)
+		return err
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+// treeNode is a line printed by tree and the lines below it
+type treeNode struct {
+	label    string
+	children []*treeNode
+}
+
+func (n *treeNode) add(label string) *treeNode {
+	child := &treeNode{label: label}
+	n.children = append(n.children, child)
+	return child
+}
+
+// print_tree prints the children of n, indented below prefix
+func print_tree(n *treeNode, prefix string) {
+	for i, child := range n.children {
+		branch, indent := "├── ", "│   "
+		if i == len(n.children)-1 {
+			branch, indent = "└── ", "    "
+		}
+		fmt.Fprintf(stdout, "%s%s%s\n", prefix, branch, child.label)
+		print_tree(child, prefix+indent)
+	}
+}
+
+// short_digest abbreviates a digest the way the image listings do
+func short_digest(digest string) string {
+	if len(digest) > 16 {
+		return digest[:16]
+	}
+	return digest
+}
+
+// manifest_tree returns the tree of the manifest reference of repo: the
+// images of an index, or the config and layers of an image, followed by the
+// manifests referring to it. Manifests already in the tree are not expanded
+// again.
+func manifest_tree(r *registry.DockerRegistry, label, repo, reference string, seen map[string]bool) (*treeNode, error) {
+	m, err := r.GetManifest(cmdctx, repo, reference)
+	if err != nil {
+		return nil, err
+	}
+	n := &treeNode{label: fmt.Sprintf("%s %s %s", label, short_digest(m.Digest), m.MediaType)}
+	if seen[m.Digest] {
+		n.label += " (shown above)"
+		return n, nil
+	}
+	seen[m.Digest] = true
+
+	children, err := m.Children()
+	if err != nil {
+		n.add(fmt.Sprintf("unable to read the index: %v", err))
+	}
+	for _, child := range children {
+		label := "image"
+		if of := child.AttestationOf(); of != "" {
+			label = "attestation of " + short_digest(of)
+		} else if p := child.Platform; p != nil {
+			label = p.OS + "/" + p.Architecture
+			if p.Variant != "" {
+				label += "/" + p.Variant
+			}
+		}
+		n.add_manifest(r, label, repo, child.Digest, seen)
+	}
+
+	blobs, err := m.Blobs()
+	if err != nil {
+		n.add(fmt.Sprintf("unable to read the manifest: %v", err))
+	}
+	for i, blob := range blobs {
+		kind := "layer"
+		if i == 0 && registry.MediaTypeKind(m.MediaType) != "schema1" {
+			kind = "config"
+		}
+		line := fmt.Sprintf("%s %s %s", kind, short_digest(blob.Digest), blob.MediaType)
+		if blob.Size > 0 {
+			line += ", " + human_size(blob.Size)
+		}
+		n.add(line)
+	}
+
+	referrers, err := r.Referrers(cmdctx, repo, m.Digest)
+	if err != nil {
+		n.add(fmt.Sprintf("unable to list referrers: %v", err))
+	}
+	for _, ref := range referrers {
+		kind := ref.ArtifactType
+		if kind == "" {
+			kind = ref.MediaType
+		}
+		n.add_manifest(r, "referrer ("+kind+")", repo, ref.Digest, seen)
+	}
+	return n, nil
+}
+
+// add_manifest adds the tree of a manifest below n, or the error fetching it
+func (n *treeNode) add_manifest(r *registry.DockerRegistry, label, repo, digest string, seen map[string]bool) {
+	child, err := manifest_tree(r, label, repo, digest, seen)
+	if err != nil {
+		record_error()
+		n.add(fmt.Sprintf("%s %s: %v", label, short_digest(digest), err))
+		return
+	}
+	n.children = append(n.children, child)
+}
+
+var treeCommand = cli.Command{
+	Name:      "tree",
+	Usage:     "Shows an image as a tree of its platform manifests, config, layers, attestations and referrers (signatures, SBOMs)",
+	ArgsUsage: "repository:tag",
+	Action: instrumented("tree", func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return cli.NewExitError("You must specify one image, eg webserver:1.4", 1)
+		}
+		repo, ref, err := registry.ParseReference(c.Args().First())
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		r := init_registry(c)
+
+		top, err := manifest_tree(r, c.Args().First(), repo, ref, make(map[string]bool))
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("Unable to fetch %s: %v", c.Args().First(), err), 1)
+		}
+		fmt.Fprintf(stdout, "%s\n", top.label)
+		print_tree(top, "")
+		return nil
+	}),
+}
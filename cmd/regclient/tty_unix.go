@@ -0,0 +1,7 @@
+//go:build !windows
+
+package main
+
+// ttyPath is the terminal of the process, which answers to prompts are read
+// from when STDIN is used for input
+const ttyPath = "/dev/tty"
@@ -0,0 +1,11 @@
+//go:build windows
+
+package main
+
+// Windows installations don't necessarily have a time zone database, the
+// maintenance windows need one
+import _ "time/tzdata"
+
+// ttyPath is the console input of the process, which answers to prompts are
+// read from when STDIN is used for input
+const ttyPath = "CONIN$"
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/loginoff/docker-regclient/pkg/registry"
+	"github.com/urfave/cli"
+)
+
+var untaggedCommand = cli.Command{
+	Name:      "untagged",
+	Usage:     "Lists (and possibly deletes) manifests no tag points at, on registries able to list manifests (Harbor)",
+	ArgsUsage: "repository...",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "older-than",
+			Usage: "Only include manifests pushed before this date or age (eg 2016-12-01 or 30d)",
+		},
+		cli.BoolFlag{
+			Name:  "delete",
+			Usage: "Delete the untagged manifests",
+		},
+		cli.BoolFlag{
+			Name:  "yes",
+			Usage: "Do not prompt, when deleting images",
+		},
+		cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "With --delete, only show which manifests would be deleted, exit with 2 if there are any",
+		},
+	},
+	Action: instrumented("untagged", func(c *cli.Context) error {
+		if c.NArg() == 0 {
+			return cli.NewExitError("You must specify at least one repository", 1)
+		}
+		if c.Bool("delete") {
+			if err := refuse_fixed_clock(c.Bool("dry-run")); err != nil {
+				return err
+			}
+		}
+		r := init_registry(c)
+
+		var imgs []*registry.DockerImage
+		for _, repo := range c.Args() {
+			repoimgs, err := r.UntaggedImages(cmdctx, repo)
+			if err != nil {
+				return cli.NewExitError(fmt.Sprintf("Unable to list untagged manifests of %s: %v", repo, err), 1)
+			}
+			imgs = append(imgs, repoimgs...)
+		}
+		if older := c.String("older-than"); older != "" {
+			t, err := parse_age(older, clock.Now())
+			if err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+			var old []*registry.DockerImage
+			for _, img := range imgs {
+				if img.Created.Before(t) {
+					old = append(old, img)
+				}
+			}
+			imgs = old
+		}
+		for _, img := range imgs {
+			fmt.Fprintf(stdout, "%s %s %s %s\n", img.Created.Format(timeFormat), img.ContentDigest, human_size(img.Size), img.Name)
+		}
+		if len(imgs) == 0 || !c.Bool("delete") {
+			return nil
+		}
+
+		imgs = drop_pinned(r, imgs)
+		imgs = require_archived(c, imgs)
+		if err := refuse_mirrors(c, r, imgs); err != nil {
+			return err
+		}
+		imgs = preflight_deletes(r, imgs, c.Bool("dry-run"))
+		if c.Bool("dry-run") {
+			for _, img := range imgs {
+				fmt.Fprintf(stdout, "Would delete %s@%s\n", img.Name, img.ContentDigest)
+			}
+			return dry_run_result(len(imgs))
+		}
+		if len(imgs) == 0 {
+			return nil
+		}
+		if !c.Bool("yes") {
+			if !Confirm(fmt.Sprintf("Do you really want to delete these %d untagged manifests? (y/n): ", len(imgs))) {
+				return nil
+			}
+		}
+		if err := wait_for_window(c); err != nil {
+			return err
+		}
+		if failed := delete_images(r, imgs); failed > 0 {
+			return cli.NewExitError(fmt.Sprintf("%d manifests were not deleted", failed), 1)
+		}
+		return nil
+	}),
+}
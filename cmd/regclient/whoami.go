@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli"
+)
+
+var whoamiCommand = cli.Command{
+	Name:  "whoami",
+	Usage: "Shows who the registry authenticates you as and what you may do, to debug rejected deletes",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "repo, r",
+			Usage: "Show the access granted to this repository (default: the catalog)",
+		},
+		cli.BoolFlag{
+			Name:  "check-delete",
+			Usage: "With --repo, probe whether deleting is allowed by sending a DELETE for a manifest that doesn't exist",
+		},
+	},
+	Action: instrumented("whoami", func(c *cli.Context) error {
+		repo := c.String("repo")
+		if c.Bool("check-delete") && repo == "" {
+			return cli.NewExitError("--check-delete needs the repository given with --repo", 1)
+		}
+		r := init_registry(c)
+		id, err := r.WhoAmI(cmdctx, repo)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+
+		user := id.Username
+		if user == "" {
+			user = "(anonymous)"
+		}
+		if id.Admin {
+			user += " (administrator)"
+		}
+		fmt.Fprintf(stdout, "User:    %s\n", user)
+		fmt.Fprintf(stdout, "Auth:    %s\n", id.Method)
+		fmt.Fprintf(stdout, "Flavor:  %s\n", r.Flavor())
+		if !id.Expires.IsZero() {
+			fmt.Fprintf(stdout, "Expires: %s\n", id.Expires.Local().Format(timeFormat))
+		}
+		switch {
+		case id.Scopes == nil && id.Method == "token":
+			fmt.Fprintf(stdout, "Scopes:  unknown, the token doesn't list them\n")
+		case id.Method == "token" && len(id.Scopes) == 0:
+			fmt.Fprintf(stdout, "Scopes:  none granted\n")
+		}
+		for i, scope := range id.Scopes {
+			label := "Scopes: "
+			if i > 0 {
+				label = "        "
+			}
+			fmt.Fprintf(stdout, "%s %s\n", label, scope)
+		}
+
+		if c.Bool("check-delete") {
+			if err := r.CheckDeletePermission(cmdctx, repo); err != nil {
+				fmt.Fprintf(stdout, "Delete:  not allowed in %s: %v\n", repo, err)
+			} else {
+				fmt.Fprintf(stdout, "Delete:  allowed in %s\n", repo)
+			}
+		}
+		return nil
+	}),
+}
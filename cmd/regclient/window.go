@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// maintenance holds the configured maintenance windows. Deletions only run
+// while one of them is open, nil means there are no restrictions.
+var maintenance *maintenanceWindows
+
+// cronSchedule matches the minutes selected by a cron expression
+// (minute hour day-of-month month day-of-week)
+type cronSchedule struct {
+	fields [5]map[int]bool
+	//Like cron, if both days are restricted either of them has to match
+	anydom, anydow bool
+}
+
+var cronLimits = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+// parse_cron_field parses a comma separated list of *, n, n-m and any of
+// them with a /step
+func parse_cron_field(field string, min, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step in '%s'", part)
+			}
+			part = part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid value '%s'", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid range '%s'", part)
+				}
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return nil, fmt.Errorf("'%s' is out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+func parse_cron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("'%s' must have 5 fields (minute hour day-of-month month day-of-week)", expr)
+	}
+	//Like cron, a field starting with * doesn't restrict the days, even with a
+	//step like */2
+	s := &cronSchedule{anydom: strings.HasPrefix(fields[2], "*"), anydow: strings.HasPrefix(fields[4], "*")}
+	for i, field := range fields {
+		values, err := parse_cron_field(field, cronLimits[i][0], cronLimits[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid cron expression '%s': %v", expr, err)
+		}
+		s.fields[i] = values
+	}
+	//Sunday can be written as 0 or 7
+	if s.fields[4][7] {
+		s.fields[4][0] = true
+	}
+	return s, nil
+}
+
+func (s *cronSchedule) matches(t time.Time) bool {
+	if !s.fields[0][t.Minute()] || !s.fields[1][t.Hour()] || !s.fields[3][int(t.Month())] {
+		return false
+	}
+	dom, dow := s.fields[2][t.Day()], s.fields[4][int(t.Weekday())]
+	if !s.anydom && !s.anydow {
+		return dom || dow
+	}
+	return dom && dow
+}
+
+// maintenanceWindows is open during every minute matched by one of the
+// cron expressions, evaluated in the configured time zone
+type maintenanceWindows struct {
+	schedules []*cronSchedule
+	loc       *time.Location
+}
+
+func (w *maintenanceWindows) open(t time.Time) bool {
+	t = t.In(w.loc)
+	for _, s := range w.schedules {
+		if s.matches(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// next_open returns the start of the next window after t, looking at most a
+// year ahead
+func (w *maintenanceWindows) next_open(t time.Time) (time.Time, bool) {
+	t = t.Truncate(time.Minute)
+	for end := t.AddDate(1, 0, 0); t.Before(end); t = t.Add(time.Minute) {
+		if w.open(t) {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func init_maintenance(c *cli.Context) error {
+	exprs := c.GlobalStringSlice("maintenance-window")
+	if len(exprs) == 0 {
+		return nil
+	}
+	loc, err := time.LoadLocation(c.GlobalString("maintenance-tz"))
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("Invalid --maintenance-tz: %v", err), 1)
+	}
+	maintenance = &maintenanceWindows{loc: loc}
+	for _, expr := range exprs {
+		s, err := parse_cron(expr)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		maintenance.schedules = append(maintenance.schedules, s)
+	}
+	return nil
+}
+
+// wait_for_window returns once a maintenance window is open. Outside of the
+// windows it fails, unless --wait-for-window is given, in which case the
+// already selected images are kept until the next window opens, or the run
+// is interrupted.
+func wait_for_window(c *cli.Context) error {
+	if err := refuse_fixed_clock(false); err != nil {
+		return err
+	}
+	now := clock.Now()
+	if maintenance == nil || maintenance.open(now) {
+		return nil
+	}
+	next, ok := maintenance.next_open(now)
+	if !ok {
+		return cli.NewExitError("The maintenance windows never open", 1)
+	}
+	if !c.GlobalBool("wait-for-window") {
+		return cli.NewExitError(fmt.Sprintf("Outside of the maintenance windows, the next one opens at %s (use --wait-for-window to wait for it)", next.In(maintenance.loc).Format(timeFormat+" MST")), 1)
+	}
+	log.Printf("Waiting for the maintenance window opening at %s", next.In(maintenance.loc).Format(timeFormat+" MST"))
+	opened := make(chan struct{})
+	go func() {
+		clock.Sleep(next.Sub(now))
+		close(opened)
+	}()
+	select {
+	case <-opened:
+		return nil
+	case <-cmdctx.Done():
+		return interrupt_error()
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronMatches(t *testing.T) {
+	//2024-03-02 is a Saturday, 2024-03-04 a Monday
+	tests := []struct {
+		expr string
+		at   string
+		want bool
+	}{
+		{"0 2 * * *", "2024-03-02T02:00", true},
+		{"0 2 * * *", "2024-03-02T02:01", false},
+		{"*/15 * * * *", "2024-03-02T10:45", true},
+		{"0 22-23 * * 1-5", "2024-03-04T23:00", true},
+		{"0 22-23 * * 1-5", "2024-03-02T23:00", false},
+		//Sunday is 0 or 7
+		{"0 0 * * 7", "2024-03-03T00:00", true},
+		//With both days restricted either of them matches
+		{"0 0 1 * 1", "2024-03-04T00:00", true},
+		{"0 0 1 * 1", "2024-03-01T00:00", true},
+		{"0 0 1 * 1", "2024-03-02T00:00", false},
+		//A stepped * still leaves the days unrestricted, both must match
+		{"0 0 */2 * 1", "2024-03-04T00:00", false},
+		{"0 0 */2 * 1", "2024-03-03T00:00", false},
+		{"0 0 */2 * 6", "2024-03-09T00:00", true},
+		{"0 0 1 * */2", "2024-03-01T00:00", false},
+	}
+	for _, test := range tests {
+		s, err := parse_cron(test.expr)
+		if err != nil {
+			t.Fatalf("parse_cron(%s): %v", test.expr, err)
+		}
+		at, _ := time.Parse("2006-01-02T15:04", test.at)
+		if got := s.matches(at); got != test.want {
+			t.Errorf("'%s' matches %s = %v, want %v", test.expr, test.at, got, test.want)
+		}
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, expr := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := parse_cron(expr); err == nil {
+			t.Errorf("parse_cron(%s) accepted an invalid expression", expr)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// AnnotationCreated is the OCI annotation holding the creation time of an
+// image, used when the image config has none
+const AnnotationCreated = "org.opencontainers.image.created"
+
+// Annotations returns the OCI annotations of a manifest or index
+func (m *Manifest) Annotations() (map[string]string, error) {
+	var content struct {
+		Annotations map[string]string `json:"annotations"`
+	}
+	if err := json.Unmarshal(m.Body, &content); err != nil {
+		return nil, err
+	}
+	return content.Annotations, nil
+}
+
+// WithAnnotations returns a copy of the manifest with the given annotations
+// set and the keys in remove deleted. All other fields are kept untouched.
+// The result has a new digest and has to be pushed to take effect.
+func (m *Manifest) WithAnnotations(set map[string]string, remove []string) (*Manifest, error) {
+	if MediaTypeKind(m.MediaType) == "schema1" {
+		return nil, fmt.Errorf("Schema1 manifests can not carry annotations")
+	}
+
+	var content map[string]json.RawMessage
+	if err := json.Unmarshal(m.Body, &content); err != nil {
+		return nil, err
+	}
+	annotations, err := m.Annotations()
+	if err != nil {
+		return nil, err
+	}
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	for k, v := range set {
+		annotations[k] = v
+	}
+	for _, k := range remove {
+		delete(annotations, k)
+	}
+
+	if len(annotations) == 0 {
+		delete(content, "annotations")
+	} else if content["annotations"], err = json.Marshal(annotations); err != nil {
+		return nil, err
+	}
+	body, err := json.MarshalIndent(content, "", "   ")
+	if err != nil {
+		return nil, err
+	}
+	return &Manifest{MediaType: m.MediaType, Digest: Digest(body), Body: body}, nil
+}
@@ -0,0 +1,95 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrListingUnsupported is returned by ListManifests for registries without
+// an API listing the manifests of a repository
+var ErrListingUnsupported = errors.New("Listing manifests is not supported by this registry")
+
+// ManifestInfo describes a manifest stored in a repository, tagged or not
+type ManifestInfo struct {
+	Digest    string
+	MediaType string
+	Size      int64
+	Pushed    time.Time
+	Tags      []string
+}
+
+// ListManifests enumerates every manifest of repo with a single paginated
+// listing, instead of resolving tags one by one. Neither the Registry API nor
+// OCI distribution 1.1, including its extensions, define such an endpoint, so
+// this needs the artifact API of Harbor and returns ErrListingUnsupported
+// elsewhere. Pages hold the entries set with SetPageSize, 100 by default.
+func (r *DockerRegistry) ListManifests(ctx context.Context, repo string) ([]ManifestInfo, error) {
+	if !r.InScope(repo) {
+		return nil, fmt.Errorf("Refusing to access %s, it is outside of the scope %s", repo, strings.TrimSuffix(r.scope, "/"))
+	}
+	if r.Flavor() != FlavorHarbor {
+		return nil, ErrListingUnsupported
+	}
+	repourl, err := r.harbor_repository_url(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	pageSize := r.pageSize
+	if pageSize <= 0 {
+		pageSize = 100
+	}
+	var manifests []ManifestInfo
+	for page := 1; ; page++ {
+		req, err := r.new_request(ctx, "GET", fmt.Sprintf("%s/artifacts?page=%d&page_size=%d&with_tag=true", repourl, page, pageSize), nil)
+		if err != nil {
+			return nil, err
+		}
+		var artifacts []struct {
+			Digest    string    `json:"digest"`
+			MediaType string    `json:"manifest_media_type"`
+			Size      int64     `json:"size"`
+			PushTime  time.Time `json:"push_time"`
+			Tags      []struct {
+				Name string `json:"name"`
+			} `json:"tags"`
+		}
+		err = r.do_api_request(req, func(r *http.Response) error {
+			return json.NewDecoder(r.Body).Decode(&artifacts)
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range artifacts {
+			m := ManifestInfo{Digest: a.Digest, MediaType: a.MediaType, Size: a.Size, Pushed: a.PushTime}
+			for _, tag := range a.Tags {
+				m.Tags = append(m.Tags, tag.Name)
+			}
+			manifests = append(manifests, m)
+		}
+		if len(artifacts) < pageSize {
+			return manifests, nil
+		}
+	}
+}
+
+// UntaggedImages lists the manifests of repo no tag points at, which the
+// Registry API can't do. See ListManifests for the registries supported.
+func (r *DockerRegistry) UntaggedImages(ctx context.Context, repo string) ([]*DockerImage, error) {
+	manifests, err := r.ListManifests(ctx, repo)
+	if err != nil {
+		return nil, err
+	}
+	var imgs []*DockerImage
+	for _, m := range manifests {
+		if len(m.Tags) == 0 {
+			imgs = append(imgs, &DockerImage{Name: repo, ContentDigest: m.Digest, MediaType: m.MediaType, Size: m.Size, Created: m.Pushed})
+		}
+	}
+	return imgs, nil
+}
@@ -0,0 +1,278 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// parse_challenge parses a WWW-Authenticate header of the form
+// Bearer realm="https://auth.example.com/token",service="registry",scope="..."
+func parse_challenge(header string) (scheme string, params map[string]string) {
+	params = make(map[string]string)
+	header = strings.TrimSpace(header)
+	i := strings.IndexByte(header, ' ')
+	if i < 0 {
+		return header, params
+	}
+	scheme, rest := header[:i], header[i+1:]
+	for rest != "" {
+		eq := strings.IndexByte(rest, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = strings.TrimLeft(rest[eq+1:], " ")
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if comma := strings.IndexByte(rest, ','); comma >= 0 {
+			value, rest = rest[:comma], rest[comma:]
+		} else {
+			value, rest = rest, ""
+		}
+		params[key] = value
+		rest = strings.TrimLeft(rest, ", ")
+	}
+	return scheme, params
+}
+
+// token_key identifies the token a request needs: the repository it
+// accesses and whether it only reads. Requests outside of repositories,
+// like the /v2/ ping, get "" and are never authenticated with tokens.
+func token_key(req *http.Request) string {
+	repo := request_repo(req)
+	if repo == "" {
+		return ""
+	}
+	access := "write"
+	if req.Method == "GET" || req.Method == "HEAD" {
+		access = "read"
+	}
+	if repo == "_catalog" {
+		return "catalog " + access
+	}
+	return repo + " " + access
+}
+
+// request_repo returns the repository a request accesses, "_catalog" for
+// the catalog and "" for anything else
+func request_repo(req *http.Request) string {
+	path := req.URL.Path
+	i := strings.Index(path, "/v2/")
+	if i < 0 {
+		return ""
+	}
+	path = path[i+len("/v2/"):]
+	if path == "_catalog" {
+		return path
+	}
+	for _, sep := range []string{"/manifests/", "/blobs/", "/tags/list", "/referrers/"} {
+		if j := strings.LastIndex(path, sep); j > 0 {
+			return path[:j]
+		}
+	}
+	return ""
+}
+
+// token_scope returns the scope a request needs, for challenges which don't
+// name one. Registries requiring tokens even for anonymous pulls (ECR
+// Public, Harbor with anonymous access) may send such challenges, and a
+// token fetched without scope grants nothing.
+func token_scope(req *http.Request) string {
+	repo := request_repo(req)
+	switch {
+	case repo == "_catalog":
+		return "registry:catalog:*"
+	case req.Method == "GET" || req.Method == "HEAD":
+		return "repository:" + repo + ":pull"
+	case req.Method == "DELETE":
+		return "repository:" + repo + ":delete"
+	default:
+		return "repository:" + repo + ":pull,push"
+	}
+}
+
+type bearerToken struct {
+	token   string
+	expires time.Time
+}
+
+// authTransport implements the authentication of the Registry API. With
+// credentials, requests to the registry host carry them as basic auth. A
+// request answered with 401 and a Bearer challenge is retried with a token
+// fetched from the token server for the scope named in the challenge, or the
+// scope the request needs if the challenge names none. This also happens
+// without credentials, for registries requiring tokens for anonymous pulls.
+// Tokens are cached per repository and access, so further requests send them
+// right away.
+type authTransport struct {
+	base     http.RoundTripper
+	host     string
+	username string
+	password string
+	mu       sync.Mutex
+	tokens   map[string]bearerToken
+	//fetching serializes fetching the token of a key, so goroutines
+	//challenged at the same time share one token
+	fetching map[string]*sync.Mutex
+	//userAgent is sent with every request, including to the token server
+	userAgent string
+	retries   int
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.userAgent != "" && req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	//Credentials are never sent to other hosts, like storage backends
+	//blobs are redirected to or the GitLab API
+	if req.Header.Get("Authorization") != "" || req.URL.Host != t.host {
+		return t.base.RoundTrip(req)
+	}
+	key := token_key(req)
+	t.mu.Lock()
+	cached, ok := t.tokens[key]
+	t.mu.Unlock()
+	first := req
+	if key != "" && ok && time.Now().Before(cached.expires) {
+		first = with_token(req, cached.token)
+	} else if t.username != "" {
+		first = req.Clone(req.Context())
+		first.SetBasicAuth(t.username, t.password)
+	}
+
+	//A cached token may lack the scope this request needs, in which case
+	//the registry challenges again
+	resp, err := t.base.RoundTrip(first)
+	if key == "" || err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	scheme, params := parse_challenge(resp.Header.Get("WWW-Authenticate"))
+	if !strings.EqualFold(scheme, "Bearer") || params["realm"] == "" {
+		return resp, nil
+	}
+	if params["scope"] == "" {
+		params["scope"] = token_scope(req)
+	}
+	//The body was consumed by the first attempt, requests whose body can't
+	//be recreated fail with the 401
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+	token, err := t.shared_token(key, cached.token, req, params)
+	if err != nil {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	retry := with_token(req, token.token)
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return t.base.RoundTrip(retry)
+}
+
+// shared_token returns a token for key other than rejected, fetching one
+// unless another goroutine did so while this one waited
+func (t *authTransport) shared_token(key, rejected string, req *http.Request, params map[string]string) (bearerToken, error) {
+	t.mu.Lock()
+	lock, ok := t.fetching[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		t.fetching[key] = lock
+	}
+	t.mu.Unlock()
+	lock.Lock()
+	defer lock.Unlock()
+
+	t.mu.Lock()
+	cached, ok := t.tokens[key]
+	t.mu.Unlock()
+	if ok && cached.token != rejected && time.Now().Before(cached.expires) {
+		return cached, nil
+	}
+	token, err := t.fetch_token(req, params)
+	if err != nil {
+		return token, err
+	}
+	t.mu.Lock()
+	t.tokens[key] = token
+	t.mu.Unlock()
+	return token, nil
+}
+
+func with_token(req *http.Request, token string) *http.Request {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+// fetch_token asks the token server named by the challenge for a token,
+// authenticating with the credentials if there are any
+func (t *authTransport) fetch_token(req *http.Request, params map[string]string) (bearerToken, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil {
+		return bearerToken{}, err
+	}
+	query := realm.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	for _, scope := range strings.Fields(params["scope"]) {
+		query.Add("scope", scope)
+	}
+	realm.RawQuery = query.Encode()
+
+	treq, err := http.NewRequestWithContext(req.Context(), "GET", realm.String(), nil)
+	if err != nil {
+		return bearerToken{}, err
+	}
+	if t.username != "" {
+		treq.SetBasicAuth(t.username, t.password)
+	}
+	if t.userAgent != "" {
+		treq.Header.Set("User-Agent", t.userAgent)
+	}
+	resp, err := with_retries(treq, t.retries, t.base.RoundTrip)
+	if err != nil {
+		return bearerToken{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return bearerToken{}, fmt.Errorf("Token server returned HTTP %d", resp.StatusCode)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return bearerToken{}, err
+	}
+	token := body.Token
+	if token == "" {
+		token = body.AccessToken
+	}
+	if token == "" {
+		return bearerToken{}, fmt.Errorf("Token server returned no token")
+	}
+	//The specification defaults to 60 seconds, renew a little early
+	lifetime := time.Duration(body.ExpiresIn) * time.Second
+	if lifetime <= 0 {
+		lifetime = 60 * time.Second
+	}
+	return bearerToken{token, time.Now().Add(lifetime - lifetime/10)}, nil
+}
@@ -0,0 +1,73 @@
+package registry
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// bandwidthLimiter spreads transfers over time so that all blobs transferred
+// through it together stay below a number of bytes per second
+type bandwidthLimiter struct {
+	mu   sync.Mutex
+	rate float64
+	next time.Time
+}
+
+// wait blocks until n more bytes may be transferred, or ctx is done
+func (l *bandwidthLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+	timer := time.NewTimer(delay)
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		timer.Stop()
+		return ctx.Err()
+	}
+}
+
+type limitedReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *bandwidthLimiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	//Small reads keep the transfer smooth instead of bursting
+	if len(p) > 32*1024 {
+		p = p[:32*1024]
+	}
+	n, err := lr.r.Read(p)
+	if werr := lr.l.wait(lr.ctx, n); werr != nil {
+		return n, werr
+	}
+	return n, err
+}
+
+// SetBandwidthLimit limits blob downloads and uploads to the given number of
+// bytes per second, 0 removes the limit
+func (r *DockerRegistry) SetBandwidthLimit(bytesPerSecond int64) {
+	if bytesPerSecond <= 0 {
+		r.bandwidth = nil
+		return
+	}
+	r.bandwidth = &bandwidthLimiter{rate: float64(bytesPerSecond)}
+}
+
+// limit wraps a blob stream with the bandwidth limit, if one is set. Waiting
+// for the limit ends when ctx is done.
+func (r *DockerRegistry) limit(ctx context.Context, content io.Reader) io.Reader {
+	if r.bandwidth == nil {
+		return content
+	}
+	return &limitedReader{ctx: ctx, r: content, l: r.bandwidth}
+}
@@ -0,0 +1,24 @@
+package registry
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBandwidthWaitCanceled(t *testing.T) {
+	l := &bandwidthLimiter{rate: 1}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	//At one byte per second this would wait for an hour
+	if err := l.wait(ctx, 3600); err != context.Canceled {
+		t.Errorf("wait = %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("wait returned after %s, not when canceled", elapsed)
+	}
+}
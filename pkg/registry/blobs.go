@@ -0,0 +1,140 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// BlobExists checks with a HEAD request whether repo contains the blob
+func (r *DockerRegistry) BlobExists(ctx context.Context, repo, digest string) (bool, error) {
+	req, err := r.new_request(ctx, "HEAD", fmt.Sprintf("%s%s/blobs/%s", r.URL, repo, digest), nil)
+	if err != nil {
+		return false, err
+	}
+	err = r.do_api_request(req, func(r *http.Response) error {
+		return nil
+	})
+	if IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// BlobSize returns the size of a blob using a HEAD request
+func (r *DockerRegistry) BlobSize(ctx context.Context, repo, digest string) (int64, error) {
+	req, err := r.new_request(ctx, "HEAD", fmt.Sprintf("%s%s/blobs/%s", r.URL, repo, digest), nil)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	err = r.do_api_request(req, func(r *http.Response) error {
+		size = r.ContentLength
+		return nil
+	})
+	return size, err
+}
+
+// FetchBlob downloads a blob from repo and hands its content to fn. The
+// reader is only valid until fn returns.
+func (r *DockerRegistry) FetchBlob(ctx context.Context, repo, digest string, fn func(content io.Reader, size int64) error) error {
+	req, err := r.new_request(ctx, "GET", fmt.Sprintf("%s%s/blobs/%s", r.URL, repo, digest), nil)
+	if err != nil {
+		return err
+	}
+	return r.do_api_request(req, func(resp *http.Response) error {
+		return fn(r.limit(ctx, resp.Body), resp.ContentLength)
+	})
+}
+
+// MountBlob makes the blob digest of repository from available in repo
+// without transferring it, using a cross-repository mount. It returns false
+// if the registry doesn't mount it, eg because it doesn't support mounts or
+// the blob isn't readable, in which case the blob has to be pushed.
+func (r *DockerRegistry) MountBlob(ctx context.Context, repo, digest, from string) (bool, error) {
+	mount := fmt.Sprintf("%s%s/blobs/uploads/?mount=%s&from=%s", r.URL, repo, url.QueryEscape(digest), url.QueryEscape(from))
+	req, err := r.new_request(ctx, "POST", mount, nil)
+	if err != nil {
+		return false, err
+	}
+	var status int
+	var location string
+	err = r.do_api_request(req, func(r *http.Response) error {
+		status = r.StatusCode
+		location = r.Header.Get("Location")
+		return nil
+	})
+	if err != nil || status == http.StatusCreated {
+		return err == nil, err
+	}
+	//The registry started a regular upload instead, which is abandoned
+	if upload, err := r.upload_url(location); err == nil && location != "" {
+		if req, err := r.new_request(ctx, "DELETE", upload.String(), nil); err == nil {
+			r.do_api_request(req, func(r *http.Response) error { return nil })
+		}
+	}
+	return false, nil
+}
+
+// upload_url resolves the upload location returned by the registry, which
+// may be relative to the registry and already carry query parameters (eg a
+// session state)
+func (r *DockerRegistry) upload_url(location string) (*url.URL, error) {
+	base, err := url.Parse(r.URL)
+	if err != nil {
+		return nil, err
+	}
+	loc, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	return base.ResolveReference(loc), nil
+}
+
+// PushBlob uploads a blob to repo using a monolithic upload
+func (r *DockerRegistry) PushBlob(ctx context.Context, repo, digest string, content io.Reader, size int64) error {
+	return r.push_blob(ctx, repo, digest, r.limit(ctx, content), size)
+}
+
+// push_blob uploads content as it is, without applying the bandwidth limit
+func (r *DockerRegistry) push_blob(ctx context.Context, repo, digest string, content io.Reader, size int64) error {
+	req, err := r.new_request(ctx, "POST", fmt.Sprintf("%s%s/blobs/uploads/", r.URL, repo), nil)
+	if err != nil {
+		return err
+	}
+	var location string
+	err = r.do_api_request(req, func(r *http.Response) error {
+		location = r.Header.Get("Location")
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if location == "" {
+		return fmt.Errorf("Registry did not return an upload location for %s", repo)
+	}
+
+	upload, err := r.upload_url(location)
+	if err != nil {
+		return err
+	}
+	query := upload.Query()
+	query.Set("digest", digest)
+	upload.RawQuery = query.Encode()
+
+	req, err = r.new_request(ctx, "PUT", upload.String(), content)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	return r.do_api_request(req, func(r *http.Response) error {
+		if d := r.Header.Get("Docker-Content-Digest"); d != "" && !strings.EqualFold(d, digest) {
+			return fmt.Errorf("Registry stored blob as %s, expected %s", d, digest)
+		}
+		return nil
+	})
+}
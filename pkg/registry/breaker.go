@@ -0,0 +1,71 @@
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// CircuitOpenError is returned for every request once the circuit breaker
+// tripped, without contacting the registry
+type CircuitOpenError struct {
+	Failures int
+	//Last is the error of the request that tripped the breaker
+	Last error
+}
+
+func (e CircuitOpenError) Error() string {
+	return fmt.Sprintf("Stopped sending requests after %d consecutive failures, the last one was: %v", e.Failures, e.Last)
+}
+
+// circuitBreaker counts consecutive failed requests. Once threshold is
+// reached it stays open for the lifetime of the client, a registry that
+// keeps failing (an authentication loop, a storm of 5xx) won't recover
+// within one run.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	failures  int
+	last      error
+}
+
+// SetCircuitBreaker makes the client stop sending requests after threshold
+// consecutive failures. Failures are network errors, 5xx responses, 401 and
+// 429. 0 disables the breaker.
+func (r *DockerRegistry) SetCircuitBreaker(threshold int) {
+	r.breaker.mu.Lock()
+	r.breaker.threshold = threshold
+	r.breaker.mu.Unlock()
+}
+
+// CircuitOpen returns a CircuitOpenError if the circuit breaker tripped
+func (r *DockerRegistry) CircuitOpen() error {
+	return r.breaker.check()
+}
+
+func (b *circuitBreaker) check() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.threshold > 0 && b.failures >= b.threshold {
+		return CircuitOpenError{b.failures, b.last}
+	}
+	return nil
+}
+
+// record registers the outcome of a request, err is the transport error or
+// the error response of the registry
+func (b *circuitBreaker) record(err error) {
+	status := StatusCode(err)
+	failed := err != nil && (status == 0 || status >= 500 || status == http.StatusUnauthorized || status == http.StatusTooManyRequests)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.threshold > 0 && b.failures >= b.threshold {
+		return
+	}
+	if failed {
+		b.failures++
+		b.last = err
+	} else {
+		b.failures = 0
+	}
+}
@@ -0,0 +1,162 @@
+package registry
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Limits keeping layers out of the cache, manifests, tag lists and image
+// configs are much smaller
+const (
+	maxCachedBody = 4 << 20
+	maxCachedBlob = 256 << 10
+)
+
+type cachedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+	//path is set instead of body for responses spilled to disk
+	path string
+}
+
+// requestCache remembers successful GET responses for the lifetime of the
+// client, so resources needed by several filters or commands of one
+// invocation are only fetched once. Responses are keyed by URL and Accept
+// header, as the registry may answer in different formats.
+type requestCache struct {
+	mu      sync.Mutex
+	entries map[string]*cachedResponse
+	//memory is the number of body bytes held in memory, once maxMemory is
+	//reached further responses go to spilldir, or aren't cached at all
+	memory    int64
+	maxMemory int64
+	spilldir  string
+}
+
+func cache_key(req *http.Request) string {
+	return req.URL.String() + " " + req.Header.Get("Accept")
+}
+
+// EnableCache turns on the request cache
+func (r *DockerRegistry) EnableCache() {
+	r.cache = &requestCache{entries: make(map[string]*cachedResponse)}
+}
+
+// SetCacheLimit bounds the memory used by the request cache to maxMemory
+// bytes, 0 means unbounded. Responses beyond the limit are written to files
+// in spilldir, or not cached if spilldir is empty. The caller owns spilldir
+// and removes it when done.
+func (r *DockerRegistry) SetCacheLimit(maxMemory int64, spilldir string) {
+	if r.cache != nil {
+		r.cache.mu.Lock()
+		r.cache.maxMemory = maxMemory
+		r.cache.spilldir = spilldir
+		r.cache.mu.Unlock()
+	}
+}
+
+// get answers GET requests, and HEAD requests for resources fetched with GET
+// before, from the cache
+func (c *requestCache) get(req *http.Request) *http.Response {
+	if req.Method != "GET" && req.Method != "HEAD" || req.Header.Get("Cache-Control") == "no-cache" {
+		return nil
+	}
+	c.mu.Lock()
+	entry := c.entries[cache_key(req)]
+	c.mu.Unlock()
+	if entry == nil {
+		return nil
+	}
+	body := entry.body
+	if entry.path != "" {
+		var err error
+		if body, err = os.ReadFile(entry.path); err != nil {
+			return nil
+		}
+	}
+	size := len(body)
+	if req.Method == "HEAD" {
+		body = nil
+	}
+	return &http.Response{
+		StatusCode:    entry.status,
+		Header:        entry.header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(size),
+		Request:       req,
+	}
+}
+
+// put stores the response to a GET request and returns a response reading
+// from the stored copy. Large responses are passed through untouched.
+func (c *requestCache) put(req *http.Request, resp *http.Response) (*http.Response, error) {
+	limit := int64(maxCachedBody)
+	if strings.Contains(req.URL.Path, "/blobs/") {
+		limit = maxCachedBlob
+	}
+	if req.Method != "GET" || resp.ContentLength > limit {
+		return resp, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		resp.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), resp.Body))
+		return resp, nil
+	}
+	c.store(cache_key(req), &cachedResponse{resp.StatusCode, resp.Header.Clone(), body, ""})
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// store keeps entry in memory while the memory limit allows, else spills
+// its body to disk. Failing to spill only means the entry isn't cached.
+func (c *requestCache) store(key string, entry *cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if old := c.entries[key]; old != nil {
+		c.memory -= int64(len(old.body))
+	}
+	size := int64(len(entry.body))
+	if c.maxMemory > 0 && c.memory+size > c.maxMemory {
+		delete(c.entries, key)
+		if c.spilldir == "" {
+			return
+		}
+		f, err := os.CreateTemp(c.spilldir, "response-*")
+		if err != nil {
+			return
+		}
+		_, err = f.Write(entry.body)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(f.Name())
+			return
+		}
+		entry.path, entry.body = f.Name(), nil
+	}
+	c.memory += int64(len(entry.body))
+	c.entries[key] = entry
+}
+
+// clear drops everything, after any request that may have changed the
+// registry
+func (c *requestCache) clear() {
+	c.mu.Lock()
+	for _, entry := range c.entries {
+		if entry.path != "" {
+			os.Remove(entry.path)
+		}
+	}
+	c.entries = make(map[string]*cachedResponse)
+	c.memory = 0
+	c.mu.Unlock()
+}
@@ -0,0 +1,57 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// ImageConfig returns the image config JSON of the image described by m.
+// For schema2 and OCI manifests the config blob is downloaded, for schema1
+// manifests the config embedded in the top history entry is returned.
+func (r *DockerRegistry) ImageConfig(ctx context.Context, repo string, m *Manifest) ([]byte, error) {
+	switch MediaTypeKind(m.MediaType) {
+	case "schema1":
+		var s1 schema1Manifest
+		if err := json.Unmarshal(m.Body, &s1); err != nil {
+			return nil, err
+		}
+		if len(s1.History) == 0 {
+			return nil, fmt.Errorf("Schema1 manifest %s has no history", m.Digest)
+		}
+		return []byte(s1.History[0].V1Compatibility), nil
+	case "schema2", "oci":
+		var m2 manifestV2
+		if err := json.Unmarshal(m.Body, &m2); err != nil {
+			return nil, err
+		}
+		var config []byte
+		err := r.FetchBlob(ctx, repo, m2.Config.Digest, func(content io.Reader, _ int64) error {
+			var err error
+			config, err = io.ReadAll(content)
+			return err
+		})
+		return config, err
+	}
+	return nil, fmt.Errorf("Manifests of type %s have no image config", m.MediaType)
+}
+
+// is_image_config reports whether the config of the manifest m is an image
+// config, as opposed to the config of an artifact
+func is_image_config(m *Manifest) bool {
+	switch MediaTypeKind(m.MediaType) {
+	case "schema1":
+		return true
+	case "schema2", "oci":
+		var m2 manifestV2
+		if err := json.Unmarshal(m.Body, &m2); err != nil {
+			return false
+		}
+		switch m2.Config.MediaType {
+		case MediaTypeImageConfig, MediaTypeOCIConfig, "":
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,217 @@
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+)
+
+// verifyingReader hashes everything read through it and fails at the end of
+// the stream if the content does not match the expected digest
+type verifyingReader struct {
+	r        io.Reader
+	h        hash.Hash
+	expected string
+}
+
+// NewVerifyingReader wraps r so that reading it to the end returns an error
+// unless the content has the given sha256 digest
+func NewVerifyingReader(r io.Reader, digest string) io.Reader {
+	return &verifyingReader{r: r, h: sha256.New(), expected: digest}
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.h.Write(p[:n])
+	if err == io.EOF {
+		if got := fmt.Sprintf("sha256:%x", v.h.Sum(nil)); got != v.expected {
+			return n, fmt.Errorf("Content digest mismatch: expected %s, got %s", v.expected, got)
+		}
+	}
+	return n, err
+}
+
+// CopyOptions control the checks done by CopyImage
+type CopyOptions struct {
+	//RequireDigest makes the copy fail unless the source resolves to
+	//exactly this manifest digest
+	RequireDigest string
+	//Workers is the number of blobs transferred concurrently
+	Workers int
+	//OnBlob is called after every blob, copied is false if the
+	//destination already had it. It may be called concurrently.
+	OnBlob func(blob Descriptor, copied bool)
+	//MountOnly makes the copy fail for blobs the registry doesn't mount
+	//from the source repository, instead of transferring them. It requires
+	//the source and destination to be the same registry.
+	MountOnly bool
+}
+
+// CopyBlob transfers a blob from src to dst unless dst already has it. The
+// content is verified against the digest while streaming. Within a registry
+// the blob is mounted from srcRepo if the registry allows it, instead of
+// being transferred. It returns false if the blob was already present.
+func CopyBlob(ctx context.Context, src *DockerRegistry, srcRepo string, dst *DockerRegistry, dstRepo string, blob Descriptor) (bool, error) {
+	exists, err := dst.BlobExists(ctx, dstRepo, blob.Digest)
+	if err != nil || exists {
+		return false, err
+	}
+	if src.URL == dst.URL && srcRepo != dstRepo {
+		if mounted, err := dst.MountBlob(ctx, dstRepo, blob.Digest, srcRepo); err == nil && mounted {
+			return true, nil
+		}
+	}
+	err = src.FetchBlob(ctx, srcRepo, blob.Digest, func(content io.Reader, size int64) error {
+		//The bytes pushed are those fetched, so the transfer is only limited
+		//once, by src unless only dst has a limit
+		if src.bandwidth == nil {
+			content = dst.limit(ctx, content)
+		}
+		return dst.push_blob(ctx, dstRepo, blob.Digest, NewVerifyingReader(content, blob.Digest), size)
+	})
+	return err == nil, err
+}
+
+// mount_blob makes blob available in dstRepo by mounting it from srcRepo,
+// failing if the registry doesn't mount it. It returns false if the blob was
+// already present.
+func mount_blob(ctx context.Context, r *DockerRegistry, srcRepo, dstRepo string, blob Descriptor) (bool, error) {
+	exists, err := r.BlobExists(ctx, dstRepo, blob.Digest)
+	if err != nil || exists {
+		return false, err
+	}
+	mounted, err := r.MountBlob(ctx, dstRepo, blob.Digest, srcRepo)
+	if err == nil && !mounted {
+		err = fmt.Errorf("The registry didn't mount it from %s", srcRepo)
+	}
+	return mounted, err
+}
+
+// copy_blobs transfers blobs using opts.Workers goroutines. Blobs listed
+// more than once (eg empty schema1 layers) are only transferred once.
+func copy_blobs(ctx context.Context, src *DockerRegistry, srcRepo string, dst *DockerRegistry, dstRepo string, blobs []Descriptor, opts CopyOptions) error {
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	jobs := make(chan Descriptor)
+	errs := make(chan error, len(blobs))
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for blob := range jobs {
+				var copied bool
+				var err error
+				if opts.MountOnly {
+					copied, err = mount_blob(ctx, dst, srcRepo, dstRepo, blob)
+				} else {
+					copied, err = CopyBlob(ctx, src, srcRepo, dst, dstRepo, blob)
+				}
+				if err != nil {
+					errs <- fmt.Errorf("Unable to copy blob %s: %v", blob.Digest, err)
+					continue
+				}
+				if opts.OnBlob != nil {
+					opts.OnBlob(blob, copied)
+				}
+			}
+		}()
+	}
+	seen := make(map[string]bool)
+	for _, blob := range blobs {
+		if !seen[blob.Digest] {
+			seen[blob.Digest] = true
+			jobs <- blob
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+	//Report the first failure, the manifest can't be pushed anyway
+	return <-errs
+}
+
+// copy_manifest copies the manifest m (and everything it refers to) from
+// srcRepo to dstRepo, pushing it under dstRef
+func copy_manifest(ctx context.Context, src *DockerRegistry, srcRepo string, m *Manifest, dst *DockerRegistry, dstRepo, dstRef string, opts CopyOptions) error {
+	got, err := m.ComputeDigest()
+	if err != nil {
+		return err
+	}
+	if m.Digest != "" && got != m.Digest {
+		return fmt.Errorf("Manifest content of %s does not match its digest %s", got, m.Digest)
+	}
+
+	children, err := m.Children()
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		cm, err := src.GetManifest(ctx, srcRepo, child.Digest)
+		if err != nil {
+			return err
+		}
+		if err := copy_manifest(ctx, src, srcRepo, cm, dst, dstRepo, child.Digest, opts); err != nil {
+			return err
+		}
+	}
+
+	blobs, err := m.Blobs()
+	if err != nil {
+		return err
+	}
+	if err := copy_blobs(ctx, src, srcRepo, dst, dstRepo, blobs, opts); err != nil {
+		return err
+	}
+
+	digest, err := dst.PutManifest(ctx, dstRepo, dstRef, m)
+	if err != nil {
+		return err
+	}
+	if digest != "" && m.Digest != "" && digest != m.Digest {
+		return fmt.Errorf("Destination stored the manifest as %s instead of %s", digest, m.Digest)
+	}
+	return nil
+}
+
+// CopyImage copies srcRepo:srcRef from src to dstRepo:dstRef on dst, which
+// may be the same registry. The manifest is pushed byte for byte, so the
+// digest is preserved. The source is resolved once and checked again before
+// the manifest is pushed, so a tag repointed during the transfer makes the
+// copy fail instead of mixing two images. It returns the copied digest.
+func CopyImage(ctx context.Context, src *DockerRegistry, srcRepo, srcRef string, dst *DockerRegistry, dstRepo, dstRef string, opts CopyOptions) (string, error) {
+	if opts.MountOnly && src.URL != dst.URL {
+		return "", fmt.Errorf("Blobs can only be mounted within a registry")
+	}
+	m, err := src.GetManifest(ctx, srcRepo, srcRef)
+	if err != nil {
+		return "", err
+	}
+	if m.Digest == "" {
+		m.Digest = Digest(m.Body)
+	}
+	if opts.RequireDigest != "" && m.Digest != opts.RequireDigest {
+		return "", fmt.Errorf("%s:%s resolves to %s, but %s is required", srcRepo, srcRef, m.Digest, opts.RequireDigest)
+	}
+
+	if err := copy_manifest(ctx, src, srcRepo, m, dst, dstRepo, dstRef, opts); err != nil {
+		return "", err
+	}
+
+	if current, err := src.manifest_digest(ctx, srcRepo, srcRef, true); err != nil {
+		return "", err
+	} else if current != m.Digest {
+		return "", fmt.Errorf("%s:%s was repointed from %s to %s during the copy", srcRepo, srcRef, m.Digest, current)
+	}
+	if copied, err := dst.manifest_digest(ctx, dstRepo, dstRef, true); err != nil {
+		return "", err
+	} else if copied != m.Digest {
+		return "", fmt.Errorf("%s:%s resolves to %s after the copy, expected %s", dstRepo, dstRef, copied, m.Digest)
+	}
+	return m.Digest, nil
+}
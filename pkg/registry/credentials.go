@@ -0,0 +1,122 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// dockerHubKey is the key docker login stores Docker Hub credentials under
+const dockerHubKey = "https://index.docker.io/v1/"
+
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth string `json:"auth"`
+	} `json:"auths"`
+	CredHelpers map[string]string `json:"credHelpers"`
+	CredsStore  string            `json:"credsStore"`
+}
+
+// docker_config_path returns the config.json of the docker CLI, which may
+// be moved with DOCKER_CONFIG
+func docker_config_path() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".docker", "config.json"), nil
+}
+
+// DockerCredentials looks up the credentials `docker login` stored for the
+// host of registryURL, in config.json or through the credential helper
+// configured for the host (docker-credential-*). Empty credentials and no
+// error are returned if there are none.
+func DockerCredentials(registryURL string) (username, password string, err error) {
+	path, err := docker_config_path()
+	if err != nil {
+		return "", "", err
+	}
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", "", nil
+	} else if err != nil {
+		return "", "", err
+	}
+	var config dockerConfig
+	if err := json.Unmarshal(content, &config); err != nil {
+		return "", "", fmt.Errorf("Unable to parse %s: %v", path, err)
+	}
+
+	host := registryURL
+	if u, err := url.Parse(registryURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	keys := []string{host, "https://" + host, "http://" + host}
+	switch host {
+	case "docker.io", "index.docker.io", "registry-1.docker.io":
+		keys = []string{dockerHubKey}
+	}
+
+	for _, key := range keys {
+		if helper, ok := config.CredHelpers[key]; ok {
+			return credential_helper(helper, key)
+		}
+	}
+	for _, key := range keys {
+		auth, ok := config.Auths[key]
+		if !ok {
+			continue
+		}
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return "", "", fmt.Errorf("Invalid credentials for %s in %s: %v", key, path, err)
+			}
+			user, pass, _ := strings.Cut(string(decoded), ":")
+			return user, pass, nil
+		}
+		//Without an inline auth the credentials are in the credential store
+		if config.CredsStore != "" {
+			return credential_helper(config.CredsStore, key)
+		}
+	}
+	return "", "", nil
+}
+
+// credential_helper runs docker-credential-<helper> get for key
+func credential_helper(helper, key string) (string, string, error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(key)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		//Helpers report missing credentials on stdout
+		msg := strings.TrimSpace(stdout.String() + stderr.String())
+		if strings.Contains(msg, "credentials not found") {
+			return "", "", nil
+		}
+		return "", "", fmt.Errorf("docker-credential-%s failed: %v %s", helper, err, msg)
+	}
+	var creds struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return "", "", fmt.Errorf("Unable to parse the output of docker-credential-%s: %v", helper, err)
+	}
+	//Identity tokens are OAuth refresh tokens, which the Registry API
+	//doesn't take as passwords
+	if creds.Username == "<token>" {
+		return "", "", fmt.Errorf("docker-credential-%s returned an identity token, which is not supported", helper)
+	}
+	return creds.Username, creds.Secret, nil
+}
@@ -0,0 +1,66 @@
+package registry
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+const imageConfigJSON = `{"created": "2024-03-01T10:00:00Z", "os": "linux", "architecture": "arm64", "variant": "v8",
+	"config": {"Labels": {"team": "a"}}, "history": [{"created_by": "buildkit"}]}`
+
+func TestImageDetailsFromConfigBlob(t *testing.T) {
+	for _, kind := range []struct{ manifest, config string }{
+		{MediaTypeSchema2, MediaTypeImageConfig},
+		{MediaTypeOCIManifest, MediaTypeOCIConfig},
+	} {
+		f := new_fake_registry(t)
+		config := f.push_blob(kind.config, []byte(imageConfigJSON))
+		digest := f.push_manifest("app", "1.0", manifestV2{SchemaVersion: 2, MediaType: kind.manifest, Config: config, Layers: []Descriptor{}})
+		r := f.connect(t)
+
+		img, err := r.ImageDetails(context.Background(), "app:1.0")
+		if err != nil {
+			t.Fatalf("%s: %v", kind.manifest, err)
+		}
+		if img.ContentDigest != digest {
+			t.Errorf("%s: got digest %s, want %s", kind.manifest, img.ContentDigest, digest)
+		}
+		if want := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC); !img.Created.Equal(want) {
+			t.Errorf("%s: got created %v, want %v", kind.manifest, img.Created, want)
+		}
+		if img.OS != "linux" || img.Architecture != "arm64" || img.Variant != "v8" {
+			t.Errorf("%s: got platform %s/%s/%s, want linux/arm64/v8", kind.manifest, img.OS, img.Architecture, img.Variant)
+		}
+		if img.Labels["team"] != "a" {
+			t.Errorf("%s: got labels %v, want team=a", kind.manifest, img.Labels)
+		}
+		if n := f.count("GET", "/app/manifests/1.0"); n != 1 {
+			t.Errorf("%s: got %d manifest requests, want 1", kind.manifest, n)
+		}
+	}
+}
+
+func TestImageDetailsOfArtifact(t *testing.T) {
+	f := new_fake_registry(t)
+	config := f.push_blob("application/vnd.cncf.helm.config.v1+json", []byte(`{"name": "chart", "version": "1.0.0"}`))
+	f.push_manifest("charts/app", "1.0.0", manifestV2{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIManifest,
+		Config:        config,
+		Layers:        []Descriptor{},
+		Annotations:   map[string]string{AnnotationCreated: "2024-03-01T10:00:00Z"},
+	})
+	r := f.connect(t)
+
+	img, err := r.ImageDetails(context.Background(), "charts/app:1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC); !img.Created.Equal(want) {
+		t.Errorf("Got created %v, want %v from the annotation", img.Created, want)
+	}
+	if n := f.count("GET", config.Digest); n != 0 {
+		t.Errorf("Got %d requests of the helm config, want none", n)
+	}
+}
@@ -0,0 +1,38 @@
+// Package registry is a client for the Docker Registry HTTP API V2 and the
+// vendor APIs some registries offer next to it (Harbor, GitLab, Quay...).
+// It is a module of its own, used by the docker-regclient command in
+// cmd/regclient but without any dependency on it, so other Go programs can
+// use it on its own:
+//
+//	r, err := registry.NewDockerRegistry("https://my.registry.com:5000",
+//		registry.WithCredentials(username, password),
+//		registry.WithTimeout(time.Minute))
+//	if err != nil {
+//		return err
+//	}
+//	r.SetReadOnly(true)
+//	tags, err := r.Tags(ctx, "webserver")
+//
+// Walk traverses a whole registry, listing the tags of several repositories
+// at once while it reads the catalog:
+//
+//	err = r.Walk(ctx, func(repo string, tags []string) error {
+//		fmt.Println(repo, len(tags))
+//		return nil
+//	})
+//
+// Every method talking to the registry takes a context, which cancels the
+// requests in flight and bounds them with its deadline. Spans carried by the
+// context become the parents of the spans of the requests.
+//
+// The connection is configured with the With* options (timeout, transport,
+// credentials, user agent, logger and rate limit), the client with its Set*
+// methods after connecting. Errors returned by the registry can be
+// inspected with StatusCode, IsNotFound and IsImmutable, or compared against
+// ErrReadOnly, ErrProtected, ErrNotRegistry, ErrAuthentication,
+// ErrListingUnsupported and ErrPullTimeUnsupported.
+// A client can be shared by many goroutines once it is configured, they
+// then share its tokens, cache and limits.
+// The package never writes to the log, except for warnings when no
+// OnWarning callback is registered, which go to the WithLogger logger.
+package registry
@@ -0,0 +1,248 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRegistry is an in-memory registry served by httptest. Faults answer
+// matching requests in its place, to test the client against failing
+// registries.
+type fakeRegistry struct {
+	*httptest.Server
+	mu sync.Mutex
+	//manifests maps repositories to tags and digests to manifests
+	manifests map[string]map[string][]byte
+	tags      map[string][]string
+	//blobs maps digests to blobs, shared by every repository
+	blobs  map[string][]byte
+	faults []*fault
+	//requests has the method and path of every request received
+	requests []string
+}
+
+// fault answers the next times requests of method to a path ending in
+// suffix with handler, forever if times is negative
+type fault struct {
+	method  string
+	suffix  string
+	times   int
+	handler http.HandlerFunc
+}
+
+func new_fake_registry(t *testing.T) *fakeRegistry {
+	f := &fakeRegistry{manifests: map[string]map[string][]byte{}, tags: map[string][]string{}, blobs: map[string][]byte{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// connect returns a client of the fake registry
+func (f *fakeRegistry) connect(t *testing.T, opts ...Option) *DockerRegistry {
+	t.Helper()
+	r, err := NewDockerRegistry(f.URL, opts...)
+	if err != nil {
+		t.Fatalf("Unable to connect to the fake registry: %v", err)
+	}
+	return r
+}
+
+// push stores a schema2 manifest under repo:tag and returns its digest
+func (f *fakeRegistry) push(repo, tag string) string {
+	return f.push_manifest(repo, tag, manifestV2{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeSchema2,
+		Config:        Descriptor{MediaType: "application/vnd.docker.container.image.v1+json", Size: 2, Digest: "sha256:" + strings.Repeat("0", 64)},
+		Annotations:   map[string]string{"tag": tag},
+	})
+}
+
+// push_blob stores content as a blob and returns its descriptor
+func (f *fakeRegistry) push_blob(mediaType string, content []byte) Descriptor {
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blobs[digest] = content
+	return Descriptor{MediaType: mediaType, Size: int64(len(content)), Digest: digest}
+}
+
+// push_manifest stores m under repo:tag and returns its digest
+func (f *fakeRegistry) push_manifest(repo, tag string, m manifestV2) string {
+	body, _ := json.Marshal(m)
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(body))
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.manifests[repo] == nil {
+		f.manifests[repo] = map[string][]byte{}
+	}
+	_, moved := f.manifests[repo][tag]
+	f.manifests[repo][tag] = body
+	f.manifests[repo][digest] = body
+	if !moved {
+		f.tags[repo] = append(f.tags[repo], tag)
+	}
+	return digest
+}
+
+// fail answers times requests of method to paths ending in suffix with
+// handler instead of the registry
+func (f *fakeRegistry) fail(method, suffix string, times int, handler http.HandlerFunc) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = append(f.faults, &fault{method, suffix, times, handler})
+}
+
+// count returns how many requests of method to paths ending in suffix were
+// received, including those answered by faults
+func (f *fakeRegistry) count(method, suffix string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, req := range f.requests {
+		if strings.HasPrefix(req, method+" ") && strings.HasSuffix(req, suffix) {
+			n++
+		}
+	}
+	return n
+}
+
+// status_fault answers with status and an error body, and a Retry-After
+// header unless retryAfter is empty
+func status_fault(status int, retryAfter string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `{"errors":[{"code":"UNAVAILABLE","message":"injected %d"}]}`, status)
+	}
+}
+
+// drop_connection closes the connection without answering, as a crashed
+// proxy does
+func drop_connection(w http.ResponseWriter, req *http.Request) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err == nil {
+		conn.Close()
+	}
+}
+
+// truncate_body announces a longer body than it sends before closing the
+// connection
+func truncate_body(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", MediaTypeSchema2)
+	w.Header().Set("Content-Length", "1000")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, `{"schemaVersion":2,`)
+}
+
+func (f *fakeRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	f.mu.Lock()
+	f.requests = append(f.requests, req.Method+" "+req.URL.Path)
+	var handler http.HandlerFunc
+	for _, fault := range f.faults {
+		if fault.times != 0 && fault.method == req.Method && strings.HasSuffix(req.URL.Path, fault.suffix) {
+			fault.times--
+			handler = fault.handler
+			break
+		}
+	}
+	f.mu.Unlock()
+	if handler != nil {
+		handler(w, req)
+		return
+	}
+
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	switch {
+	case path == "":
+		w.WriteHeader(http.StatusOK)
+	case path == "_catalog":
+		f.mu.Lock()
+		repos := []string{}
+		for repo := range f.tags {
+			repos = append(repos, repo)
+		}
+		f.mu.Unlock()
+		sort.Strings(repos)
+		json.NewEncoder(w).Encode(Repolist{Repositories: repos})
+	case strings.HasSuffix(path, "/tags/list"):
+		f.mu.Lock()
+		tags := f.tags[strings.TrimSuffix(path, "/tags/list")]
+		f.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string][]string{"tags": tags})
+	case strings.Contains(path, "/manifests/"):
+		f.serve_manifest(w, req, path)
+	case strings.Contains(path, "/blobs/sha256:") && (req.Method == "GET" || req.Method == "HEAD"):
+		f.mu.Lock()
+		blob, ok := f.blobs[path[strings.LastIndex(path, "/")+1:]]
+		f.mu.Unlock()
+		if !ok {
+			status_fault(http.StatusNotFound, "")(w, req)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(blob)))
+		if req.Method == "GET" {
+			w.Write(blob)
+		}
+	default:
+		status_fault(http.StatusNotFound, "")(w, req)
+	}
+}
+
+func (f *fakeRegistry) serve_manifest(w http.ResponseWriter, req *http.Request, path string) {
+	i := strings.LastIndex(path, "/manifests/")
+	repo, ref := path[:i], path[i+len("/manifests/"):]
+	if req.Method == "PUT" {
+		var m manifestV2
+		body, _ := io.ReadAll(req.Body)
+		if err := json.Unmarshal(body, &m); err != nil {
+			status_fault(http.StatusBadRequest, "")(w, req)
+			return
+		}
+		f.push_manifest(repo, ref, m)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	f.mu.Lock()
+	body, ok := f.manifests[repo][ref]
+	if ok && req.Method == "DELETE" {
+		for ref, b := range f.manifests[repo] {
+			if string(b) == string(body) {
+				delete(f.manifests[repo], ref)
+			}
+		}
+	}
+	f.mu.Unlock()
+	if !ok {
+		status_fault(http.StatusNotFound, "")(w, req)
+		return
+	}
+	switch req.Method {
+	case "DELETE":
+		w.WriteHeader(http.StatusAccepted)
+	case "GET", "HEAD":
+		var m struct {
+			MediaType string `json:"mediaType"`
+		}
+		json.Unmarshal(body, &m)
+		w.Header().Set("Content-Type", m.MediaType)
+		w.Header().Set("Docker-Content-Digest", fmt.Sprintf("sha256:%x", sha256.Sum256(body)))
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		if req.Method == "GET" {
+			w.Write(body)
+		}
+	default:
+		status_fault(http.StatusMethodNotAllowed, "")(w, req)
+	}
+}
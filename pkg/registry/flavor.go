@@ -0,0 +1,176 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Flavor identifies the registry implementation, which determines the
+// vendor specific APIs available next to the Registry API
+type Flavor string
+
+const (
+	FlavorDistribution Flavor = "distribution"
+	FlavorHarbor       Flavor = "harbor"
+	FlavorGitLab       Flavor = "gitlab"
+	FlavorQuay         Flavor = "quay"
+	FlavorNexus        Flavor = "nexus"
+	FlavorArtifactory  Flavor = "artifactory"
+	FlavorECR          Flavor = "ecr"
+	FlavorGCR          Flavor = "gcr"
+)
+
+// SetFlavor overrides the flavor detected when connecting to the registry
+func (r *DockerRegistry) SetFlavor(f Flavor) {
+	r.flavor = f
+}
+
+// detect_flavor guesses the registry implementation from the host name and
+// the response to the /v2/ endpoint. Token based registries announce their
+// token service in WWW-Authenticate, which is usually distinctive.
+func detect_flavor(resp *http.Response) Flavor {
+	host := resp.Request.URL.Hostname()
+	realm := resp.Header.Get("WWW-Authenticate")
+	switch {
+	case strings.Contains(host, ".dkr.ecr.") && strings.HasSuffix(host, ".amazonaws.com"):
+		return FlavorECR
+	case host == "gcr.io" || strings.HasSuffix(host, ".gcr.io") || strings.HasSuffix(host, "-docker.pkg.dev"):
+		return FlavorGCR
+	case resp.Header.Get("X-Artifactory-Id") != "" || resp.Header.Get("X-JFrog-Version") != "":
+		return FlavorArtifactory
+	case strings.HasPrefix(resp.Header.Get("Server"), "Nexus/"):
+		return FlavorNexus
+	case strings.Contains(realm, "/service/token"):
+		return FlavorHarbor
+	case strings.Contains(realm, "/jwt/auth"):
+		return FlavorGitLab
+	case host == "quay.io" || strings.Contains(realm, "/v2/auth"):
+		return FlavorQuay
+	}
+	return FlavorDistribution
+}
+
+// Flavor returns the registry implementation, as detected or set with
+// SetFlavor
+func (r *DockerRegistry) Flavor() Flavor {
+	if r.flavor == "" {
+		return FlavorDistribution
+	}
+	return r.flavor
+}
+
+// SetGitLabAPI configures the GitLab API used for repository management,
+// which is served separately from the registry
+func (r *DockerRegistry) SetGitLabAPI(apiurl, token string) {
+	r.gitlabURL = strings.TrimSuffix(apiurl, "/")
+	r.gitlabToken = token
+}
+
+// base_url returns the root URL of the registry host, without the /v2/ path
+func (r *DockerRegistry) base_url() string {
+	return strings.TrimSuffix(r.URL, "v2/")
+}
+
+// DeleteRepository removes an (empty) repository. The Registry API has no
+// such operation, so this is only supported for Harbor and GitLab.
+func (r *DockerRegistry) DeleteRepository(ctx context.Context, repo string) error {
+	if !r.InScope(repo) {
+		return fmt.Errorf("Refusing to delete %s, it is outside of the scope %s", repo, strings.TrimSuffix(r.scope, "/"))
+	}
+	switch r.Flavor() {
+	case FlavorHarbor:
+		return r.harbor_delete_repository(ctx, repo)
+	case FlavorGitLab:
+		return r.gitlab_delete_repository(ctx, repo)
+	}
+	return fmt.Errorf("Deleting repositories is not supported by %s registries", r.Flavor())
+}
+
+// harbor_repository_url returns the Harbor API URL of repo
+func (r *DockerRegistry) harbor_repository_url(repo string) (string, error) {
+	parts := strings.SplitN(repo, "/", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("Harbor repository %s is not of the form project/repository", repo)
+	}
+	//Harbor expects slashes in the repository name to be encoded twice
+	name := url.PathEscape(url.PathEscape(parts[1]))
+	return fmt.Sprintf("%sapi/v2.0/projects/%s/repositories/%s", r.base_url(), url.PathEscape(parts[0]), name), nil
+}
+
+func (r *DockerRegistry) harbor_delete_repository(ctx context.Context, repo string) error {
+	repourl, err := r.harbor_repository_url(repo)
+	if err != nil {
+		return err
+	}
+	req, err := r.new_request(ctx, "DELETE", repourl, nil)
+	if err != nil {
+		return err
+	}
+	return r.do_api_request(req, func(r *http.Response) error {
+		return nil
+	})
+}
+
+func (r *DockerRegistry) gitlab_request(ctx context.Context, method, path string) (*http.Request, error) {
+	if r.gitlabURL == "" {
+		return nil, fmt.Errorf("The GitLab API URL must be configured to manage GitLab repositories")
+	}
+	req, err := r.new_request(ctx, method, r.gitlabURL+"/api/v4/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if r.gitlabToken != "" {
+		req.Header.Set("PRIVATE-TOKEN", r.gitlabToken)
+	}
+	return req, nil
+}
+
+func (r *DockerRegistry) gitlab_delete_repository(ctx context.Context, repo string) error {
+	//The registry path is <project path>[/<image name>], so we try the
+	//longest project path first
+	segments := strings.Split(repo, "/")
+	for n := len(segments); n >= 2; n-- {
+		project := strings.Join(segments[:n], "/")
+		req, err := r.gitlab_request(ctx, "GET", fmt.Sprintf("projects/%s/registry/repositories", url.PathEscape(project)))
+		if err != nil {
+			return err
+		}
+		var repos []struct {
+			ID        int    `json:"id"`
+			Path      string `json:"path"`
+			ProjectID int    `json:"project_id"`
+		}
+		err = r.do_api_request(req, func(r *http.Response) error {
+			return json.NewDecoder(r.Body).Decode(&repos)
+		})
+		if IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		for _, candidate := range repos {
+			if candidate.Path != repo {
+				continue
+			}
+			req, err := r.gitlab_request(ctx, "DELETE", fmt.Sprintf("projects/%d/registry/repositories/%d", candidate.ProjectID, candidate.ID))
+			if err != nil {
+				return err
+			}
+			return r.do_api_request(req, func(r *http.Response) error {
+				return nil
+			})
+		}
+	}
+	return fmt.Errorf("Repository %s not found in the GitLab API", repo)
+}
+
+// delete_tag_first reports whether the registry refuses to delete a manifest
+// by digest while tags still point at it. These registries accept deleting a
+// tag through the manifest endpoint instead.
+func (r *DockerRegistry) delete_tag_first() bool {
+	return r.Flavor() == FlavorGCR || r.Flavor() == FlavorQuay
+}
@@ -0,0 +1,127 @@
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Identity describes who the registry takes the client for and what it may
+// do
+type Identity struct {
+	//Username is empty for anonymous access
+	Username string
+	//Method is how the client authenticates: "anonymous", "basic" or "token"
+	Method string
+	//Scopes are the access rights granted by the token server, in the
+	//type:name:actions form of token scopes. They are nil if the registry
+	//doesn't use tokens or its tokens aren't JWTs.
+	Scopes []string
+	//Expires is when the token expires, zero without token
+	Expires time.Time
+	//Admin is set if the registry reports the user as administrator (Harbor)
+	Admin bool
+}
+
+// tokenClaims are the claims of the JWTs issued by Docker token servers
+type tokenClaims struct {
+	Subject   string `json:"sub"`
+	ExpiresAt int64  `json:"exp"`
+	Access    []struct {
+		Type    string   `json:"type"`
+		Name    string   `json:"name"`
+		Actions []string `json:"actions"`
+	} `json:"access"`
+}
+
+// parse_token_claims decodes the payload of a JWT without verifying it,
+// tokens are only read to show what they grant
+func parse_token_claims(token string) (*tokenClaims, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, false
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, false
+	}
+	return &claims, true
+}
+
+// WhoAmI reports the identity the registry authenticates the client as. On
+// registries using tokens, a token for every action on repo (pull, push and
+// delete) is requested, and the scopes the token server granted are
+// returned. Without repo, access to the catalog is requested.
+func (r *DockerRegistry) WhoAmI(ctx context.Context, repo string) (*Identity, error) {
+	auth := r.client.Transport.(*authTransport)
+	id := &Identity{Username: auth.username, Method: "anonymous"}
+	if auth.username != "" {
+		id.Method = "basic"
+	}
+
+	req, err := r.new_request(ctx, "GET", r.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.send(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	scheme, params := parse_challenge(resp.Header.Get("WWW-Authenticate"))
+	if resp.StatusCode == http.StatusUnauthorized && strings.EqualFold(scheme, "Bearer") && params["realm"] != "" {
+		params["scope"] = "registry:catalog:*"
+		if repo != "" {
+			params["scope"] = fmt.Sprintf("repository:%s:pull,push,delete", repo)
+		}
+		token, err := auth.fetch_token(req, params)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrAuthentication, err)
+		}
+		id.Method = "token"
+		id.Expires = token.expires
+		if claims, ok := parse_token_claims(token.token); ok {
+			if claims.Subject != "" {
+				id.Username = claims.Subject
+			}
+			if claims.ExpiresAt > 0 {
+				id.Expires = time.Unix(claims.ExpiresAt, 0)
+			}
+			id.Scopes = []string{}
+			for _, access := range claims.Access {
+				id.Scopes = append(id.Scopes, fmt.Sprintf("%s:%s:%s", access.Type, access.Name, strings.Join(access.Actions, ",")))
+			}
+		}
+	} else if resp.StatusCode == http.StatusUnauthorized {
+		return nil, fmt.Errorf("%w: %s rejected the credentials", ErrAuthentication, r.URL)
+	}
+
+	//Robot accounts and tokens without access to the user API keep the
+	//identity found so far
+	if r.Flavor() == FlavorHarbor && auth.username != "" {
+		req, err := r.new_request(ctx, "GET", r.base_url()+"api/v2.0/users/current", nil)
+		if err != nil {
+			return nil, err
+		}
+		var user struct {
+			Username string `json:"username"`
+			Admin    bool   `json:"sysadmin_flag"`
+		}
+		err = r.do_api_request(req, func(r *http.Response) error {
+			return json.NewDecoder(r.Body).Decode(&user)
+		})
+		if err == nil && user.Username != "" {
+			id.Username = user.Username
+			id.Admin = user.Admin
+		}
+	}
+	return id, nil
+}
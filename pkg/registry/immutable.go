@@ -0,0 +1,152 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ImmutableError is returned when the registry refuses to delete an image
+// because a tag immutability or protection rule covers it. Rule describes
+// the rule, as far as the registry tells.
+type ImmutableError struct {
+	Image string
+	Rule  string
+	Err   error
+}
+
+func (e ImmutableError) Error() string {
+	return fmt.Sprintf("%s is immutable (%s)", e.Image, e.Rule)
+}
+
+func (e ImmutableError) Unwrap() error {
+	return e.Err
+}
+
+// IsImmutable reports whether err was caused by an immutability rule
+func IsImmutable(err error) bool {
+	var ie ImmutableError
+	return errors.As(err, &ie)
+}
+
+// immutableCodes are the error codes with which registries refuse to
+// delete an image covered by an immutability rule, by flavor. When message
+// is set, the message of the error must contain it too, for codes also used
+// for other refusals.
+var immutableCodes = map[Flavor][]struct{ code, message string }{
+	//Harbor answers 412 Precondition Failed for immutable artifacts
+	FlavorHarbor: {{"PRECONDITION", ""}},
+	FlavorQuay:   {{"TAG_IMMUTABLE", ""}},
+	//GCR and Artifact Registry deny any change to immutable tags
+	FlavorGCR: {{"DENIED", "immutable"}},
+}
+
+// is_immutable reports whether err is the answer of a registry of flavor
+// to a delete prevented by an immutability rule
+func is_immutable(flavor Flavor, err error) bool {
+	var re RegistryErrorResponse
+	if !errors.As(err, &re) {
+		return false
+	}
+	for _, e := range re.Errors {
+		for _, c := range immutableCodes[flavor] {
+			if e.Code == c.code && strings.Contains(strings.ToLower(e.Message), c.message) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// immutable_error turns err into an ImmutableError if the registry refused
+// to delete img because of an immutability rule, as told by the error codes
+// of its flavor
+func (r *DockerRegistry) immutable_error(ctx context.Context, img *DockerImage, err error) error {
+	if err == nil || IsImmutable(err) || !is_immutable(r.Flavor(), err) {
+		return err
+	}
+	ref := img.Name + ":" + img.Tag
+	if img.Tag == "" {
+		ref = img.Name + "@" + img.ContentDigest
+	}
+	rule := err.Error()
+	if r.Flavor() == FlavorHarbor {
+		if rules, rerr := r.harbor_immutable_rules(ctx, img.Name, img.Tag); rerr == nil && len(rules) > 0 {
+			rule = strings.Join(rules, "; ")
+		}
+	}
+	return ImmutableError{ref, rule, err}
+}
+
+type harborSelector struct {
+	Decoration string `json:"decoration"`
+	Pattern    string `json:"pattern"`
+}
+
+// matches applies a Harbor selector, supporting the "**" wildcard only as
+// the whole pattern
+func (s harborSelector) matches(name string) bool {
+	ok := s.Pattern == "**"
+	if !ok {
+		ok, _ = path.Match(s.Pattern, name)
+	}
+	if strings.HasSuffix(s.Decoration, "xcludes") {
+		return !ok
+	}
+	return ok
+}
+
+// harbor_immutable_rules describes the enabled immutability rules of the
+// project of repo matching repo and tag
+func (r *DockerRegistry) harbor_immutable_rules(ctx context.Context, repo, tag string) ([]string, error) {
+	parts := strings.SplitN(repo, "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Harbor repository %s is not of the form project/repository", repo)
+	}
+	req, err := r.new_request(ctx, "GET", fmt.Sprintf("%sapi/v2.0/projects/%s/immutabletagrules", r.base_url(), url.PathEscape(parts[0])), nil)
+	if err != nil {
+		return nil, err
+	}
+	var rules []struct {
+		ID             int64            `json:"id"`
+		Disabled       bool             `json:"disabled"`
+		TagSelectors   []harborSelector `json:"tag_selectors"`
+		ScopeSelectors struct {
+			Repository []harborSelector `json:"repository"`
+		} `json:"scope_selectors"`
+	}
+	err = r.do_api_request(req, func(r *http.Response) error {
+		return json.NewDecoder(r.Body).Decode(&rules)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var matching []string
+Rules:
+	for _, rule := range rules {
+		if rule.Disabled {
+			continue
+		}
+		var desc []string
+		for _, s := range rule.ScopeSelectors.Repository {
+			if !s.matches(parts[1]) {
+				continue Rules
+			}
+			desc = append(desc, fmt.Sprintf("repositories %s %s", s.Decoration, s.Pattern))
+		}
+		for _, s := range rule.TagSelectors {
+			if tag != "" && !s.matches(tag) {
+				continue Rules
+			}
+			desc = append(desc, fmt.Sprintf("tags %s %s", s.Decoration, s.Pattern))
+		}
+		matching = append(matching, fmt.Sprintf("Harbor immutability rule %d: %s", rule.ID, strings.Join(desc, ", ")))
+	}
+	return matching, nil
+}
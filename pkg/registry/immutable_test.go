@@ -0,0 +1,38 @@
+package registry
+
+import (
+	"errors"
+	"testing"
+)
+
+func registry_error(status int, code, message string) error {
+	re := RegistryErrorResponse{StatusCode: status}
+	re.Errors = append(re.Errors, struct {
+		Code    string
+		Message string
+	}{code, message})
+	return re
+}
+
+func TestIsImmutable(t *testing.T) {
+	tests := []struct {
+		flavor Flavor
+		err    error
+		want   bool
+	}{
+		{FlavorHarbor, registry_error(412, "PRECONDITION", "the artifact is immutable"), true},
+		{FlavorQuay, registry_error(409, "TAG_IMMUTABLE", "tag latest is immutable"), true},
+		{FlavorGCR, registry_error(403, "DENIED", "Cannot delete immutable tag 1.0"), true},
+		//Other refusals mentioning protection or a 412 are not immutability
+		{FlavorGCR, registry_error(403, "DENIED", "Permission denied"), false},
+		{FlavorDistribution, registry_error(412, "PRECONDITION", "immutable"), false},
+		{FlavorHarbor, registry_error(403, "DENIED", "protected by a robot account policy"), false},
+		{FlavorHarbor, StatusError{StatusCode: 412}, false},
+		{FlavorHarbor, errors.New("image is immutable"), false},
+	}
+	for _, test := range tests {
+		if got := is_immutable(test.flavor, test.err); got != test.want {
+			t.Errorf("is_immutable(%s, %v) = %v, want %v", test.flavor, test.err, got, test.want)
+		}
+	}
+}
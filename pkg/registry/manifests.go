@@ -0,0 +1,216 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Manifest is a manifest as stored in the registry, kept as raw bytes so
+// it can be pushed again without changing its digest
+type Manifest struct {
+	MediaType string
+	Digest    string
+	Body      []byte
+}
+
+func content_type(resp *http.Response) string {
+	return strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+}
+
+// ComputeDigest computes the digest of the manifest from its content, for
+// registries and proxies which don't send Docker-Content-Digest. Signed
+// schema1 manifests are digested without their signatures.
+func (m *Manifest) ComputeDigest() (string, error) {
+	if m.MediaType != MediaTypeSchema1Signed {
+		return Digest(m.Body), nil
+	}
+	payload, err := schema1_payload(m.Body)
+	if err != nil {
+		return "", err
+	}
+	return Digest(payload), nil
+}
+
+// GetManifest fetches the manifest of repo by tag or digest in the format
+// it was pushed in
+func (r *DockerRegistry) GetManifest(ctx context.Context, repo, reference string) (*Manifest, error) {
+	return r.get_manifest(ctx, repo, reference, false)
+}
+
+// get_manifest fetches a manifest, bypassing the request cache if fresh is
+// set
+func (r *DockerRegistry) get_manifest(ctx context.Context, repo, reference string, fresh bool) (*Manifest, error) {
+	req, err := r.new_request(ctx, "GET", fmt.Sprintf("%s%s/manifests/%s", r.URL, repo, reference), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", r.accept_header())
+	if fresh {
+		req.Header.Set("Cache-Control", "no-cache")
+	}
+
+	var m Manifest
+	err = r.do_buffered_request(req, func(r *http.Response) error {
+		m.MediaType = content_type(r)
+		m.Digest = r.Header.Get("Docker-Content-Digest")
+		m.Body, err = io.ReadAll(r.Body)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	//Some registries and proxies ignore the negotiation and answer with a
+	//generic content type, the manifest itself tells its format
+	if MediaTypeKind(m.MediaType) == m.MediaType {
+		m.MediaType = detect_media_type(m.Body)
+	}
+	if m.Digest == "" {
+		if m.Digest, err = m.ComputeDigest(); err != nil {
+			return nil, fmt.Errorf("Registry did not return a digest for %s:%s and it can't be computed: %v", repo, reference, err)
+		}
+		r.warn(WarningMissingDigest, repo+":"+reference, "the registry sent no digest, computed %s from the manifest", m.Digest)
+	}
+	return &m, nil
+}
+
+// PutManifest uploads a manifest to repo under reference (a tag or the
+// manifest digest) and returns the digest the registry stored it under
+func (r *DockerRegistry) PutManifest(ctx context.Context, repo, reference string, m *Manifest) (string, error) {
+	req, err := r.new_request(ctx, "PUT", fmt.Sprintf("%s%s/manifests/%s", r.URL, repo, reference), bytes.NewReader(m.Body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", m.MediaType)
+
+	var digest string
+	err = r.do_api_request(req, func(r *http.Response) error {
+		digest = r.Header.Get("Docker-Content-Digest")
+		return nil
+	})
+	if err == nil && digest == "" {
+		if digest, err = m.ComputeDigest(); err == nil {
+			r.warn(WarningMissingDigest, repo+":"+reference, "the registry sent no digest, computed %s from the manifest", digest)
+		}
+	}
+	return digest, err
+}
+
+// ManifestDigest resolves a tag to the digest of its manifest using a HEAD
+// request, without downloading the manifest unless the registry doesn't send
+// the digest
+func (r *DockerRegistry) ManifestDigest(ctx context.Context, repo, reference string) (string, error) {
+	return r.manifest_digest(ctx, repo, reference, false)
+}
+
+// manifest_digest resolves a tag, bypassing the request cache if fresh is
+// set, to notice tags moved during the run
+func (r *DockerRegistry) manifest_digest(ctx context.Context, repo, reference string, fresh bool) (string, error) {
+	req, err := r.new_request(ctx, "HEAD", fmt.Sprintf("%s%s/manifests/%s", r.URL, repo, reference), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", r.accept_header())
+	if fresh {
+		req.Header.Set("Cache-Control", "no-cache")
+	}
+
+	var digest string
+	err = r.do_api_request(req, func(r *http.Response) error {
+		digest = r.Header.Get("Docker-Content-Digest")
+		return nil
+	})
+	if err != nil || digest != "" {
+		return digest, err
+	}
+	//Without the header the manifest is downloaded to compute the digest
+	m, err := r.get_manifest(ctx, repo, reference, fresh)
+	if err != nil {
+		return "", err
+	}
+	return m.Digest, nil
+}
+
+// Layers returns the layers of an image manifest, starting with the base
+// layer. Schema1 manifests don't record layer sizes, so Size is 0 for them.
+// Indexes have no layers.
+func (m *Manifest) Layers() ([]Descriptor, error) {
+	var layers []Descriptor
+	switch MediaTypeKind(m.MediaType) {
+	case "schema1":
+		var s1 schema1Manifest
+		if err := json.Unmarshal(m.Body, &s1); err != nil {
+			return nil, err
+		}
+		for i := len(s1.FSLayers) - 1; i >= 0; i-- {
+			layers = append(layers, Descriptor{MediaType: MediaTypeLayer, Digest: s1.FSLayers[i].BlobSum})
+		}
+	case "schema2", "oci":
+		var m2 manifestV2
+		if err := json.Unmarshal(m.Body, &m2); err != nil {
+			return nil, err
+		}
+		layers = m2.Layers
+	}
+	return layers, nil
+}
+
+// Platform describes the platform of an image in a manifest list or index
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// IndexEntry is a manifest referenced by a manifest list or OCI index
+type IndexEntry struct {
+	Descriptor
+	Platform *Platform `json:"platform,omitempty"`
+	//Annotations tell attestations apart from images, see AttestationOf
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// AttestationOf returns the digest of the image an entry of an index
+// attests to, for the attestation manifests buildx stores next to the
+// images, or "" for images
+func (e IndexEntry) AttestationOf() string {
+	if e.Annotations["vnd.docker.reference.type"] != "attestation-manifest" {
+		return ""
+	}
+	return e.Annotations["vnd.docker.reference.digest"]
+}
+
+// Children returns the manifests referenced by a manifest list or index
+func (m *Manifest) Children() ([]IndexEntry, error) {
+	if MediaTypeKind(m.MediaType) != "index" {
+		return nil, nil
+	}
+	var index struct {
+		Manifests []IndexEntry `json:"manifests"`
+	}
+	if err := json.Unmarshal(m.Body, &index); err != nil {
+		return nil, err
+	}
+	return index.Manifests, nil
+}
+
+// Blobs returns the config and layer blobs an image manifest refers to
+func (m *Manifest) Blobs() ([]Descriptor, error) {
+	layers, err := m.Layers()
+	if err != nil {
+		return nil, err
+	}
+	switch MediaTypeKind(m.MediaType) {
+	case "schema2", "oci":
+		var m2 manifestV2
+		if err := json.Unmarshal(m.Body, &m2); err != nil {
+			return nil, err
+		}
+		return append([]Descriptor{m2.Config}, layers...), nil
+	}
+	return layers, nil
+}
@@ -0,0 +1,100 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	MediaTypeSchema1       = "application/vnd.docker.distribution.manifest.v1+json"
+	MediaTypeSchema1Signed = "application/vnd.docker.distribution.manifest.v1+prettyjws"
+	MediaTypeSchema2       = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeManifestList  = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest   = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex      = "application/vnd.oci.image.index.v1+json"
+)
+
+// manifestAccept lists every manifest format we understand, so the registry
+// returns a manifest in the format it was pushed in instead of converting it
+var manifestAccept = strings.Join([]string{
+	MediaTypeSchema2,
+	MediaTypeManifestList,
+	MediaTypeOCIManifest,
+	MediaTypeOCIIndex,
+	MediaTypeSchema1Signed,
+	MediaTypeSchema1,
+}, ", ")
+
+// MediaTypeKind maps a manifest media type to one of the short names
+// "schema1", "schema2", "oci" or "index". Docker manifest lists and OCI
+// indexes are both reported as "index". Unknown types are returned as is.
+func MediaTypeKind(mediatype string) string {
+	switch mediatype {
+	case MediaTypeSchema1, MediaTypeSchema1Signed:
+		return "schema1"
+	case MediaTypeSchema2:
+		return "schema2"
+	case MediaTypeOCIManifest:
+		return "oci"
+	case MediaTypeManifestList, MediaTypeOCIIndex:
+		return "index"
+	}
+	return mediatype
+}
+
+// acceptKinds maps the short names accepted by SetAccept to media types
+var acceptKinds = map[string][]string{
+	"schema2": {MediaTypeSchema2},
+	"oci":     {MediaTypeOCIManifest},
+	"index":   {MediaTypeManifestList, MediaTypeOCIIndex},
+	"schema1": {MediaTypeSchema1Signed, MediaTypeSchema1},
+}
+
+// SetAccept limits the manifest formats requested from the registry to the
+// given kinds ("schema2", "oci", "index" and "schema1"), in order of
+// preference. Without kinds every format is accepted.
+func (r *DockerRegistry) SetAccept(kinds []string) error {
+	var types []string
+	for _, kind := range kinds {
+		mediatypes, ok := acceptKinds[kind]
+		if !ok {
+			return fmt.Errorf("Unknown manifest format '%s', expected schema2, oci, index or schema1", kind)
+		}
+		types = append(types, mediatypes...)
+	}
+	r.accept = strings.Join(types, ", ")
+	return nil
+}
+
+func (r *DockerRegistry) accept_header() string {
+	if r.accept == "" {
+		return manifestAccept
+	}
+	return r.accept
+}
+
+// detect_media_type determines the format of a manifest from its content,
+// for registries and proxies that don't send a meaningful Content-Type
+func detect_media_type(body []byte) string {
+	var m struct {
+		SchemaVersion int             `json:"schemaVersion"`
+		MediaType     string          `json:"mediaType"`
+		Signatures    json.RawMessage `json:"signatures"`
+		Manifests     json.RawMessage `json:"manifests"`
+	}
+	if err := json.Unmarshal(body, &m); err != nil {
+		return ""
+	}
+	switch {
+	case m.SchemaVersion == 1 && m.Signatures != nil:
+		return MediaTypeSchema1Signed
+	case m.SchemaVersion == 1:
+		return MediaTypeSchema1
+	case m.MediaType != "":
+		return m.MediaType
+	case m.Manifests != nil:
+		return MediaTypeOCIIndex
+	}
+	return MediaTypeOCIManifest
+}
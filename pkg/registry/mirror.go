@@ -0,0 +1,108 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// IsPullThroughCache reports whether repo is served by a pull-through cache
+// (registry mirror), where deleting only drops cached copies that come back
+// on the next pull, or hides images the upstream still has. It only reads:
+// Harbor proxy cache projects, Artifactory remote repositories, Nexus proxy
+// repositories and Quay mirrored repositories or proxy cache organizations
+// are told by their APIs, GitLab dependency proxies by their path. Other
+// registries don't tell, they are reported as not being mirrors. The
+// distribution registry in proxy mode refuses deletes anyway.
+func (r *DockerRegistry) IsPullThroughCache(ctx context.Context, repo string) (bool, error) {
+	switch r.Flavor() {
+	case FlavorHarbor:
+		return r.harbor_is_proxy_cache(ctx, repo)
+	case FlavorArtifactory:
+		return r.artifactory_is_remote(ctx, repo)
+	case FlavorNexus:
+		return r.nexus_is_proxy(ctx, repo)
+	case FlavorQuay:
+		return r.quay_is_mirror(ctx, repo)
+	case FlavorGitLab:
+		return strings.Contains(repo, "/dependency_proxy/containers/"), nil
+	}
+	return false, nil
+}
+
+// get_json decodes the answer to a GET request of an API of the registry
+// into v
+func (r *DockerRegistry) get_json(ctx context.Context, apiurl string, v interface{}) error {
+	req, err := r.new_request(ctx, "GET", apiurl, nil)
+	if err != nil {
+		return err
+	}
+	return r.do_api_request(req, func(r *http.Response) error {
+		return json.NewDecoder(r.Body).Decode(v)
+	})
+}
+
+func (r *DockerRegistry) harbor_is_proxy_cache(ctx context.Context, repo string) (bool, error) {
+	project := strings.SplitN(repo, "/", 2)[0]
+	var p struct {
+		RegistryID *int64 `json:"registry_id"`
+	}
+	if err := r.get_json(ctx, fmt.Sprintf("%sapi/v2.0/projects/%s", r.base_url(), url.PathEscape(project)), &p); err != nil {
+		return false, err
+	}
+	//Only proxy cache projects are linked to an upstream registry
+	return p.RegistryID != nil && *p.RegistryID != 0, nil
+}
+
+// artifactory_is_remote reads the class of the Artifactory repository, the
+// first component of repo with the repository path access method
+func (r *DockerRegistry) artifactory_is_remote(ctx context.Context, repo string) (bool, error) {
+	key := strings.SplitN(repo, "/", 2)[0]
+	var config struct {
+		Class string `json:"rclass"`
+	}
+	if err := r.get_json(ctx, fmt.Sprintf("%sartifactory/api/repositories/%s", r.base_url(), url.PathEscape(key)), &config); err != nil {
+		return false, err
+	}
+	return config.Class == "remote", nil
+}
+
+// nexus_is_proxy reads the type of the Nexus repository, the first component
+// of repo with path based routing
+func (r *DockerRegistry) nexus_is_proxy(ctx context.Context, repo string) (bool, error) {
+	name := strings.SplitN(repo, "/", 2)[0]
+	var config struct {
+		Type string `json:"type"`
+	}
+	if err := r.get_json(ctx, fmt.Sprintf("%sservice/rest/v1/repositories/%s", r.base_url(), url.PathEscape(name)), &config); err != nil {
+		return false, err
+	}
+	return config.Type == "proxy", nil
+}
+
+// quay_is_mirror reads the state of the Quay repository, mirrored
+// repositories are read-only, and the proxy cache configuration of its
+// organization. Namespaces which aren't organizations have none.
+func (r *DockerRegistry) quay_is_mirror(ctx context.Context, repo string) (bool, error) {
+	var state struct {
+		State string `json:"state"`
+	}
+	if err := r.get_json(ctx, fmt.Sprintf("%sapi/v1/repository/%s", r.base_url(), repo), &state); err != nil {
+		return false, err
+	}
+	if state.State == "MIRROR" {
+		return true, nil
+	}
+	org := strings.SplitN(repo, "/", 2)[0]
+	var proxy struct {
+		Upstream string `json:"upstream_registry"`
+	}
+	err := r.get_json(ctx, fmt.Sprintf("%sapi/v1/organization/%s/proxycache", r.base_url(), url.PathEscape(org)), &proxy)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	return proxy.Upstream != "", err
+}
@@ -0,0 +1,80 @@
+package registry
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+)
+
+// json_answer answers with body as JSON
+func json_answer(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body)
+	}
+}
+
+func TestPullThroughCacheOnlyReads(t *testing.T) {
+	tests := []struct {
+		flavor Flavor
+		repo   string
+		path   string
+		answer string
+		want   bool
+	}{
+		{FlavorHarbor, "dockerhub/library/alpine", "/api/v2.0/projects/dockerhub", `{"registry_id": 3}`, true},
+		{FlavorHarbor, "team-a/webserver", "/api/v2.0/projects/team-a", `{"registry_id": null}`, false},
+		{FlavorArtifactory, "docker-remote/alpine", "/artifactory/api/repositories/docker-remote", `{"rclass": "remote"}`, true},
+		{FlavorArtifactory, "docker-local/webserver", "/artifactory/api/repositories/docker-local", `{"rclass": "local"}`, false},
+		{FlavorNexus, "docker-proxy/alpine", "/service/rest/v1/repositories/docker-proxy", `{"type": "proxy"}`, true},
+		{FlavorNexus, "docker-hosted/webserver", "/service/rest/v1/repositories/docker-hosted", `{"type": "hosted"}`, false},
+		{FlavorQuay, "mirrors/alpine", "/api/v1/repository/mirrors/alpine", `{"state": "MIRROR"}`, true},
+		{FlavorQuay, "cache/alpine", "/api/v1/organization/cache/proxycache", `{"upstream_registry": "docker.io"}`, true},
+		{FlavorQuay, "team-a/webserver", "/api/v1/repository/team-a/webserver", `{"state": "NORMAL"}`, false},
+		{FlavorGitLab, "group/dependency_proxy/containers/alpine", "", "", true},
+		{FlavorDistribution, "library/alpine", "", "", false},
+	}
+	for _, test := range tests {
+		f := new_fake_registry(t)
+		if test.path != "" {
+			f.fail("GET", test.path, -1, json_answer(test.answer))
+		}
+		if test.flavor == FlavorQuay && test.repo == "cache/alpine" {
+			f.fail("GET", "/api/v1/repository/cache/alpine", -1, json_answer(`{"state": "NORMAL"}`))
+		}
+		r := f.connect(t)
+		r.SetFlavor(test.flavor)
+		got, err := r.IsPullThroughCache(context.Background(), test.repo)
+		if err != nil {
+			t.Errorf("%s %s: %v", test.flavor, test.repo, err)
+		} else if got != test.want {
+			t.Errorf("%s %s: IsPullThroughCache = %v, want %v", test.flavor, test.repo, got, test.want)
+		}
+		for _, method := range []string{"POST", "PUT", "PATCH", "DELETE"} {
+			if n := f.count(method, ""); n > 0 {
+				t.Errorf("%s %s: sent %d %s requests", test.flavor, test.repo, n, method)
+			}
+		}
+	}
+}
+
+func TestPullThroughCacheProbeError(t *testing.T) {
+	//The APIs of Artifactory and Nexus are often not served on the host of
+	//the Docker connector, or require admin rights
+	for _, flavor := range []Flavor{FlavorArtifactory, FlavorNexus, FlavorHarbor} {
+		f := new_fake_registry(t)
+		r := f.connect(t)
+		r.SetFlavor(flavor)
+		if mirror, err := r.IsPullThroughCache(context.Background(), "docker-remote/alpine"); err == nil {
+			t.Errorf("%s: got %v without error, want the error of the API", flavor, mirror)
+		}
+	}
+	f := new_fake_registry(t)
+	f.fail("GET", "/artifactory/api/repositories/docker-remote", -1, status_fault(http.StatusForbidden, ""))
+	r := f.connect(t)
+	r.SetFlavor(FlavorArtifactory)
+	if _, err := r.IsPullThroughCache(context.Background(), "docker-remote/alpine"); StatusCode(err) != http.StatusForbidden {
+		t.Errorf("Got %v, want the 403 of the API", err)
+	}
+}
@@ -0,0 +1,95 @@
+package registry
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Option configures a DockerRegistry when connecting, see NewDockerRegistry
+type Option func(*options)
+
+type options struct {
+	verify    bool
+	timeout   time.Duration
+	transport http.RoundTripper
+	username  string
+	password  string
+	userAgent string
+	logger    *log.Logger
+	rate      float64
+	retries   int
+}
+
+// WithTLSVerify enables or disables the verification of the TLS
+// certificate of the registry. It is verified by default.
+func WithTLSVerify(verify bool) Option {
+	return func(o *options) { o.verify = verify }
+}
+
+// WithTimeout limits how long the registry may take to answer a request, and
+// to send more of the response while it is read, so long transfers don't
+// time out while they make progress. The default is 30 seconds, 0 means no
+// limit.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) { o.timeout = timeout }
+}
+
+// WithTransport sends the requests through transport instead of
+// http.DefaultTransport, for proxies or custom TLS settings. WithTLSVerify
+// has no effect then.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(o *options) { o.transport = transport }
+}
+
+// WithCredentials authenticates as username. The credentials are sent as
+// basic auth with every request to the registry, starting with the initial
+// /v2/ ping, and to the token server of registries using token
+// authentication.
+func WithCredentials(username, password string) Option {
+	return func(o *options) {
+		o.username = username
+		o.password = password
+	}
+}
+
+// WithUserAgent sends userAgent in the User-Agent header of every request,
+// including those to the token server
+func WithUserAgent(userAgent string) Option {
+	return func(o *options) { o.userAgent = userAgent }
+}
+
+// WithLogger writes warnings to logger instead of the standard logger, when
+// no OnWarning callback is registered
+func WithLogger(logger *log.Logger) Option {
+	return func(o *options) { o.logger = logger }
+}
+
+// WithRateLimit sends at most perSecond requests per second to the
+// registry, shared by every goroutine using the client. 0 means no limit.
+func WithRateLimit(perSecond float64) Option {
+	return func(o *options) { o.rate = perSecond }
+}
+
+// new_request_limiter spaces requests evenly at perSecond, without bursts.
+// 0 means no limit.
+func new_request_limiter(perSecond float64) *rate.Limiter {
+	return rate.NewLimiter(request_limit(perSecond), 1)
+}
+
+// request_limit converts a rate given to WithRateLimit or SetRequestRate
+func request_limit(perSecond float64) rate.Limit {
+	if perSecond <= 0 {
+		return rate.Inf
+	}
+	return rate.Limit(perSecond)
+}
+
+// SetRequestRate changes the limit set with WithRateLimit, also while
+// requests are being made, eg to slow a scan down to stay within a quota.
+// 0 means no limit.
+func (r *DockerRegistry) SetRequestRate(perSecond float64) {
+	r.limiter.SetLimit(request_limit(perSecond))
+}
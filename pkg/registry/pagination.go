@@ -0,0 +1,110 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+)
+
+// SetPageSize caps the number of entries requested per page of the catalog
+// and tag lists, and sets the page size of ListManifests. 0 leaves the page
+// size to the registry, and to 100 for ListManifests.
+func (r *DockerRegistry) SetPageSize(n int) {
+	r.pageSize = n
+}
+
+// next_link returns the URL of the next page from the Link header of a
+// paginated response, eg </v2/_catalog?last=b&n=100>; rel="next"
+func next_link(resp *http.Response) string {
+	for _, header := range resp.Header.Values("Link") {
+		for _, link := range strings.Split(header, ",") {
+			parts := strings.Split(link, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, param := range parts[1:] {
+				param = strings.ReplaceAll(strings.TrimSpace(param), " ", "")
+				if strings.EqualFold(param, `rel="next"`) || strings.EqualFold(param, "rel=next") {
+					return strings.Trim(target, "<>")
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// list_pages reads every page of a listing starting at url. decode returns
+// the entries of one page.
+func (r *DockerRegistry) list_pages(ctx context.Context, url, subject string, decode func(*http.Response) ([]string, error)) ([]string, error) {
+	var entries []string
+	err := r.each_page(ctx, url, subject, decode, func(page []string) error {
+		entries = append(entries, page...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// each_page passes every page of a listing starting at url to fn as soon as
+// it is read, the next page is only requested once fn returns. Pages are
+// followed through the Link header, or with the last parameter when a
+// registry returns full pages of the requested size without one.
+func (r *DockerRegistry) each_page(ctx context.Context, url, subject string, decode func(*http.Response) ([]string, error), fn func([]string) error) error {
+	next, err := neturl.Parse(url)
+	if err != nil {
+		return err
+	}
+	if r.pageSize > 0 {
+		q := next.Query()
+		q.Set("n", strconv.Itoa(r.pageSize))
+		next.RawQuery = q.Encode()
+	}
+
+	read := 0
+	seen := make(map[string]bool)
+	for next != nil {
+		if seen[next.String()] {
+			r.warn(WarningTruncated, subject, "the registry returned the same page twice, only the first %d entries were read", read)
+			break
+		}
+		seen[next.String()] = true
+		req, err := r.new_request(ctx, "GET", next.String(), nil)
+		if err != nil {
+			return err
+		}
+		var page []string
+		var link string
+		err = r.do_buffered_request(req, func(resp *http.Response) error {
+			link = next_link(resp)
+			var err error
+			page, err = decode(resp)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		read += len(page)
+		if err := fn(page); err != nil {
+			return err
+		}
+
+		current := next
+		next = nil
+		if link != "" {
+			if next, err = current.Parse(link); err != nil {
+				return err
+			}
+		} else if r.pageSize > 0 && len(page) == r.pageSize {
+			next = current.ResolveReference(&neturl.URL{})
+			q := next.Query()
+			q.Set("last", page[len(page)-1])
+			next.RawQuery = q.Encode()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// probeDigest doesn't match any manifest, so deleting it can't do harm
+var probeDigest = "sha256:" + strings.Repeat("0", 64)
+
+// is_probe reports whether req is the delete of probeDigest sent by
+// CheckDeletePermission
+func is_probe(req *http.Request) bool {
+	return req.Method == "DELETE" && strings.HasSuffix(req.URL.Path, "/manifests/"+probeDigest)
+}
+
+// CheckDeletePermission finds out whether manifests in repo can be deleted,
+// without deleting anything. It asks the registry to delete a manifest that
+// doesn't exist: the registry checks authorization first, so "not found"
+// means the delete would have been allowed.
+func (r *DockerRegistry) CheckDeletePermission(ctx context.Context, repo string) error {
+	if r.Flavor() == FlavorECR {
+		return fmt.Errorf("ECR doesn't support deleting through the Registry API")
+	}
+	req, err := r.new_request(ctx, "DELETE", fmt.Sprintf("%s%s/manifests/%s", r.URL, repo, probeDigest), nil)
+	if err != nil {
+		return err
+	}
+	err = r.do_api_request(req, func(r *http.Response) error {
+		return nil
+	})
+	switch StatusCode(err) {
+	case http.StatusNotFound:
+		return nil
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("Permission denied (HTTP %d)", StatusCode(err))
+	case http.StatusMethodNotAllowed:
+		return fmt.Errorf("Deletes are disabled on this registry")
+	}
+	if err == nil {
+		//Nothing to delete, but the registry accepted the request
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,39 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestDeleteProbeKeepsCache(t *testing.T) {
+	tests := []struct {
+		status int
+		denied bool
+	}{
+		{http.StatusNotFound, false},
+		{http.StatusForbidden, true},
+		{http.StatusMethodNotAllowed, true},
+	}
+	for _, test := range tests {
+		f := new_fake_registry(t)
+		f.push("webserver", "1.0")
+		f.fail("DELETE", "/manifests/"+probeDigest, -1, status_fault(test.status, ""))
+		r := f.connect(t, WithRetries(0))
+		r.EnableCache()
+		ctx := context.Background()
+		if _, err := r.Tags(ctx, "webserver"); err != nil {
+			t.Fatalf("Tags: %v", err)
+		}
+		err := r.CheckDeletePermission(ctx, "webserver")
+		if denied := err != nil; denied != test.denied {
+			t.Errorf("HTTP %d: CheckDeletePermission = %v, want denied %v", test.status, err, test.denied)
+		}
+		if _, err := r.Tags(ctx, "webserver"); err != nil {
+			t.Fatalf("Tags: %v", err)
+		}
+		if n := f.count("GET", "/tags/list"); n != 1 {
+			t.Errorf("HTTP %d: tags listed %d times after the probe, want 1 from the cache", test.status, n)
+		}
+	}
+}
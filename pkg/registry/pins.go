@@ -0,0 +1,156 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+const (
+	//PinsTag is the well-known tag of the artifact recording the pinned
+	//digests of a repository
+	PinsTag = "regclient-pins"
+	//pinAnnotation prefixes the annotation recorded for every pinned digest
+	pinAnnotation = "io.regclient.pin."
+	//MediaTypeOCIConfig is the config media type of OCI image manifests
+	MediaTypeOCIConfig = "application/vnd.oci.image.config.v1+json"
+)
+
+// errPinsChanged means the pins artifact was replaced while it was updated
+var errPinsChanged = errors.New("The pins were changed concurrently")
+
+// pinsAttempts bounds how often an update of the pins is applied again
+// after losing a race with another update
+const pinsAttempts = 5
+
+// Pins returns the digests pinned in repo, with the note recorded when
+// pinning them. The digest of the pins artifact itself is returned as well,
+// it is empty if nothing was ever pinned.
+func (r *DockerRegistry) Pins(ctx context.Context, repo string) (map[string]string, string, error) {
+	return r.pins(ctx, repo, false)
+}
+
+// pins reads the pins of repo, bypassing the request cache if fresh is set
+func (r *DockerRegistry) pins(ctx context.Context, repo string, fresh bool) (map[string]string, string, error) {
+	pins := make(map[string]string)
+	m, err := r.get_manifest(ctx, repo, PinsTag, fresh)
+	if IsNotFound(err) {
+		return pins, "", nil
+	} else if err != nil {
+		return nil, "", err
+	}
+	annotations, err := m.Annotations()
+	if err != nil {
+		return nil, "", err
+	}
+	for key, value := range annotations {
+		if strings.HasPrefix(key, pinAnnotation) {
+			pins[strings.TrimPrefix(key, pinAnnotation)] = value
+		}
+	}
+	return pins, m.Digest, nil
+}
+
+// SetPins replaces the pinned digests of repo. They are stored as the
+// annotations of an empty OCI image pushed under PinsTag, the previous pins
+// artifact is deleted.
+func (r *DockerRegistry) SetPins(ctx context.Context, repo string, pins map[string]string) error {
+	return r.UpdatePins(ctx, repo, func(current map[string]string) error {
+		for digest := range current {
+			delete(current, digest)
+		}
+		for digest, note := range pins {
+			current[digest] = note
+		}
+		return nil
+	})
+}
+
+// UpdatePins changes the pinned digests of repo with update, which is given
+// the current pins to modify. Registries can't replace a tag conditionally,
+// so the pins artifact is compared before and after pushing: if another
+// update replaced it in the meantime, update is applied again to the pins
+// that update wrote.
+func (r *DockerRegistry) UpdatePins(ctx context.Context, repo string, update func(pins map[string]string) error) error {
+	for attempt := 1; ; attempt++ {
+		pins, olddigest, err := r.pins(ctx, repo, true)
+		if err != nil {
+			return err
+		}
+		if err := update(pins); err != nil {
+			return err
+		}
+		err = r.push_pins(ctx, repo, pins, olddigest)
+		if err != errPinsChanged || attempt == pinsAttempts {
+			return err
+		}
+	}
+}
+
+// push_pins pushes pins under PinsTag unless the tag no longer points at
+// olddigest, and deletes the olddigest artifact. errPinsChanged is returned
+// if the tag was replaced before or right after pushing.
+func (r *DockerRegistry) push_pins(ctx context.Context, repo string, pins map[string]string, olddigest string) error {
+	config := []byte("{}")
+	configdigest := Digest(config)
+	if exists, err := r.BlobExists(ctx, repo, configdigest); err != nil {
+		return err
+	} else if !exists {
+		if err := r.PushBlob(ctx, repo, configdigest, bytes.NewReader(config), int64(len(config))); err != nil {
+			return err
+		}
+	}
+
+	m := manifestV2{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIManifest,
+		Config:        Descriptor{MediaType: MediaTypeOCIConfig, Size: int64(len(config)), Digest: configdigest},
+		Layers:        []Descriptor{},
+		Annotations:   make(map[string]string),
+	}
+	for digest, note := range pins {
+		m.Annotations[pinAnnotation+digest] = note
+	}
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	digest := Digest(body)
+	if digest == olddigest {
+		return nil
+	}
+
+	if current, err := r.pins_digest(ctx, repo); err != nil {
+		return err
+	} else if current != olddigest {
+		return errPinsChanged
+	}
+	if _, err := r.PutManifest(ctx, repo, PinsTag, &Manifest{MediaType: MediaTypeOCIManifest, Digest: digest, Body: body}); err != nil {
+		return err
+	}
+	//Another update pushing between the comparison and ours was overwritten,
+	//the one overwriting ours applies its update again
+	if current, err := r.pins_digest(ctx, repo); err != nil {
+		return err
+	} else if current != digest {
+		return errPinsChanged
+	}
+
+	if olddigest != "" {
+		if err := r.DeleteImage(ctx, &DockerImage{Name: repo, ContentDigest: olddigest}); err != nil {
+			r.warn(WarningCleanup, repo, "unable to delete the previous pins %s: %v", olddigest, err)
+		}
+	}
+	return nil
+}
+
+// pins_digest returns the digest PinsTag points at, "" if there is none
+func (r *DockerRegistry) pins_digest(ctx context.Context, repo string) (string, error) {
+	digest, err := r.manifest_digest(ctx, repo, PinsTag, true)
+	if IsNotFound(err) {
+		return "", nil
+	}
+	return digest, err
+}
@@ -0,0 +1,65 @@
+package registry
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPinsListedAsNoTag(t *testing.T) {
+	f := new_fake_registry(t)
+	f.push_blob(MediaTypeOCIConfig, []byte("{}"))
+	f.push("webserver", "1.0")
+	r := f.connect(t)
+	if err := r.SetPins(context.Background(), "webserver", map[string]string{"sha256:aaa": "pinned"}); err != nil {
+		t.Fatalf("SetPins: %v", err)
+	}
+	tags, err := r.Tags(context.Background(), "webserver")
+	if err != nil {
+		t.Fatalf("Tags: %v", err)
+	}
+	if len(tags) != 1 || tags[0] != "1.0" {
+		t.Errorf("Tags = %v, want [1.0]", tags)
+	}
+}
+
+func TestUpdatePinsConcurrent(t *testing.T) {
+	f := new_fake_registry(t)
+	f.push_blob(MediaTypeOCIConfig, []byte("{}"))
+	ctx := context.Background()
+	r, other := f.connect(t), f.connect(t)
+	if err := r.SetPins(ctx, "webserver", map[string]string{"sha256:aaa": "first"}); err != nil {
+		t.Fatalf("SetPins: %v", err)
+	}
+
+	//The other client pins a digest between the read and the push of the
+	//first attempt, which must be applied again on top of it
+	attempts := 0
+	err := r.UpdatePins(ctx, "webserver", func(pins map[string]string) error {
+		attempts++
+		if attempts == 1 {
+			if err := other.UpdatePins(ctx, "webserver", func(pins map[string]string) error {
+				pins["sha256:bbb"] = "other"
+				return nil
+			}); err != nil {
+				t.Fatalf("UpdatePins of the other client: %v", err)
+			}
+		}
+		pins["sha256:ccc"] = "mine"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdatePins: %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("update applied %d times, want 2", attempts)
+	}
+	pins, _, err := r.pins(ctx, "webserver", true)
+	if err != nil {
+		t.Fatalf("Pins: %v", err)
+	}
+	for _, digest := range []string{"sha256:aaa", "sha256:bbb", "sha256:ccc"} {
+		if _, ok := pins[digest]; !ok {
+			t.Errorf("%s lost, pins are %v", digest, pins)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+)
+
+// ErrProtected is returned when deleting a manifest would remove a
+// protected tag
+var ErrProtected = errors.New("Refusing to delete a protected tag")
+
+// SetProtectedTags protects the tags matching one of the glob patterns, eg
+// latest or release-*, from deletion. DeleteTag refuses to delete them and
+// DeleteImage refuses to delete a manifest any of them points at, whatever
+// the tag of the image, as deleting the manifest removes every tag pointing
+// at it.
+func (r *DockerRegistry) SetProtectedTags(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Invalid pattern '%s'", pattern)
+		}
+	}
+	r.protected = patterns
+	return nil
+}
+
+// Protected reports whether tag matches one of the patterns set with
+// SetProtectedTags
+func (r *DockerRegistry) Protected(tag string) bool {
+	for _, pattern := range r.protected {
+		if ok, _ := path.Match(pattern, tag); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// check_protected is the last check before deleting img. The protected tags
+// of the repository are resolved again, bypassing the cache, and if one
+// can't be resolved the deletion is refused, as it can't be shown safe.
+func (r *DockerRegistry) check_protected(ctx context.Context, img *DockerImage) error {
+	if len(r.protected) == 0 {
+		return nil
+	}
+	if img.Tag != "" && r.Protected(img.Tag) {
+		return fmt.Errorf("%w (%s:%s)", ErrProtected, img.Name, img.Tag)
+	}
+	if img.ContentDigest == "" {
+		return nil
+	}
+	tags, err := r.Tags(ctx, img.Name)
+	if err != nil {
+		return fmt.Errorf("Unable to check the protected tags of %s: %v", img.Name, err)
+	}
+	for _, tag := range tags {
+		if !r.Protected(tag) {
+			continue
+		}
+		digest, err := r.manifest_digest(ctx, img.Name, tag, true)
+		if IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("Unable to check the protected tag %s:%s: %v", img.Name, tag, err)
+		}
+		if digest == img.ContentDigest {
+			return fmt.Errorf("%w (%s:%s points at %s too)", ErrProtected, img.Name, tag, img.ContentDigest)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrPullTimeUnsupported is returned by LastPulled for registries that don't
+// record when images are pulled, or don't expose it
+var ErrPullTimeUnsupported = errors.New("The registry doesn't tell when images were last pulled")
+
+// LastPulled returns when img was last pulled, the zero time if it never
+// was. The Registry API doesn't record pulls, so this needs the artifact API
+// of Harbor or the storage API of Artifactory, which counts the pulls of the
+// tag rather than of the digest. ECR only exposes it through the AWS API.
+func (r *DockerRegistry) LastPulled(ctx context.Context, img *DockerImage) (time.Time, error) {
+	if !r.InScope(img.Name) {
+		return time.Time{}, fmt.Errorf("Refusing to access %s, it is outside of the scope %s", img.Name, strings.TrimSuffix(r.scope, "/"))
+	}
+	switch r.Flavor() {
+	case FlavorHarbor:
+		return r.harbor_pull_time(ctx, img)
+	case FlavorArtifactory:
+		return r.artifactory_pull_time(ctx, img)
+	}
+	return time.Time{}, ErrPullTimeUnsupported
+}
+
+func (r *DockerRegistry) harbor_pull_time(ctx context.Context, img *DockerImage) (time.Time, error) {
+	repourl, err := r.harbor_repository_url(img.Name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	req, err := r.new_request(ctx, "GET", fmt.Sprintf("%s/artifacts/%s", repourl, img.ContentDigest), nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var artifact struct {
+		PullTime time.Time `json:"pull_time"`
+	}
+	err = r.do_api_request(req, func(r *http.Response) error {
+		return json.NewDecoder(r.Body).Decode(&artifact)
+	})
+	//Harbor reports 0001-01-01T00:00:00Z for artifacts never pulled
+	return artifact.PullTime, err
+}
+
+// artifactory_pull_time reads the download statistics of the manifest of
+// the tag. With the repository path access method, the first component of
+// the repository is the Artifactory repository key.
+func (r *DockerRegistry) artifactory_pull_time(ctx context.Context, img *DockerImage) (time.Time, error) {
+	if img.Tag == "" {
+		return time.Time{}, fmt.Errorf("Artifactory only records pulls per tag")
+	}
+	key, image, ok := strings.Cut(img.Name, "/")
+	if !ok {
+		return time.Time{}, fmt.Errorf("Artifactory repository %s is not of the form repository-key/image", img.Name)
+	}
+	req, err := r.new_request(ctx, "GET", fmt.Sprintf("%sartifactory/api/storage/%s/%s/%s/manifest.json?stats", r.base_url(), url.PathEscape(key), image, url.PathEscape(img.Tag)), nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var stats struct {
+		LastDownloaded int64 `json:"lastDownloaded"`
+	}
+	err = r.do_api_request(req, func(r *http.Response) error {
+		return json.NewDecoder(r.Body).Decode(&stats)
+	})
+	if err != nil || stats.LastDownloaded == 0 {
+		return time.Time{}, err
+	}
+	return time.UnixMilli(stats.LastDownloaded), nil
+}
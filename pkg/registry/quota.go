@@ -0,0 +1,64 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ErrQuotaUnsupported is returned by Quota for registries without a storage
+// quota API
+var ErrQuotaUnsupported = errors.New("Storage quotas are not available for this registry")
+
+// Quota is the storage quota of a namespace (a Harbor project)
+type Quota struct {
+	Namespace string
+	Used      int64
+	//Limit is -1 if the namespace has no limit
+	Limit int64
+}
+
+// Utilization returns the used share of the limit in percent, or -1 if the
+// namespace has no limit
+func (q *Quota) Utilization() float64 {
+	if q.Limit <= 0 {
+		return -1
+	}
+	return float64(q.Used) / float64(q.Limit) * 100
+}
+
+// Quota returns the storage quota applying to repo. Only Harbor exposes
+// quotas through its API, ECR quotas are managed by the AWS Service Quotas
+// API.
+func (r *DockerRegistry) Quota(ctx context.Context, repo string) (*Quota, error) {
+	if r.Flavor() != FlavorHarbor {
+		return nil, ErrQuotaUnsupported
+	}
+	project := strings.SplitN(repo, "/", 2)[0]
+	req, err := r.new_request(ctx, "GET", fmt.Sprintf("%sapi/v2.0/projects/%s/summary", r.base_url(), url.PathEscape(project)), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Is-Resource-Name", "true")
+	var summary struct {
+		Quota struct {
+			Hard struct {
+				Storage int64 `json:"storage"`
+			} `json:"hard"`
+			Used struct {
+				Storage int64 `json:"storage"`
+			} `json:"used"`
+		} `json:"quota"`
+	}
+	err = r.do_api_request(req, func(r *http.Response) error {
+		return json.NewDecoder(r.Body).Decode(&summary)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Quota{project, summary.Quota.Used.Storage, summary.Quota.Hard.Storage}, nil
+}
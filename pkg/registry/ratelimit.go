@@ -0,0 +1,67 @@
+package registry
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit is the request quota advertised by the registry in the
+// RateLimit-Limit and RateLimit-Remaining headers (eg Docker Hub)
+type RateLimit struct {
+	Limit     int
+	Remaining int
+	//Window is the period after which the quota is replenished
+	Window time.Duration
+}
+
+type rateLimitState struct {
+	mu    sync.Mutex
+	limit *RateLimit
+}
+
+// parse_ratelimit parses header values of the form "100;w=21600"
+func parse_ratelimit(value string) (int, time.Duration, bool) {
+	parts := strings.Split(value, ";")
+	n, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, false
+	}
+	var window time.Duration
+	for _, param := range parts[1:] {
+		if w := strings.TrimPrefix(strings.TrimSpace(param), "w="); w != param {
+			if secs, err := strconv.Atoi(w); err == nil {
+				window = time.Duration(secs) * time.Second
+			}
+		}
+	}
+	return n, window, true
+}
+
+// record_ratelimit remembers the quota advertised by a response, if any
+func (r *DockerRegistry) record_ratelimit(resp *http.Response) {
+	limit, window, ok := parse_ratelimit(resp.Header.Get("RateLimit-Limit"))
+	if !ok {
+		return
+	}
+	remaining, _, ok := parse_ratelimit(resp.Header.Get("RateLimit-Remaining"))
+	if !ok {
+		remaining = limit
+	}
+	r.ratelimit.mu.Lock()
+	r.ratelimit.limit = &RateLimit{Limit: limit, Remaining: remaining, Window: window}
+	r.ratelimit.mu.Unlock()
+}
+
+// RateLimit returns the quota the registry advertised in its latest response
+// carrying rate limit headers. It returns false if none did so far.
+func (r *DockerRegistry) RateLimit() (RateLimit, bool) {
+	r.ratelimit.mu.Lock()
+	defer r.ratelimit.mu.Unlock()
+	if r.ratelimit.limit == nil {
+		return RateLimit{}, false
+	}
+	return *r.ratelimit.limit, true
+}
@@ -0,0 +1,57 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Referrer is a manifest attached to another one through its subject, like
+// a signature, an SBOM or an attestation
+type Referrer struct {
+	Descriptor
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+// Referrers lists the manifests of repo whose subject is the manifest
+// digest. Registries without the referrers API of OCI distribution 1.1 are
+// asked for the fallback tag (sha256-<hex>) clients push instead. Manifests
+// without referrers return nil.
+func (r *DockerRegistry) Referrers(ctx context.Context, repo, digest string) ([]Referrer, error) {
+	req, err := r.new_request(ctx, "GET", fmt.Sprintf("%s%s/referrers/%s", r.URL, repo, digest), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", MediaTypeOCIIndex)
+	var index struct {
+		Manifests []Referrer `json:"manifests"`
+	}
+	err = r.do_api_request(req, func(resp *http.Response) error {
+		return json.NewDecoder(resp.Body).Decode(&index)
+	})
+	switch StatusCode(err) {
+	case 0:
+		if err != nil {
+			return nil, err
+		}
+		return index.Manifests, nil
+	case http.StatusNotFound, http.StatusBadRequest, http.StatusMethodNotAllowed:
+	default:
+		return nil, err
+	}
+
+	m, err := r.GetManifest(ctx, repo, strings.Replace(digest, ":", "-", 1))
+	if IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(m.Body, &index); err != nil {
+		return nil, err
+	}
+	return index.Manifests, nil
+}
@@ -0,0 +1,650 @@
+package registry
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+)
+
+var tracer = otel.Tracer("github.com/loginoff/docker-regclient/pkg/registry")
+
+// DockerRegistry is a client of one registry. It is safe for concurrent use
+// by multiple goroutines once configured: the Set*, On* and EnableCache
+// methods must be called before the client is shared. The state changing
+// while requests are made (tokens, cache, rate limits, bandwidth limiter and
+// circuit breaker) is synchronized, so worker pools can share one client and
+// its tokens.
+type DockerRegistry struct {
+	URL       string
+	client    http.Client
+	onrequest func(RequestStats)
+	onwarning func(Warning)
+	bandwidth *bandwidthLimiter
+	scope     string
+	ratelimit rateLimitState
+	breaker   circuitBreaker
+	cache     *requestCache
+	pageSize  int
+	walkers   int
+	protected []string
+	accept    string
+	readonly  bool
+
+	flavor      Flavor
+	gitlabURL   string
+	gitlabToken string
+
+	requestIDHeader string
+	logger          *log.Logger
+	limiter         *rate.Limiter
+	retries         int
+	timeout         time.Duration
+}
+
+// RequestStats describes a finished request to the Registry API
+type RequestStats struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Duration   time.Duration
+	Err        error
+	//RequestID identifies the request in error messages and, with
+	//SetRequestIDHeader, in the logs of the registry
+	RequestID string
+}
+
+type RegistryErrorResponse struct {
+	StatusCode int    `json:"-"`
+	RequestID  string `json:"-"`
+	Errors     []struct {
+		Code    string
+		Message string
+	}
+}
+
+// StatusError is returned when the registry answers with an error status and
+// a body which is not a registry error response (eg for HEAD requests)
+type StatusError struct {
+	StatusCode int
+	RequestID  string
+}
+
+func (e StatusError) Error() string {
+	return with_request_id(fmt.Sprintf("ERROR: Unable to parse JSON for HTTP status code %d", e.StatusCode), e.RequestID)
+}
+
+// StatusCode returns the HTTP status code of an error response from the
+// registry, or 0 if err isn't one
+func StatusCode(err error) int {
+	switch e := err.(type) {
+	case StatusError:
+		return e.StatusCode
+	case RegistryErrorResponse:
+		return e.StatusCode
+	}
+	return 0
+}
+
+// IsNotFound reports whether err was caused by a 404 response
+func IsNotFound(err error) bool {
+	return StatusCode(err) == http.StatusNotFound
+}
+
+func (re RegistryErrorResponse) Error() string {
+	var s string
+	for _, err := range re.Errors {
+		s = fmt.Sprintf("%s%s - %s", s, err.Code, err.Message)
+	}
+	return with_request_id(s, re.RequestID)
+}
+
+type DockerImage struct {
+	Name          string
+	Tag           string
+	ContentDigest string
+	Created       time.Time
+	//Size is the sum of the config and layer sizes. Layers shared with other
+	//images are counted in full
+	Size         int64
+	OS           string
+	Architecture string
+	Variant      string
+	//MediaType of the manifest ContentDigest refers to
+	MediaType   string
+	Labels      map[string]string
+	Annotations map[string]string
+	//Author is the author recorded in the image config, set by the
+	//deprecated MAINTAINER instruction or by docker commit --author
+	Author string
+	//Children are the per-platform manifests of a manifest list or index.
+	//Deleting the image deletes the index, for every platform at once
+	Children []IndexEntry
+}
+
+// Platform returns the platform of the image in the os/arch[/variant] form
+// used by docker, eg linux/arm/v6
+func (img *DockerImage) Platform() string {
+	p := img.OS + "/" + img.Architecture
+	if img.Variant != "" {
+		p += "/" + img.Variant
+	}
+	return p
+}
+
+// Platforms returns the platforms of the manifests in a manifest list or
+// index, leaving out attestations and other entries without a platform
+func (img *DockerImage) Platforms() []string {
+	var platforms []string
+	for _, child := range img.Children {
+		if child.Platform == nil || child.Platform.OS == "unknown" {
+			continue
+		}
+		p := child.Platform.OS + "/" + child.Platform.Architecture
+		if child.Platform.Variant != "" {
+			p += "/" + child.Platform.Variant
+		}
+		platforms = append(platforms, p)
+	}
+	return platforms
+}
+
+// Descriptor references a blob by digest, as used in schema2 and OCI manifests
+type Descriptor struct {
+	MediaType string `json:"mediaType"`
+	Size      int64  `json:"size"`
+	Digest    string `json:"digest"`
+}
+
+type manifestV2 struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+type Repolist struct {
+	Repositories []string
+}
+
+type Taglist struct {
+	Tags []string
+}
+
+//This function will parse the http.Response body
+//and send the result using a clojure
+type parsefunc func(b *http.Response) error
+
+//This function makes the actual request to the Registry API and does all
+//the error handling
+func (r *DockerRegistry) do_api_request(req *http.Request, pfunc parsefunc) error {
+	return r.do_request(req, pfunc, false)
+}
+
+// do_request is do_api_request, reading successful response bodies as a
+// whole within the retries if buffered is set
+func (r *DockerRegistry) do_request(req *http.Request, pfunc parsefunc, buffered bool) (err error) {
+	if r.cache != nil {
+		if resp := r.cache.get(req); resp != nil {
+			return pfunc(resp)
+		}
+		//The delete permission probe can't change anything, whatever the
+		//registry answers
+		if req.Method != "GET" && req.Method != "HEAD" && !is_probe(req) {
+			r.cache.clear()
+		}
+	}
+
+	if err := r.breaker.check(); err != nil {
+		return err
+	}
+	if err := r.limiter.Wait(req.Context()); err != nil {
+		return err
+	}
+
+	id := new_request_id()
+	ctx, span := tracer.Start(req.Context(), fmt.Sprintf("registry %s", req.Method),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("url.full", req.URL.String()),
+			attribute.String("http.request.id", id),
+		))
+	start := time.Now()
+	status := 0
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+		if r.onrequest != nil {
+			r.onrequest(RequestStats{req.Method, req.URL.String(), status, time.Since(start), err, id})
+		}
+	}()
+	req = req.WithContext(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	if r.requestIDHeader != "" {
+		req.Header.Set(r.requestIDHeader, id)
+	}
+
+	send := r.send
+	if buffered {
+		send = r.send_buffered
+	}
+	resp, err := with_retries(req, r.retries, send)
+	if err != nil {
+		r.breaker.record(err)
+		return fmt.Errorf("%w (request %s)", err, id)
+	}
+	defer resp.Body.Close()
+	status = resp.StatusCode
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	r.record_ratelimit(resp)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		decoder := json.NewDecoder(resp.Body)
+		var regerr RegistryErrorResponse
+		if decoder.Decode(&regerr) != nil {
+			err = StatusError{resp.StatusCode, id}
+		} else {
+			regerr.StatusCode = resp.StatusCode
+			regerr.RequestID = id
+			err = regerr
+		}
+		r.breaker.record(err)
+		return err
+	}
+	r.breaker.record(nil)
+	if err := check_not_html(resp); err != nil {
+		return err
+	}
+
+	if r.cache != nil {
+		if resp, err = r.cache.put(req, resp); err != nil {
+			return err
+		}
+	}
+	return pfunc(resp)
+}
+
+// ErrNotRegistry is returned when a URL answers with something other than
+// the Registry API, typically the login page of a misconfigured proxy
+var ErrNotRegistry = errors.New("Not a registry v2 endpoint")
+
+// check_not_html fails for HTML responses, which no Registry API endpoint
+// returns, even if they come with a success status
+func check_not_html(resp *http.Response) error {
+	if strings.HasPrefix(content_type(resp), "text/html") {
+		return fmt.Errorf("%w: %s returned an HTML page, check the URL and any proxy in front of the registry", ErrNotRegistry, resp.Request.URL)
+	}
+	return nil
+}
+
+// ErrReadOnly is returned for every request that could modify the registry
+// when the client is in read-only mode
+var ErrReadOnly = errors.New("Refusing to modify the registry in read-only mode")
+
+// SetReadOnly makes every request other than GET and HEAD fail before it is
+// sent, so the registry can't be modified by accident
+func (r *DockerRegistry) SetReadOnly(readonly bool) {
+	r.readonly = readonly
+}
+
+// ReadOnly reports whether the client refuses to modify the registry
+func (r *DockerRegistry) ReadOnly() bool {
+	return r.readonly
+}
+
+// OnRequest registers a callback which is invoked after every API request,
+// for example to feed request counts and latencies into a metrics system
+func (r *DockerRegistry) OnRequest(f func(RequestStats)) {
+	r.onrequest = f
+}
+
+// new_request builds a request against the registry bound to ctx. Spans
+// created for API calls become children of any span carried by ctx.
+func (r *DockerRegistry) new_request(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	if err := r.check_scope(url); err != nil {
+		return nil, err
+	}
+	if r.readonly && method != "GET" && method != "HEAD" {
+		return nil, fmt.Errorf("%w (%s %s)", ErrReadOnly, method, url)
+	}
+	return http.NewRequestWithContext(ctx, method, url, body)
+}
+
+func (r *DockerRegistry) Repos(ctx context.Context) ([]string, error) {
+	var repos []string
+	err := r.EachRepo(ctx, func(repo string) error {
+		repos = append(repos, repo)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return repos, nil
+}
+
+// EachRepo calls fn with every repository of the catalog in scope as the
+// catalog is read page by page, so the catalog is never held in memory.
+// The next page is only requested once fn returned for the current one.
+// It stops at the first error of fn, which is returned.
+func (r *DockerRegistry) EachRepo(ctx context.Context, fn func(repo string) error) error {
+	return r.each_page(ctx, r.URL+"_catalog", "catalog", decode_catalog, func(page []string) error {
+		for _, repo := range page {
+			if !r.InScope(repo) {
+				continue
+			}
+			if err := fn(repo); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func decode_catalog(resp *http.Response) ([]string, error) {
+	var rl Repolist
+	decoder := json.NewDecoder(resp.Body)
+	err := decoder.Decode(&rl)
+	return rl.Repositories, err
+}
+
+// Tags lists the tags of repo. PinsTag isn't listed, the pins artifact is
+// bookkeeping of the client rather than an image.
+func (r *DockerRegistry) Tags(ctx context.Context, repo string) ([]string, error) {
+	return r.list_pages(ctx, fmt.Sprintf("%s%s/tags/list", r.URL, repo), repo, func(resp *http.Response) ([]string, error) {
+		var tags Taglist
+		decoder := json.NewDecoder(resp.Body)
+		err := decoder.Decode(&tags)
+		listed := tags.Tags[:0]
+		for _, tag := range tags.Tags {
+			if tag != PinsTag {
+				listed = append(listed, tag)
+			}
+		}
+		return listed, err
+	})
+}
+
+// ParseReference separates an image string of the form repository:tag into
+// repository and tag, the tag defaults to "latest". Images can also be
+// referenced by digest as repository@sha256:..., in which case the digest is
+// returned as the tag.
+func ParseReference(image string) (repo, tag string, err error) {
+	if i := strings.Index(image, "@"); i >= 0 {
+		return image[:i], image[i+1:], nil
+	}
+	parts := strings.Split(image, ":")
+	if len(parts) == 2 {
+		return parts[0], parts[1], nil
+	} else if len(parts) == 1 {
+		return parts[0], "latest", nil
+	}
+	return "", "", errors.New("Image must be in the form 'repository:tag'")
+}
+
+// imageConfig holds the fields of an image config we are interested in. The
+// v1Compatibility entries of schema1 manifests have the same layout.
+type imageConfig struct {
+	Created      time.Time `json:"created"`
+	OS           string    `json:"os"`
+	Architecture string    `json:"architecture"`
+	Variant      string    `json:"variant"`
+	Author       string    `json:"author"`
+	Config       struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"config"`
+}
+
+func (r *DockerRegistry) ImageDetails(ctx context.Context, image string) (*DockerImage, error) {
+	repo, tag, err := ParseReference(image)
+	if err != nil {
+		return nil, err
+	}
+
+	//We request the manifest in the format it was pushed in, which gives us
+	//the "correct" Content-Digest we can use for deleting the image
+	//https://github.com/docker/distribution/issues/1755
+	m, err := r.GetManifest(ctx, repo, tag)
+	if err != nil {
+		return nil, err
+	}
+	img := &DockerImage{Name: repo, Tag: tag, ContentDigest: m.Digest, MediaType: m.MediaType}
+	if img.Annotations, err = m.Annotations(); err != nil {
+		return nil, err
+	}
+	if MediaTypeKind(m.MediaType) != "schema1" {
+		var m2 manifestV2
+		if err := json.Unmarshal(m.Body, &m2); err != nil {
+			return nil, err
+		}
+		img.Size = m2.Config.Size
+		for _, layer := range m2.Layers {
+			img.Size += layer.Size
+		}
+	}
+
+	//Manifest lists and indexes have no config of their own, so the
+	//creation time and platform are taken from the first image they contain
+	if MediaTypeKind(m.MediaType) == "index" {
+		if img.Children, err = m.Children(); err != nil {
+			return nil, err
+		}
+		if len(img.Children) == 0 {
+			return nil, fmt.Errorf("Manifest list %s:%s is empty", repo, tag)
+		}
+		if m, err = r.GetManifest(ctx, repo, img.Children[0].Digest); err != nil {
+			return nil, err
+		}
+	}
+
+	//The creation time, platform and labels come from the image config,
+	//which is embedded in the top history entry of schema1 manifests.
+	//Artifacts like signatures or helm charts have configs in formats of
+	//their own, which are not read
+	if is_image_config(m) {
+		content, err := r.ImageConfig(ctx, repo, m)
+		if err != nil {
+			return nil, err
+		}
+		var config imageConfig
+		if err := json.Unmarshal(content, &config); err != nil {
+			return nil, err
+		}
+		img.Created = config.Created
+		img.OS = config.OS
+		img.Architecture = config.Architecture
+		img.Variant = config.Variant
+		img.Labels = config.Config.Labels
+		img.Author = config.Author
+	}
+	if img.Created.IsZero() {
+		img.Created, _ = time.Parse(time.RFC3339, img.Annotations[AnnotationCreated])
+	}
+	if img.Created.IsZero() {
+		r.warn(WarningMissingCreated, repo+":"+tag, "the image config has no creation time")
+	}
+	return img, nil
+}
+
+// DeleteTag removes only the tag, leaving the manifest and other tags
+// pointing at it in place. Only GCR and Quay support this through the
+// Registry API.
+func (r *DockerRegistry) DeleteTag(ctx context.Context, repo, tag string) error {
+	if !r.delete_tag_first() {
+		return fmt.Errorf("The registry can only delete manifests, which removes every tag pointing at them")
+	}
+	if r.Protected(tag) {
+		return fmt.Errorf("%w (%s:%s)", ErrProtected, repo, tag)
+	}
+	req, err := r.new_request(ctx, "DELETE", fmt.Sprintf("%s%s/manifests/%s", r.URL, repo, tag), nil)
+	if err != nil {
+		return err
+	}
+	err = r.do_api_request(req, func(r *http.Response) error {
+		return nil
+	})
+	return r.immutable_error(ctx, &DockerImage{Name: repo, Tag: tag}, err)
+}
+
+func (r *DockerRegistry) DeleteImage(ctx context.Context, img *DockerImage) error {
+	if r.Flavor() == FlavorECR {
+		return fmt.Errorf("ECR doesn't support deleting through the Registry API, use aws ecr batch-delete-image")
+	}
+	if img.ContentDigest == "" {
+		return fmt.Errorf("No digest known for %s:%s, refusing to delete", img.Name, img.Tag)
+	}
+	if err := r.check_protected(ctx, img); err != nil {
+		return err
+	}
+	if r.delete_tag_first() && img.Tag != "" {
+		if err := r.DeleteTag(ctx, img.Name, img.Tag); err != nil && !IsNotFound(err) {
+			return r.immutable_error(ctx, img, err)
+		}
+	}
+	req, err := r.new_request(ctx, "DELETE", fmt.Sprintf("%s%s/manifests/%s", r.URL, img.Name, img.ContentDigest), nil)
+	if err != nil {
+		return err
+	}
+	err = r.do_api_request(req, func(r *http.Response) error {
+		return nil
+	})
+	return r.immutable_error(ctx, img, err)
+}
+
+// APIURL returns the URL of the Registry API of the registry at url, as
+// the URL of its DockerRegistry holds it
+func APIURL(url string) string {
+	if strings.HasSuffix(url, "/") {
+		return url + "v2/"
+	}
+	return url + "/v2/"
+}
+
+// NewDockerRegistry connects to the registry at url, eg
+// https://my.registry.com:5000, configured by opts. The connection and any
+// credentials are checked right away.
+func NewDockerRegistry(url string, opts ...Option) (*DockerRegistry, error) {
+	o := options{verify: true, timeout: 30 * time.Second}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	url = APIURL(url)
+
+	transport := o.transport
+	if transport == nil {
+		transport = http.DefaultTransport
+		if !o.verify {
+			transport = &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			}
+		}
+	}
+
+	r := DockerRegistry{
+		URL:     url,
+		logger:  o.logger,
+		limiter: new_request_limiter(o.rate),
+		retries: o.retries,
+		timeout: o.timeout,
+	}
+	if r.logger == nil {
+		r.logger = log.Default()
+	}
+	parsed, err := neturl.Parse(url)
+	if err != nil {
+		return nil, err
+	}
+	r.client.Transport = &authTransport{
+		base:      transport,
+		host:      parsed.Host,
+		username:  o.username,
+		password:  o.password,
+		userAgent: o.userAgent,
+		retries:   o.retries,
+		tokens:    make(map[string]bearerToken),
+		fetching:  make(map[string]*sync.Mutex),
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.send(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := check_not_html(resp); err != nil {
+		return nil, err
+	}
+	r.flavor = detect_flavor(resp)
+	if err := r.check_ping(resp); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// NewDockerRegistryWithAuth connects to the registry with credentials.
+//
+// Deprecated: use NewDockerRegistry with WithTLSVerify and WithCredentials.
+func NewDockerRegistryWithAuth(url string, verify_ssl bool, username, password string) (*DockerRegistry, error) {
+	return NewDockerRegistry(url, WithTLSVerify(verify_ssl), WithCredentials(username, password))
+}
+
+// ErrAuthentication is returned when the registry or its token server
+// rejects the credentials, or requires some and none were given
+var ErrAuthentication = errors.New("Authentication failed")
+
+// check_ping validates the answer to the /v2/ ping. A token based registry
+// challenges every client, if credentials were given they are verified
+// against its token server right away.
+func (r *DockerRegistry) check_ping(resp *http.Response) error {
+	auth := r.client.Transport.(*authTransport)
+	switch {
+	case resp.StatusCode/100 == 2:
+		return nil
+	case resp.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("%w: %s returned 404, check the URL", ErrNotRegistry, r.URL)
+	case resp.StatusCode != http.StatusUnauthorized:
+		return fmt.Errorf("%s returned HTTP %d", r.URL, resp.StatusCode)
+	}
+
+	scheme, params := parse_challenge(resp.Header.Get("WWW-Authenticate"))
+	if !strings.EqualFold(scheme, "Bearer") {
+		if auth.username == "" {
+			return fmt.Errorf("%w: %s requires credentials", ErrAuthentication, r.URL)
+		}
+		return fmt.Errorf("%w: %s rejected the credentials of %s", ErrAuthentication, r.URL, auth.username)
+	}
+	//Anonymous clients get tokens for public repositories only, which is
+	//checked by the requests that need them
+	if auth.username == "" {
+		return nil
+	}
+	if params["realm"] == "" {
+		return fmt.Errorf("%w: %s sent a token challenge without a realm", ErrAuthentication, r.URL)
+	}
+	if _, err := auth.fetch_token(resp.Request, params); err != nil {
+		return fmt.Errorf("%w: the token server of %s rejected the credentials of %s: %v", ErrAuthentication, r.URL, auth.username, err)
+	}
+	return nil
+}
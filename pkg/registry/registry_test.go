@@ -0,0 +1,207 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRetryTooManyRequests(t *testing.T) {
+	f := new_fake_registry(t)
+	digest := f.push("app", "1.0")
+	f.fail("GET", "/app/manifests/1.0", 2, status_fault(http.StatusTooManyRequests, "0"))
+	r := f.connect(t, WithRetries(3))
+
+	m, err := r.GetManifest(context.Background(), "app", "1.0")
+	if err != nil {
+		t.Fatalf("GetManifest failed despite retries: %v", err)
+	}
+	if m.Digest != digest {
+		t.Errorf("Got digest %s, want %s", m.Digest, digest)
+	}
+	if n := f.count("GET", "/app/manifests/1.0"); n != 3 {
+		t.Errorf("Got %d attempts, want 3", n)
+	}
+}
+
+func TestRetryExhausted(t *testing.T) {
+	f := new_fake_registry(t)
+	f.push("app", "1.0")
+	f.fail("GET", "/app/tags/list", -1, status_fault(http.StatusServiceUnavailable, "0"))
+	r := f.connect(t, WithRetries(2))
+
+	_, err := r.Tags(context.Background(), "app")
+	if StatusCode(err) != http.StatusServiceUnavailable {
+		t.Fatalf("Got %v, want the 503 of the last attempt", err)
+	}
+	if n := f.count("GET", "/app/tags/list"); n != 3 {
+		t.Errorf("Got %d attempts, want 3", n)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	f := new_fake_registry(t)
+	f.push("app", "1.0")
+	f.fail("GET", "/app/tags/list", 1, status_fault(http.StatusBadGateway, ""))
+	r := f.connect(t, WithRetries(1))
+
+	start := time.Now()
+	if _, err := r.Tags(context.Background(), "app"); err != nil {
+		t.Fatalf("Tags failed despite retries: %v", err)
+	}
+	//Without Retry-After the first retry waits between half and all of retryBase
+	if elapsed := time.Since(start); elapsed < retryBase/2 {
+		t.Errorf("Retried after %v, want at least %v", elapsed, retryBase/2)
+	}
+}
+
+func TestRetryNetworkError(t *testing.T) {
+	f := new_fake_registry(t)
+	f.push("app", "1.0")
+	f.fail("HEAD", "/app/manifests/1.0", 1, drop_connection)
+	r := f.connect(t, WithRetries(1))
+
+	if _, err := r.ManifestDigest(context.Background(), "app", "1.0"); err != nil {
+		t.Fatalf("ManifestDigest failed despite retries: %v", err)
+	}
+	if n := f.count("HEAD", "/app/manifests/1.0"); n != 2 {
+		t.Errorf("Got %d attempts, want 2", n)
+	}
+}
+
+func TestRetryCanceled(t *testing.T) {
+	f := new_fake_registry(t)
+	f.push("app", "1.0")
+	f.fail("GET", "/app/tags/list", -1, status_fault(http.StatusTooManyRequests, "30"))
+	r := f.connect(t, WithRetries(3))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := r.Tags(ctx, "app")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Got %v, want the deadline of the context", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Waiting for the Retry-After took %v despite the deadline", elapsed)
+	}
+}
+
+func TestNoRetries(t *testing.T) {
+	f := new_fake_registry(t)
+	f.push("app", "1.0")
+	f.fail("GET", "/app/tags/list", 1, status_fault(http.StatusServiceUnavailable, "0"))
+	r := f.connect(t)
+
+	if _, err := r.Tags(context.Background(), "app"); StatusCode(err) != http.StatusServiceUnavailable {
+		t.Fatalf("Got %v, want the 503 without retries", err)
+	}
+	if n := f.count("GET", "/app/tags/list"); n != 1 {
+		t.Errorf("Got %d attempts, want 1", n)
+	}
+}
+
+func TestTruncatedManifest(t *testing.T) {
+	f := new_fake_registry(t)
+	digest := f.push("app", "1.0")
+	f.fail("GET", "/app/manifests/1.0", 1, truncate_body)
+	r := f.connect(t, WithRetries(1))
+
+	m, err := r.GetManifest(context.Background(), "app", "1.0")
+	if err != nil {
+		t.Fatalf("GetManifest failed despite retries: %v", err)
+	}
+	if m.Digest != digest {
+		t.Errorf("Got digest %s, want %s", m.Digest, digest)
+	}
+	if n := f.count("GET", "/app/manifests/1.0"); n != 2 {
+		t.Errorf("Got %d attempts, want 2", n)
+	}
+}
+
+func TestTruncatedManifestNoRetries(t *testing.T) {
+	f := new_fake_registry(t)
+	f.push("app", "1.0")
+	f.fail("GET", "/app/manifests/1.0", 1, truncate_body)
+	r := f.connect(t)
+
+	if _, err := r.GetManifest(context.Background(), "app", "1.0"); err == nil {
+		t.Fatal("GetManifest succeeded with a truncated body")
+	}
+}
+
+func TestTruncatedManifestRetriesOnce(t *testing.T) {
+	f := new_fake_registry(t)
+	f.push("app", "1.0")
+	f.fail("GET", "/app/manifests/1.0", -1, truncate_body)
+	r := f.connect(t, WithRetries(2))
+	r.SetCircuitBreaker(2)
+
+	for i := 0; i < 2; i++ {
+		if _, err := r.GetManifest(context.Background(), "app", "1.0"); err == nil {
+			t.Fatal("GetManifest succeeded with a truncated body")
+		}
+	}
+	//Body failures go through the retries of the response, not a second
+	//loop around them
+	if n := f.count("GET", "/app/manifests/1.0"); n != 6 {
+		t.Errorf("Got %d attempts, want 6", n)
+	}
+	if err := r.CircuitOpen(); err == nil {
+		t.Error("Truncated bodies counted as successes by the circuit breaker")
+	}
+}
+
+func TestStalledBodyRetried(t *testing.T) {
+	f := new_fake_registry(t)
+	f.push("app", "1.0")
+	stalled := make(chan struct{})
+	f.fail("GET", "/app/tags/list", 1, func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, `{"tags":[`)
+		w.(http.Flusher).Flush()
+		select {
+		case <-req.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+		close(stalled)
+	})
+	r := f.connect(t, WithRetries(1), WithTimeout(200*time.Millisecond))
+
+	tags, err := r.Tags(context.Background(), "app")
+	if err != nil {
+		t.Fatalf("Tags failed despite retries: %v", err)
+	}
+	if len(tags) != 1 {
+		t.Errorf("Got tags %v, want 1.0", tags)
+	}
+	<-stalled
+}
+
+func TestMissingDigest(t *testing.T) {
+	f := new_fake_registry(t)
+	digest := f.push("app", "1.0")
+	body := f.manifests["app"]["1.0"]
+	f.fail("GET", "/app/manifests/1.0", 1, func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", MediaTypeSchema2)
+		w.Write(body)
+	})
+	r := f.connect(t)
+	var warnings []Warning
+	r.OnWarning(func(w Warning) { warnings = append(warnings, w) })
+
+	m, err := r.GetManifest(context.Background(), "app", "1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Digest != digest {
+		t.Errorf("Computed digest %s, want %s", m.Digest, digest)
+	}
+	if len(warnings) != 1 || warnings[0].Kind != WarningMissingDigest {
+		t.Errorf("Got warnings %v, want one about the missing digest", warnings)
+	}
+}
@@ -0,0 +1,31 @@
+package registry
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+)
+
+// SetRequestIDHeader sends the ID of every request in the given header (eg
+// X-Request-ID), so failures can be found in the logs of the registry. An
+// empty name doesn't send it.
+func (r *DockerRegistry) SetRequestIDHeader(name string) {
+	r.requestIDHeader = name
+}
+
+// new_request_id returns a short random ID identifying one API request
+func new_request_id() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// with_request_id appends the request ID to an error message
+func with_request_id(msg, id string) string {
+	if id == "" {
+		return msg
+	}
+	return fmt.Sprintf("%s (request %s)", msg, id)
+}
@@ -0,0 +1,117 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	//retryBase is the delay before the first retry, doubled for every
+	//further one
+	retryBase = 500 * time.Millisecond
+	//retryMax caps the delay between two attempts, including delays asked
+	//for with Retry-After
+	retryMax = time.Minute
+)
+
+// WithRetries retries requests failing with a network error, 429 or a 5xx
+// status up to retries times, with exponential backoff and jitter. A
+// Retry-After header sent by the registry or token server is honored.
+// Requests that are not idempotent (POST, PATCH) are only retried when the
+// registry refused them with 429 or 503 and a Retry-After header, as they
+// may have had an effect otherwise. Requests whose body can't be sent again
+// (blob uploads) are not retried.
+func WithRetries(retries int) Option {
+	return func(o *options) { o.retries = retries }
+}
+
+// idempotent reports whether sending a request of method again has the same
+// effect as sending it once
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "PUT", "DELETE", "OPTIONS":
+		return true
+	}
+	return false
+}
+
+// retryable reports whether an attempt of a request of method failed in a
+// way worth retrying
+func retryable(method string, resp *http.Response, err error) bool {
+	if err != nil {
+		return idempotent(method) && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	if !idempotent(method) {
+		//The registry tells it didn't process the request
+		return (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) && resp.Header.Get("Retry-After") != ""
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// retry_delay returns how long to wait before the given retry (starting at
+// 0): the Retry-After of resp if it has one, otherwise a random delay
+// between half and all of an exponentially growing backoff
+func retry_delay(retry int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if after := resp.Header.Get("Retry-After"); after != "" {
+			if secs, err := strconv.Atoi(after); err == nil {
+				return cap_delay(time.Duration(secs) * time.Second)
+			}
+			if t, err := http.ParseTime(after); err == nil {
+				return cap_delay(time.Until(t))
+			}
+		}
+	}
+	backoff := retryMax
+	if retry < 16 {
+		backoff = cap_delay(retryBase << retry)
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// cap_delay bounds a delay to [0, retryMax]
+func cap_delay(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	if d > retryMax {
+		return retryMax
+	}
+	return d
+}
+
+// with_retries sends req with send, retrying up to retries times. The
+// response of the last attempt is returned.
+func with_retries(req *http.Request, retries int, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	for retry := 0; ; retry++ {
+		resp, err := send(req)
+		if retry >= retries || !retryable(req.Method, resp, err) || (req.Body != nil && req.GetBody == nil) {
+			return resp, err
+		}
+		delay := retry_delay(retry, resp)
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
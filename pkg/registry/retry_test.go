@@ -0,0 +1,121 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func response(status int, retryAfter string) *http.Response {
+	resp := &http.Response{StatusCode: status, Header: http.Header{}}
+	if retryAfter != "" {
+		resp.Header.Set("Retry-After", retryAfter)
+	}
+	return resp
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		method string
+		resp   *http.Response
+		err    error
+		want   bool
+	}{
+		{"GET", nil, errors.New("connection reset"), true},
+		{"GET", nil, context.Canceled, false},
+		{"HEAD", nil, context.DeadlineExceeded, false},
+		{"GET", response(502, ""), nil, true},
+		{"DELETE", response(500, ""), nil, true},
+		{"PUT", response(429, ""), nil, true},
+		{"GET", response(404, ""), nil, false},
+		{"GET", response(401, ""), nil, false},
+		//Non-idempotent requests only when the registry tells it didn't
+		//process them
+		{"POST", nil, errors.New("connection reset"), false},
+		{"POST", response(500, ""), nil, false},
+		{"POST", response(503, ""), nil, false},
+		{"POST", response(503, "5"), nil, true},
+		{"PATCH", response(429, "1"), nil, true},
+		{"POST", response(502, "1"), nil, false},
+	}
+	for _, test := range tests {
+		if got := retryable(test.method, test.resp, test.err); got != test.want {
+			status := 0
+			if test.resp != nil {
+				status = test.resp.StatusCode
+			}
+			t.Errorf("retryable(%s, %d, %v) = %v, want %v", test.method, status, test.err, got, test.want)
+		}
+	}
+}
+
+func TestRetryDelayRetryAfter(t *testing.T) {
+	if d := retry_delay(0, response(429, "7")); d != 7*time.Second {
+		t.Errorf("Retry-After in seconds gave %v, want 7s", d)
+	}
+	if d := retry_delay(0, response(429, "3600")); d != retryMax {
+		t.Errorf("Retry-After beyond retryMax gave %v, want %v", d, retryMax)
+	}
+	date := time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat)
+	if d := retry_delay(0, response(503, date)); d < 8*time.Second || d > 10*time.Second {
+		t.Errorf("Retry-After as a date 10s ahead gave %v", d)
+	}
+	past := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	if d := retry_delay(0, response(503, past)); d != 0 {
+		t.Errorf("Retry-After in the past gave %v, want 0", d)
+	}
+}
+
+func TestRetryDelayBackoff(t *testing.T) {
+	for retry := 0; retry < 20; retry++ {
+		backoff := retryMax
+		if retry < 16 && retryBase<<retry < retryMax {
+			backoff = retryBase << retry
+		}
+		for i := 0; i < 50; i++ {
+			d := retry_delay(retry, nil)
+			if d < backoff/2 || d > backoff {
+				t.Fatalf("Retry %d waits %v, want between %v and %v", retry, d, backoff/2, backoff)
+			}
+		}
+	}
+	//An unparsable Retry-After falls back to the backoff
+	if d := retry_delay(1, response(429, "soon")); d < retryBase || d > 2*retryBase {
+		t.Errorf("Invalid Retry-After gave %v", d)
+	}
+}
+
+func TestNonIdempotentNotRetried(t *testing.T) {
+	f := new_fake_registry(t)
+	f.fail("POST", "/app/blobs/uploads/", 1, status_fault(http.StatusInternalServerError, ""))
+	r := f.connect(t, WithRetries(3))
+
+	req, _ := r.new_request(context.Background(), "POST", r.URL+"app/blobs/uploads/", strings.NewReader(""))
+	err := r.do_api_request(req, func(*http.Response) error { return nil })
+	if StatusCode(err) != http.StatusInternalServerError {
+		t.Fatalf("Got %v, want the 500 of the only attempt", err)
+	}
+	if n := f.count("POST", "/app/blobs/uploads/"); n != 1 {
+		t.Errorf("Got %d attempts of a POST, want 1", n)
+	}
+}
+
+func TestNonIdempotentRetryAfter(t *testing.T) {
+	f := new_fake_registry(t)
+	f.fail("POST", "/app/blobs/uploads/", 1, status_fault(http.StatusServiceUnavailable, "0"))
+	f.fail("POST", "/app/blobs/uploads/", 1, func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+	r := f.connect(t, WithRetries(3))
+
+	req, _ := r.new_request(context.Background(), "POST", r.URL+"app/blobs/uploads/", strings.NewReader(""))
+	if err := r.do_api_request(req, func(*http.Response) error { return nil }); err != nil {
+		t.Fatalf("POST refused with Retry-After was not retried: %v", err)
+	}
+	if n := f.count("POST", "/app/blobs/uploads/"); n != 2 {
+		t.Errorf("Got %d attempts, want 2", n)
+	}
+}
@@ -0,0 +1,208 @@
+package registry
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	MediaTypeImageConfig = "application/vnd.docker.container.image.v1+json"
+	MediaTypeLayer       = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+)
+
+type schema1Manifest struct {
+	Name     string `json:"name"`
+	Tag      string `json:"tag"`
+	FSLayers []struct {
+		BlobSum string `json:"blobSum"`
+	} `json:"fsLayers"`
+	History []struct {
+		V1Compatibility string `json:"v1Compatibility"`
+	} `json:"history"`
+}
+
+type v1Compatibility struct {
+	Created         time.Time `json:"created"`
+	Author          string    `json:"author"`
+	Comment         string    `json:"comment"`
+	ThrowAway       bool      `json:"throwaway"`
+	ContainerConfig struct {
+		Cmd []string
+	} `json:"container_config"`
+}
+
+type historyEntry struct {
+	Created    time.Time `json:"created"`
+	CreatedBy  string    `json:"created_by,omitempty"`
+	Author     string    `json:"author,omitempty"`
+	Comment    string    `json:"comment,omitempty"`
+	EmptyLayer bool      `json:"empty_layer,omitempty"`
+}
+
+// Digest returns the sha256 content digest of b as used by the registry
+func Digest(b []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(b))
+}
+
+// schema1_payload returns a signed schema1 manifest without its signatures,
+// which is what its digest is computed from. Every signature records where
+// the signatures start in its protected header, and the closing characters
+// replaced by them.
+func schema1_payload(body []byte) ([]byte, error) {
+	var jws struct {
+		Signatures []struct {
+			Protected string `json:"protected"`
+		} `json:"signatures"`
+	}
+	if err := json.Unmarshal(body, &jws); err != nil {
+		return nil, err
+	}
+	if len(jws.Signatures) == 0 {
+		return nil, fmt.Errorf("Signed schema1 manifest has no signatures")
+	}
+	protected, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(jws.Signatures[0].Protected, "="))
+	if err != nil {
+		return nil, err
+	}
+	var header struct {
+		FormatLength int    `json:"formatLength"`
+		FormatTail   string `json:"formatTail"`
+	}
+	if err := json.Unmarshal(protected, &header); err != nil {
+		return nil, err
+	}
+	tail, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(header.FormatTail, "="))
+	if err != nil {
+		return nil, err
+	}
+	if header.FormatLength <= 0 || header.FormatLength > len(body) {
+		return nil, fmt.Errorf("Signed schema1 manifest has an invalid format length %d", header.FormatLength)
+	}
+	payload := append([]byte{}, body[:header.FormatLength]...)
+	return append(payload, tail...), nil
+}
+
+// layer_diffid downloads a gzipped layer and returns the digest of its
+// uncompressed content together with the compressed size
+func (r *DockerRegistry) layer_diffid(ctx context.Context, repo, digest string) (diffid string, size int64, err error) {
+	err = r.FetchBlob(ctx, repo, digest, func(content io.Reader, _ int64) error {
+		counter := &countingReader{r: content}
+		gz, err := gzip.NewReader(counter)
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, gz); err != nil {
+			return err
+		}
+		//Drain whatever follows the gzip stream so the size is exact
+		if _, err := io.Copy(io.Discard, counter); err != nil {
+			return err
+		}
+		diffid = fmt.Sprintf("sha256:%x", h.Sum(nil))
+		size = counter.n
+		return nil
+	})
+	return diffid, size, err
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ConvertSchema1 converts the schema1 manifest m of repo into an equivalent
+// schema2 manifest. Every layer is downloaded once to compute the
+// uncompressed digests schema2 requires. It returns the new manifest and its
+// config blob, which has to be pushed to repo before the manifest.
+func (r *DockerRegistry) ConvertSchema1(ctx context.Context, repo string, m *Manifest) (*Manifest, []byte, error) {
+	var s1 schema1Manifest
+	if err := json.Unmarshal(m.Body, &s1); err != nil {
+		return nil, nil, err
+	}
+	if len(s1.History) == 0 || len(s1.History) != len(s1.FSLayers) {
+		return nil, nil, fmt.Errorf("Malformed schema1 manifest for %s: %d layers but %d history entries", repo, len(s1.FSLayers), len(s1.History))
+	}
+
+	var config map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(s1.History[0].V1Compatibility), &config); err != nil {
+		return nil, nil, err
+	}
+	for _, key := range []string{"id", "parent", "Size", "parent_id", "layer_id", "throwaway"} {
+		delete(config, key)
+	}
+
+	out := manifestV2{SchemaVersion: 2, MediaType: MediaTypeSchema2}
+	var diffids []string
+	var history []historyEntry
+	type layerinfo struct {
+		diffid string
+		size   int64
+	}
+	seen := make(map[string]layerinfo)
+
+	//schema1 lists layers top-most first, schema2 starts from the base layer
+	for i := len(s1.History) - 1; i >= 0; i-- {
+		var compat v1Compatibility
+		if err := json.Unmarshal([]byte(s1.History[i].V1Compatibility), &compat); err != nil {
+			return nil, nil, err
+		}
+		entry := historyEntry{
+			Created:    compat.Created,
+			CreatedBy:  strings.Join(compat.ContainerConfig.Cmd, " "),
+			Author:     compat.Author,
+			Comment:    compat.Comment,
+			EmptyLayer: compat.ThrowAway,
+		}
+		history = append(history, entry)
+		if compat.ThrowAway {
+			continue
+		}
+
+		blobsum := s1.FSLayers[i].BlobSum
+		info, ok := seen[blobsum]
+		if !ok {
+			diffid, size, err := r.layer_diffid(ctx, repo, blobsum)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Unable to read layer %s: %v", blobsum, err)
+			}
+			info = layerinfo{diffid, size}
+			seen[blobsum] = info
+		}
+		diffids = append(diffids, info.diffid)
+		out.Layers = append(out.Layers, Descriptor{MediaType: MediaTypeLayer, Size: info.size, Digest: blobsum})
+	}
+
+	rootfs, err := json.Marshal(map[string]interface{}{"type": "layers", "diff_ids": diffids})
+	if err != nil {
+		return nil, nil, err
+	}
+	config["rootfs"] = rootfs
+	if config["history"], err = json.Marshal(history); err != nil {
+		return nil, nil, err
+	}
+	configblob, err := json.Marshal(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out.Config = Descriptor{MediaType: MediaTypeImageConfig, Size: int64(len(configblob)), Digest: Digest(configblob)}
+	body, err := json.MarshalIndent(out, "", "   ")
+	if err != nil {
+		return nil, nil, err
+	}
+	return &Manifest{MediaType: MediaTypeSchema2, Digest: Digest(body), Body: body}, configblob, nil
+}
@@ -0,0 +1,57 @@
+package registry
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// SetScope restricts the client to repositories below namespace. The catalog
+// only lists those repositories and any request for a repository outside of
+// the namespace fails before it is sent.
+func (r *DockerRegistry) SetScope(namespace string) {
+	namespace = strings.Trim(namespace, "/")
+	if namespace == "" {
+		r.scope = ""
+		return
+	}
+	r.scope = namespace + "/"
+}
+
+// repoName is the repository name grammar of the distribution spec. Names
+// with empty, "." or ".." components don't match, so a registry or proxy
+// normalizing the path can't be led out of the scope.
+var repoName = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+
+// InScope reports whether repo may be accessed with the configured scope
+func (r *DockerRegistry) InScope(repo string) bool {
+	if r.scope == "" {
+		return true
+	}
+	return repoName.MatchString(repo) && strings.HasPrefix(repo, r.scope)
+}
+
+// check_scope refuses Registry API URLs of repositories outside of the scope.
+// URLs not below the registry (eg vendor APIs) are checked by their callers.
+func (r *DockerRegistry) check_scope(url string) error {
+	if r.scope == "" || !strings.HasPrefix(url, r.URL) {
+		return nil
+	}
+	path := strings.TrimPrefix(url, r.URL)
+	if path == "" || strings.HasPrefix(path, "_catalog") {
+		return nil
+	}
+	repo := path
+	for _, sep := range []string{"/manifests/", "/blobs/", "/tags/", "/referrers/"} {
+		if i := strings.Index(repo, sep); i >= 0 {
+			repo = repo[:i]
+		}
+	}
+	if !repoName.MatchString(repo) {
+		return fmt.Errorf("Refusing to access %s, it is not a valid repository name", repo)
+	}
+	if !strings.HasPrefix(repo, r.scope) {
+		return fmt.Errorf("Refusing to access %s, it is outside of the scope %s", repo, strings.TrimSuffix(r.scope, "/"))
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package registry
+
+import "testing"
+
+func TestCheckScope(t *testing.T) {
+	r := &DockerRegistry{URL: "https://registry.example.com/v2/"}
+	r.SetScope("team-a")
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{"", true},
+		{"_catalog?n=100", true},
+		{"team-a/app/manifests/latest", true},
+		{"team-a/app/tags/list", true},
+		{"team-a/my_app.v2/blobs/uploads/", true},
+		{"team-a/app/referrers/sha256:0", true},
+		{"team-b/app/manifests/latest", false},
+		{"team-ab/app/manifests/latest", false},
+		//Paths a proxy may normalize into another namespace
+		{"team-a/../team-b/app/manifests/latest", false},
+		{"team-a/./app/manifests/latest", false},
+		{"team-a//app/manifests/latest", false},
+		{"team-a/%2e%2e/team-b/manifests/latest", false},
+	}
+	for _, test := range tests {
+		if err := r.check_scope(r.URL + test.path); (err == nil) != test.ok {
+			t.Errorf("check_scope(%s) = %v, want ok %v", test.path, err, test.ok)
+		}
+	}
+}
+
+func TestInScope(t *testing.T) {
+	r := &DockerRegistry{}
+	if !r.InScope("Anything/../goes") {
+		t.Errorf("InScope without a scope refused a repository")
+	}
+	r.SetScope("/team-a/")
+	for repo, want := range map[string]bool{
+		"team-a/app":          true,
+		"team-a/app/nested":   true,
+		"team-a":              false,
+		"team-b/app":          false,
+		"team-a/../team-b":    false,
+		"team-a/app/":         false,
+		"team-a/App":          false,
+		"team-a/app--dev__v1": true,
+	} {
+		if got := r.InScope(repo); got != want {
+			t.Errorf("InScope(%s) = %v, want %v", repo, got, want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// timeoutError aborts a request the registry stopped answering
+type timeoutError struct {
+	timeout time.Duration
+}
+
+func (e timeoutError) Error() string {
+	return fmt.Sprintf("The registry sent nothing for %s", e.timeout)
+}
+
+// Timeout makes timeoutError a net.Error timeout
+func (e timeoutError) Timeout() bool {
+	return true
+}
+
+// send makes a single attempt of req. Instead of limiting the whole request,
+// the timeout limits the wait for the response and every pause while reading
+// its body, so large manifests, indexes with hundreds of entries and blobs
+// take as long as they need on slow links, as long as data keeps coming.
+func (r *DockerRegistry) send(req *http.Request) (*http.Response, error) {
+	if r.timeout <= 0 {
+		return r.client.Do(req)
+	}
+	ctx, cancel := context.WithCancelCause(req.Context())
+	timer := time.AfterFunc(r.timeout, func() { cancel(timeoutError{r.timeout}) })
+	resp, err := r.client.Do(req.WithContext(ctx))
+	if err != nil {
+		timer.Stop()
+		err = timeout_cause(ctx, err)
+		cancel(nil)
+		return nil, err
+	}
+	resp.Body = &idleTimeoutBody{resp.Body, ctx, cancel, timer, r.timeout}
+	return resp, nil
+}
+
+// timeout_cause replaces the cancellation error of a request aborted by the
+// timeout with the timeout, which is retried unlike a cancellation
+func timeout_cause(ctx context.Context, err error) error {
+	var timeout timeoutError
+	if errors.As(context.Cause(ctx), &timeout) {
+		return timeout
+	}
+	return err
+}
+
+// idleTimeoutBody restarts the timeout of a request whenever data arrives
+type idleTimeoutBody struct {
+	body    io.ReadCloser
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+	if err != nil && err != io.EOF {
+		err = timeout_cause(b.ctx, err)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	b.timer.Stop()
+	err := b.body.Close()
+	b.cancel(nil)
+	return err
+}
+
+// send_buffered is send for responses read as a whole, like manifests and
+// listings. The body of a successful response is read before returning, so
+// a failure while reading it, like a stall beyond the timeout or a dropped
+// connection, fails the attempt and is retried like a failed response.
+func (r *DockerRegistry) send_buffered(req *http.Request) (*http.Response, error) {
+	resp, err := r.send(req)
+	if err != nil || resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// do_buffered_request is do_api_request for responses read as a whole.
+// pfunc gets the complete body, and the registry only counts as healthy
+// for the circuit breaker once it was read.
+func (r *DockerRegistry) do_buffered_request(req *http.Request, pfunc parsefunc) error {
+	return r.do_request(req, pfunc, true)
+}
@@ -0,0 +1,95 @@
+package registry
+
+import (
+	"context"
+	"sync"
+)
+
+// defaultWalkers is the number of repositories Walk lists the tags of at
+// once, unless changed with SetWalkConcurrency
+const defaultWalkers = 8
+
+// SetWalkConcurrency sets the number of repositories Walk lists the tags of
+// at once. 0 restores the default of 8.
+func (r *DockerRegistry) SetWalkConcurrency(n int) {
+	r.walkers = n
+}
+
+type walkResult struct {
+	repo string
+	tags []string
+	err  error
+}
+
+// Walk calls fn with the tags of every repository of the catalog in scope.
+// The catalog is read page by page while the tags of several repositories
+// are listed at once, so repositories are passed to fn roughly in catalog
+// order. fn is called by one goroutine at a time, and the walk only reads
+// ahead as far as the workers can buffer, so a slow fn slows the walk down
+// instead of piling up results. Repositories deleted during the walk are
+// skipped. The walk stops at the first error of fn or of the registry, which
+// is returned.
+func (r *DockerRegistry) Walk(ctx context.Context, fn func(repo string, tags []string) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	workers := r.walkers
+	if workers <= 0 {
+		workers = defaultWalkers
+	}
+
+	repos := make(chan string)
+	var catalogErr error
+	go func() {
+		defer close(repos)
+		catalogErr = r.each_page(ctx, r.URL+"_catalog", "catalog", decode_catalog, func(page []string) error {
+			for _, repo := range page {
+				if !r.InScope(repo) {
+					continue
+				}
+				select {
+				case repos <- repo:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return nil
+		})
+	}()
+
+	results := make(chan walkResult, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for repo := range repos {
+				tags, err := r.Tags(ctx, repo)
+				results <- walkResult{repo, tags, err}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var err error
+	for res := range results {
+		switch {
+		case err != nil:
+			//Draining the workers after the walk was cancelled
+		case res.err != nil && IsNotFound(res.err):
+		case res.err != nil:
+			err = res.err
+			cancel()
+		default:
+			if err = fn(res.repo, res.tags); err != nil {
+				cancel()
+			}
+		}
+	}
+	if err == nil {
+		err = catalogErr
+	}
+	return err
+}
@@ -0,0 +1,51 @@
+package registry
+
+import (
+	"fmt"
+)
+
+// WarningKind classifies a non-fatal condition encountered while talking to
+// the registry
+type WarningKind string
+
+const (
+	//WarningMissingCreated is reported for images without a creation time,
+	//which makes age based filters treat them as infinitely old
+	WarningMissingCreated WarningKind = "missing-created"
+	//WarningMissingDigest is reported when the registry did not send the
+	//Docker-Content-Digest header and the digest had to be computed
+	WarningMissingDigest WarningKind = "missing-digest"
+	//WarningTruncated is reported when the pages of a listing could not all
+	//be read, because the registry kept returning the same page
+	WarningTruncated WarningKind = "truncated"
+	//WarningCleanup is reported when superseded data written by the client
+	//could not be removed
+	WarningCleanup WarningKind = "cleanup"
+)
+
+// Warning is a data-quality issue which did not prevent returning a result,
+// but may make the result incomplete or less exact
+type Warning struct {
+	Kind    WarningKind
+	Subject string
+	Message string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%s: %s", w.Subject, w.Message)
+}
+
+// OnWarning registers a callback which receives every warning instead of it
+// being logged, for example to summarize them at the end of a run
+func (r *DockerRegistry) OnWarning(f func(Warning)) {
+	r.onwarning = f
+}
+
+func (r *DockerRegistry) warn(kind WarningKind, subject, format string, args ...interface{}) {
+	w := Warning{kind, subject, fmt.Sprintf(format, args...)}
+	if r.onwarning != nil {
+		r.onwarning(w)
+	} else {
+		r.logger.Printf("WARNING %s", w)
+	}
+}